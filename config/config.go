@@ -5,6 +5,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	"os"
+	"strings"
 )
 
 type Config struct {
@@ -12,15 +13,24 @@ type Config struct {
 	ServerConfig
 	MongoDBConfig
 	WebhookConfig
+	ProviderConfig
 	RabbitMQConfig
+	ReaperConfig
+	DispatcherConfig
+	OutboxConfig
+	TracingConfig
+	AuthConfig
+	RateLimitConfig
 }
 
 type AppConfig struct {
-	AppName string
+	AppName    string
+	InstanceID string
 }
 
 type ServerConfig struct {
-	Port int
+	Port                   int
+	ShutdownTimeoutSeconds int
 }
 
 type MongoDBConfig struct {
@@ -32,8 +42,61 @@ type WebhookConfig struct {
 	URL string
 }
 
+type ProviderConfig struct {
+	Name           string
+	URL            string
+	TimeoutSeconds int
+	Headers        map[string]string
+	ToField        string
+	ContentField   string
+	MessageIDPath  string
+	StatePath      string
+}
+
 type RabbitMQConfig struct {
-	URL string
+	URL             string
+	PrefetchCount   int
+	ConsumerWorkers int
+}
+
+type DispatcherConfig struct {
+	IntervalSeconds int
+	BatchSize       int
+	Workers         int
+	MaxInFlight     int
+	LeaseSeconds    int
+}
+
+type OutboxConfig struct {
+	RelayIntervalSeconds int
+	RelayBatchSize       int
+}
+
+// RateLimitConfig caps the outbound side, a zero value disables the limit. Everything but the recipient
+// limit is counted per instance.
+type RateLimitConfig struct {
+	RecipientPerHour int
+	TenantPerSecond  float64
+	TenantBurst      int
+	APIKeyPerSecond  float64
+	APIKeyBurst      int
+	ProviderTPS      float64
+}
+
+type AuthConfig struct {
+	BootstrapAdminKey string
+}
+
+type TracingConfig struct {
+	Exporter     string
+	OTLPEndpoint string
+	OTLPInsecure bool
+}
+
+type ReaperConfig struct {
+	IntervalSeconds       int
+	StuckThresholdSeconds int
+	Mode                  string
 }
 
 func New() (*Config, error) {
@@ -43,11 +106,35 @@ func New() (*Config, error) {
 	viper.SetConfigType("env")
 	viper.AutomaticEnv()
 
-	mongoURL := "mongodb://localhost:27017"
+	viper.SetDefault("SHUTDOWN_TIMEOUT_SECONDS", 30)
+	viper.SetDefault("SMS_PROVIDER", "webhooksite")
+	viper.SetDefault("SMS_PROVIDER_TIMEOUT_SECONDS", 10)
+	viper.SetDefault("RABBITMQ_PREFETCH_COUNT", 10)
+	viper.SetDefault("RABBITMQ_CONSUMER_WORKERS", 1)
+	viper.SetDefault("DISPATCHER_INTERVAL_SECONDS", 10)
+	viper.SetDefault("DISPATCHER_BATCH_SIZE", 2)
+	viper.SetDefault("DISPATCHER_WORKERS", 1)
+	viper.SetDefault("DISPATCHER_MAX_IN_FLIGHT", 1)
+	viper.SetDefault("DISPATCHER_LEASE_SECONDS", 60)
+	viper.SetDefault("REAPER_INTERVAL_SECONDS", 30)
+	viper.SetDefault("REAPER_STUCK_THRESHOLD_SECONDS", 300)
+	viper.SetDefault("REAPER_MODE", "requeue")
+	viper.SetDefault("OUTBOX_RELAY_INTERVAL_SECONDS", 5)
+	viper.SetDefault("OUTBOX_RELAY_BATCH_SIZE", 50)
+	viper.SetDefault("TRACING_EXPORTER", "none")
+	viper.SetDefault("RATE_LIMIT_RECIPIENT_PER_HOUR", 10)
+	viper.SetDefault("RATE_LIMIT_TENANT_PER_SECOND", 50)
+	viper.SetDefault("RATE_LIMIT_TENANT_BURST", 500)
+	viper.SetDefault("RATE_LIMIT_API_KEY_PER_SECOND", 20)
+	viper.SetDefault("RATE_LIMIT_API_KEY_BURST", 500)
+	viper.SetDefault("DISPATCHER_PROVIDER_TPS", 10)
+
+	// directConnection lets a client outside the compose network use the single member replica set
+	mongoURL := "mongodb://localhost:27017/?directConnection=true"
 	rabbitHost := "localhost"
 	if os.Getenv("DOCKER_ENV") == "1" {
 		rabbitHost = "rabbitmq"
-		mongoURL = "mongodb://mongodb:27017/message_bird"
+		mongoURL = "mongodb://mongodb:27017/message_bird?replicaSet=rs0"
 	}
 
 	rabbitMQURL := fmt.Sprintf("amqp://guest:guest@%s:5672/", rabbitHost)
@@ -71,11 +158,22 @@ func New() (*Config, error) {
 		return nil, fmt.Errorf(errMsg)
 	}
 
+	instanceID := viper.GetString("INSTANCE_ID")
+	if instanceID == "" {
+		instanceID, err = os.Hostname()
+		if err != nil {
+			log.Error().Msg("Could not resolve the hostname for the instance id.")
+			return nil, err
+		}
+	}
+
 	config.AppConfig = AppConfig{
-		AppName: viper.GetString("APPNAME"),
+		AppName:    viper.GetString("APPNAME"),
+		InstanceID: instanceID,
 	}
 	config.ServerConfig = ServerConfig{
-		Port: viper.GetInt("PORT"),
+		Port:                   viper.GetInt("PORT"),
+		ShutdownTimeoutSeconds: viper.GetInt("SHUTDOWN_TIMEOUT_SECONDS"),
 	}
 	config.MongoDBConfig = MongoDBConfig{
 		Host: mongoURL,
@@ -84,8 +182,56 @@ func New() (*Config, error) {
 	config.WebhookConfig = WebhookConfig{
 		URL: viper.GetString("WEBHOOK_SITE_URL"),
 	}
+	providerURL := viper.GetString("SMS_PROVIDER_URL")
+	if providerURL == "" {
+		providerURL = config.WebhookConfig.URL
+	}
+	config.ProviderConfig = ProviderConfig{
+		Name:           viper.GetString("SMS_PROVIDER"),
+		URL:            providerURL,
+		TimeoutSeconds: viper.GetInt("SMS_PROVIDER_TIMEOUT_SECONDS"),
+		Headers:        parseHeaders(viper.GetString("SMS_PROVIDER_HEADERS")),
+		ToField:        viper.GetString("SMS_PROVIDER_TO_FIELD"),
+		ContentField:   viper.GetString("SMS_PROVIDER_CONTENT_FIELD"),
+		MessageIDPath:  viper.GetString("SMS_PROVIDER_MESSAGE_ID_PATH"),
+		StatePath:      viper.GetString("SMS_PROVIDER_STATE_PATH"),
+	}
 	config.RabbitMQConfig = RabbitMQConfig{
-		URL: rabbitMQURL,
+		URL:             rabbitMQURL,
+		PrefetchCount:   viper.GetInt("RABBITMQ_PREFETCH_COUNT"),
+		ConsumerWorkers: viper.GetInt("RABBITMQ_CONSUMER_WORKERS"),
+	}
+	config.DispatcherConfig = DispatcherConfig{
+		IntervalSeconds: viper.GetInt("DISPATCHER_INTERVAL_SECONDS"),
+		BatchSize:       viper.GetInt("DISPATCHER_BATCH_SIZE"),
+		Workers:         viper.GetInt("DISPATCHER_WORKERS"),
+		MaxInFlight:     viper.GetInt("DISPATCHER_MAX_IN_FLIGHT"),
+		LeaseSeconds:    viper.GetInt("DISPATCHER_LEASE_SECONDS"),
+	}
+	config.ReaperConfig = ReaperConfig{
+		IntervalSeconds:       viper.GetInt("REAPER_INTERVAL_SECONDS"),
+		StuckThresholdSeconds: viper.GetInt("REAPER_STUCK_THRESHOLD_SECONDS"),
+		Mode:                  viper.GetString("REAPER_MODE"),
+	}
+	config.OutboxConfig = OutboxConfig{
+		RelayIntervalSeconds: viper.GetInt("OUTBOX_RELAY_INTERVAL_SECONDS"),
+		RelayBatchSize:       viper.GetInt("OUTBOX_RELAY_BATCH_SIZE"),
+	}
+	config.AuthConfig = AuthConfig{
+		BootstrapAdminKey: viper.GetString("API_BOOTSTRAP_ADMIN_KEY"),
+	}
+	config.TracingConfig = TracingConfig{
+		Exporter:     viper.GetString("TRACING_EXPORTER"),
+		OTLPEndpoint: viper.GetString("TRACING_OTLP_ENDPOINT"),
+		OTLPInsecure: viper.GetBool("TRACING_OTLP_INSECURE"),
+	}
+	config.RateLimitConfig = RateLimitConfig{
+		RecipientPerHour: viper.GetInt("RATE_LIMIT_RECIPIENT_PER_HOUR"),
+		TenantPerSecond:  viper.GetFloat64("RATE_LIMIT_TENANT_PER_SECOND"),
+		TenantBurst:      viper.GetInt("RATE_LIMIT_TENANT_BURST"),
+		APIKeyPerSecond:  viper.GetFloat64("RATE_LIMIT_API_KEY_PER_SECOND"),
+		APIKeyBurst:      viper.GetInt("RATE_LIMIT_API_KEY_BURST"),
+		ProviderTPS:      viper.GetFloat64("DISPATCHER_PROVIDER_TPS"),
 	}
 
 	return config, nil
@@ -100,3 +246,16 @@ func checkMissingKeys(keys []string) []string {
 	}
 	return missingKeys
 }
+
+// parseHeaders reads headers in the `Name: value, Other: value` format.
+func parseHeaders(raw string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		name, value, found := strings.Cut(pair, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers
+}
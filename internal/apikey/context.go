@@ -0,0 +1,15 @@
+package apikey
+
+import "context"
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the key the request was authenticated with, or nil.
+func FromContext(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(contextKey{}).(*APIKey)
+	return key
+}
@@ -0,0 +1,29 @@
+package apikey
+
+import "time"
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+	// TenantID defaults to the tenant of the caller, only callers not bound to a tenant may pick another one.
+	TenantID string `json:"tenantId,omitempty" validate:"excluded_with=Platform"`
+	// Platform creates a key bound to no tenant, only callers not bound to a tenant may create one.
+	Platform bool     `json:"platform,omitempty"`
+	Scopes   []string `json:"scopes" validate:"required,min=1,dive,oneof=messages:read messages:write admin:dispatcher admin:keys admin:tenants"`
+}
+
+type APIKeyResponse struct {
+	Id          string     `json:"id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Scopes      []string   `json:"scopes"`
+	TenantID    string     `json:"tenantId,omitempty"`
+	RotatedFrom string     `json:"rotatedFrom,omitempty"`
+	CreatedAt   *time.Time `json:"createdAt"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+}
+
+// CreatedAPIKeyResponse is the only response that carries the key itself, it cannot be looked up later.
+type CreatedAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
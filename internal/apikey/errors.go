@@ -0,0 +1,16 @@
+package apikey
+
+import "errors"
+
+var (
+	ErrAPIKeyNotFound  = errors.New("api key not found")
+	ErrInvalidAPIKeyID = errors.New("invalid api key id")
+	ErrInvalidAPIKey   = errors.New("invalid or revoked api key")
+	ErrMissingAPIKey   = errors.New("missing api key")
+
+	ErrTenantRequired = errors.New("tenantId is required")
+	ErrUnknownTenant  = errors.New("tenant does not exist")
+	ErrForeignTenant  = errors.New("api key cannot manage the keys of another tenant")
+	ErrScopeNotHeld   = errors.New("api key cannot grant a scope it does not hold")
+	ErrPlatformScope  = errors.New("admin:dispatcher and admin:tenants can only be granted to platform keys")
+)
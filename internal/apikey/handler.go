@@ -0,0 +1,123 @@
+package apikey
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+type Handler interface {
+	createAPIKey(ctx echo.Context) error
+}
+
+type handler struct {
+	echo    *echo.Echo
+	useCase UseCase
+}
+
+func NewHandler(e *echo.Echo, u UseCase) Handler {
+	h := &handler{
+		echo:    e,
+		useCase: u,
+	}
+	h.registerRoutes()
+	return h
+}
+
+func (h *handler) registerRoutes() {
+	keys := h.echo.Group("/admin/api-keys", RequireScope(ScopeAdminKeys))
+	keys.POST("", h.createAPIKey)
+	keys.GET("", h.listAPIKeys)
+	keys.POST("/:id/rotate", h.rotateAPIKey)
+	keys.DELETE("/:id", h.revokeAPIKey)
+}
+
+func (h *handler) createAPIKey(ctx echo.Context) error {
+	var requestDto CreateAPIKeyRequest
+	err := ctx.Bind(&requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body").
+			SetInternal(err)
+	}
+
+	err = ctx.Validate(requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "validation error").
+			SetInternal(err)
+	}
+
+	resp, err := h.useCase.CreateAPIKey(ctx.Request().Context(), requestDto)
+	if errors.Is(err, ErrTenantRequired) || errors.Is(err, ErrUnknownTenant) || errors.Is(err, ErrPlatformScope) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+	}
+	if errors.Is(err, ErrForeignTenant) || errors.Is(err, ErrScopeNotHeld) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error()).SetInternal(err)
+	}
+	if err != nil {
+		log.Ctx(ctx.Request().Context()).Error().
+			Err(err).
+			Str("name", requestDto.Name).
+			Msg("failed to create api key - handler")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error()).
+			SetInternal(err)
+	}
+
+	return ctx.JSON(http.StatusCreated, resp)
+}
+
+func (h *handler) listAPIKeys(ctx echo.Context) error {
+	keys, err := h.useCase.ListAPIKeys(ctx.Request().Context())
+	if err != nil {
+		log.Ctx(ctx.Request().Context()).Error().
+			Err(err).
+			Msg("failed to list api keys - handler")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error()).
+			SetInternal(err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"data": keys,
+	})
+}
+
+func (h *handler) rotateAPIKey(ctx echo.Context) error {
+	keyID := ctx.Param("id")
+
+	resp, err := h.useCase.RotateAPIKey(ctx.Request().Context(), keyID)
+	if err != nil {
+		return apiKeyHTTPError(ctx, err, keyID, "failed to rotate api key - handler")
+	}
+
+	return ctx.JSON(http.StatusCreated, resp)
+}
+
+func (h *handler) revokeAPIKey(ctx echo.Context) error {
+	keyID := ctx.Param("id")
+
+	resp, err := h.useCase.RevokeAPIKey(ctx.Request().Context(), keyID)
+	if err != nil {
+		return apiKeyHTTPError(ctx, err, keyID, "failed to revoke api key - handler")
+	}
+
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+func apiKeyHTTPError(ctx echo.Context, err error, keyID string, logMsg string) error {
+	switch {
+	case errors.Is(err, ErrInvalidAPIKeyID):
+		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidAPIKeyID.Error()).SetInternal(err)
+	case errors.Is(err, ErrAPIKeyNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, ErrAPIKeyNotFound.Error()).SetInternal(err)
+	}
+
+	log.Ctx(ctx.Request().Context()).Error().
+		Err(err).
+		Str("apiKeyId", keyID).
+		Msg(logMsg)
+
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error()).
+		SetInternal(err)
+}
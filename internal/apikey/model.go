@@ -0,0 +1,41 @@
+package apikey
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	ScopeMessagesRead    = "messages:read"
+	ScopeMessagesWrite   = "messages:write"
+	ScopeAdminDispatcher = "admin:dispatcher"
+	ScopeAdminKeys       = "admin:keys"
+	ScopeAdminTenants    = "admin:tenants"
+)
+
+// Scopes lists every scope a key can be granted.
+var Scopes = []string{ScopeMessagesRead, ScopeMessagesWrite, ScopeAdminDispatcher, ScopeAdminKeys, ScopeAdminTenants}
+
+// platformScopes act on every tenant at once, keys bound to a tenant cannot hold them.
+var platformScopes = []string{ScopeAdminDispatcher, ScopeAdminTenants}
+
+type APIKey struct {
+	Id   string
+	Name string
+	// Prefix is the start of the key, enough to recognise it in listings without exposing the secret.
+	Prefix string
+	// Hash is the SHA-256 of the key, the key itself is never stored.
+	Hash   string
+	Scopes []string
+	// TenantID is the tenant every request made with the key acts on. It is empty for platform keys and
+	// the bootstrap key.
+	TenantID string
+	// RotatedFrom is the id of the key this one replaced.
+	RotatedFrom string
+	CreatedAt   *time.Time
+	RevokedAt   *time.Time
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
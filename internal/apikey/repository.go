@@ -0,0 +1,13 @@
+package apikey
+
+import "context"
+
+type Repository interface {
+	CreateAPIKey(ctx context.Context, key APIKey) (*APIKey, error)
+	// GetActiveAPIKeyByHash returns ErrAPIKeyNotFound for unknown and revoked keys alike.
+	GetActiveAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, keyID string) (*APIKey, error)
+	// RotateAPIKey revokes the key and stores its replacement with the same name and scopes in one transaction.
+	RotateAPIKey(ctx context.Context, keyID string, replacement APIKey) (*APIKey, error)
+}
@@ -0,0 +1,30 @@
+package apikey
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"slices"
+)
+
+// RequireScope rejects requests whose API key was not granted scope. It expects the key to be put on
+// the request context by the authentication middleware. The platform scopes (the dispatcher, the dead
+// letters, the reaper and the tenants) act on every tenant, keys bound to a tenant are refused them even
+// when they were granted one before this was enforced.
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := FromContext(c.Request().Context())
+			if key == nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, ErrMissingAPIKey.Error())
+			}
+			if !key.HasScope(scope) {
+				return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("api key lacks the %s scope", scope))
+			}
+			if key.TenantID != "" && slices.Contains(platformScopes, scope) {
+				return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("the %s scope is reserved to platform keys", scope))
+			}
+			return next(c)
+		}
+	}
+}
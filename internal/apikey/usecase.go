@@ -0,0 +1,237 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"slices"
+)
+
+const (
+	keyPrefix       = "mb_"
+	keyBytes        = 32
+	displayedLength = len(keyPrefix) + 8
+
+	bootstrapKeyID = "bootstrap"
+)
+
+type UseCase interface {
+	Authenticate(ctx context.Context, rawKey string) (*APIKey, error)
+	CreateAPIKey(ctx context.Context, request CreateAPIKeyRequest) (*CreatedAPIKeyResponse, error)
+	ListAPIKeys(ctx context.Context) ([]APIKeyResponse, error)
+	RotateAPIKey(ctx context.Context, keyID string) (*CreatedAPIKeyResponse, error)
+	RevokeAPIKey(ctx context.Context, keyID string) (*APIKeyResponse, error)
+}
+
+// Tenants is the part of the tenant use case the keys depend on.
+type Tenants interface {
+	TenantExists(ctx context.Context, tenantID string) (bool, error)
+}
+
+type useCase struct {
+	repo    Repository
+	tenants Tenants
+
+	bootstrapKey *APIKey
+}
+
+type NewUseCaseOptions struct {
+	Repo    Repository
+	Tenants Tenants
+	// BootstrapAdminKey is accepted with every scope without being stored, so the first keys can be created.
+	BootstrapAdminKey string
+}
+
+func NewUseCase(opts *NewUseCaseOptions) UseCase {
+	u := &useCase{repo: opts.Repo, tenants: opts.Tenants}
+
+	if opts.BootstrapAdminKey != "" {
+		u.bootstrapKey = &APIKey{
+			Id:     bootstrapKeyID,
+			Name:   bootstrapKeyID,
+			Prefix: displayPrefix(opts.BootstrapAdminKey),
+			Hash:   hashKey(opts.BootstrapAdminKey),
+			Scopes: Scopes,
+		}
+	}
+
+	return u
+}
+
+func (u *useCase) Authenticate(ctx context.Context, rawKey string) (*APIKey, error) {
+	if rawKey == "" {
+		return nil, ErrMissingAPIKey
+	}
+
+	hash := hashKey(rawKey)
+	if u.bootstrapKey != nil && subtle.ConstantTimeCompare([]byte(hash), []byte(u.bootstrapKey.Hash)) == 1 {
+		return u.bootstrapKey, nil
+	}
+
+	key, err := u.repo.GetActiveAPIKeyByHash(ctx, hash)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (u *useCase) CreateAPIKey(ctx context.Context, request CreateAPIKeyRequest) (*CreatedAPIKeyResponse, error) {
+	tenantID, err := u.keyTenant(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGrantedScopes(ctx, tenantID, request.Scopes); err != nil {
+		return nil, err
+	}
+
+	rawKey, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := u.repo.CreateAPIKey(ctx, APIKey{
+		Name:     request.Name,
+		Prefix:   displayPrefix(rawKey),
+		Hash:     hashKey(rawKey),
+		Scopes:   request.Scopes,
+		TenantID: tenantID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Ctx(ctx).Info().Str("apiKeyId", key.Id).Str("tenantId", key.TenantID).Strs("scopes", key.Scopes).Msg("API key created")
+
+	return &CreatedAPIKeyResponse{APIKeyResponse: toAPIKeyResponse(*key), Key: rawKey}, nil
+}
+
+func (u *useCase) ListAPIKeys(ctx context.Context) ([]APIKeyResponse, error) {
+	keys, err := u.repo.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, toAPIKeyResponse(key))
+	}
+	return resp, nil
+}
+
+// RotateAPIKey issues a new key with the same name and scopes and revokes the old one right away.
+func (u *useCase) RotateAPIKey(ctx context.Context, keyID string) (*CreatedAPIKeyResponse, error) {
+	rawKey, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := u.repo.RotateAPIKey(ctx, keyID, APIKey{
+		Prefix:      displayPrefix(rawKey),
+		Hash:        hashKey(rawKey),
+		RotatedFrom: keyID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Ctx(ctx).Info().Str("apiKeyId", key.Id).Str("rotatedFrom", keyID).Msg("API key rotated")
+
+	return &CreatedAPIKeyResponse{APIKeyResponse: toAPIKeyResponse(*key), Key: rawKey}, nil
+}
+
+func (u *useCase) RevokeAPIKey(ctx context.Context, keyID string) (*APIKeyResponse, error) {
+	key, err := u.repo.RevokeAPIKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Ctx(ctx).Info().Str("apiKeyId", key.Id).Msg("API key revoked")
+
+	resp := toAPIKeyResponse(*key)
+	return &resp, nil
+}
+
+// keyTenant resolves the tenant of a new key. Callers bound to a tenant can only create keys for it,
+// platform keys are created without a tenant.
+func (u *useCase) keyTenant(ctx context.Context, request CreateAPIKeyRequest) (string, error) {
+	requested := request.TenantID
+	if caller := FromContext(ctx); caller != nil && caller.TenantID != "" {
+		if request.Platform || (requested != "" && requested != caller.TenantID) {
+			return "", ErrForeignTenant
+		}
+		return caller.TenantID, nil
+	}
+
+	if request.Platform {
+		return "", nil
+	}
+	if requested == "" {
+		return "", ErrTenantRequired
+	}
+	exists, err := u.tenants.TenantExists(ctx, requested)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", ErrUnknownTenant
+	}
+	return requested, nil
+}
+
+// checkGrantedScopes keeps the caller from granting more than it holds, and keys bound to a tenant from
+// acting on every tenant.
+func checkGrantedScopes(ctx context.Context, tenantID string, scopes []string) error {
+	caller := FromContext(ctx)
+	for _, scope := range scopes {
+		if caller == nil || !caller.HasScope(scope) {
+			return fmt.Errorf("%w: %s", ErrScopeNotHeld, scope)
+		}
+		if tenantID != "" && slices.Contains(platformScopes, scope) {
+			return fmt.Errorf("%w: %s", ErrPlatformScope, scope)
+		}
+	}
+	return nil
+}
+
+func toAPIKeyResponse(key APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		Id:          key.Id,
+		Name:        key.Name,
+		Prefix:      key.Prefix,
+		Scopes:      key.Scopes,
+		TenantID:    key.TenantID,
+		RotatedFrom: key.RotatedFrom,
+		CreatedAt:   key.CreatedAt,
+		RevokedAt:   key.RevokedAt,
+	}
+}
+
+func generateKey() (string, error) {
+	secret := make([]byte, keyBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashKey uses a plain SHA-256, generated keys carry enough entropy that a slow hash would add nothing.
+func hashKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func displayPrefix(rawKey string) string {
+	if len(rawKey) <= displayedLength {
+		return rawKey[:len(rawKey)/2]
+	}
+	return rawKey[:displayedLength]
+}
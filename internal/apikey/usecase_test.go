@@ -0,0 +1,220 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type memoryRepo struct {
+	Repository
+
+	byHash  map[string]*APIKey
+	created []APIKey
+}
+
+func (r *memoryRepo) GetActiveAPIKeyByHash(_ context.Context, hash string) (*APIKey, error) {
+	key, ok := r.byHash[hash]
+	if !ok || key.RevokedAt != nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+func (r *memoryRepo) CreateAPIKey(_ context.Context, key APIKey) (*APIKey, error) {
+	key.Id = "created"
+	r.created = append(r.created, key)
+	return &key, nil
+}
+
+type knownTenants map[string]bool
+
+func (t knownTenants) TenantExists(_ context.Context, tenantID string) (bool, error) {
+	return t[tenantID], nil
+}
+
+func newTestUseCase(repo *memoryRepo) UseCase {
+	return NewUseCase(&NewUseCaseOptions{
+		Repo:              repo,
+		Tenants:           knownTenants{"acme": true, "globex": true},
+		BootstrapAdminKey: "mb_bootstrap",
+	})
+}
+
+func callerContext(tenantID string, scopes ...string) context.Context {
+	return NewContext(context.Background(), &APIKey{Id: "caller", TenantID: tenantID, Scopes: scopes})
+}
+
+func TestAuthenticate(t *testing.T) {
+	stored := &APIKey{Id: "stored", TenantID: "acme", Scopes: []string{ScopeMessagesRead}}
+	repo := &memoryRepo{byHash: map[string]*APIKey{hashKey("mb_stored"): stored}}
+	u := newTestUseCase(repo)
+	ctx := context.Background()
+
+	key, err := u.Authenticate(ctx, "mb_bootstrap")
+	if err != nil || key.Id != bootstrapKeyID || key.TenantID != "" || len(key.Scopes) != len(Scopes) {
+		t.Errorf("Authenticate(bootstrap) = %+v, %v; want the unbound bootstrap key with every scope", key, err)
+	}
+
+	key, err = u.Authenticate(ctx, "mb_stored")
+	if err != nil || key != stored {
+		t.Errorf("Authenticate(stored) = %+v, %v; want the stored key", key, err)
+	}
+
+	if _, err := u.Authenticate(ctx, "mb_unknown"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate(unknown) error = %v, want ErrInvalidAPIKey", err)
+	}
+	if _, err := u.Authenticate(ctx, ""); !errors.Is(err, ErrMissingAPIKey) {
+		t.Errorf("Authenticate(\"\") error = %v, want ErrMissingAPIKey", err)
+	}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		request    CreateAPIKeyRequest
+		wantErr    error
+		wantTenant string
+	}{
+		{
+			name:       "tenant key creates a key for its own tenant",
+			ctx:        callerContext("acme", ScopeAdminKeys, ScopeMessagesRead),
+			request:    CreateAPIKeyRequest{Name: "reader", Scopes: []string{ScopeMessagesRead}},
+			wantTenant: "acme",
+		},
+		{
+			name:    "scope the caller does not hold",
+			ctx:     callerContext("acme", ScopeAdminKeys),
+			request: CreateAPIKeyRequest{Name: "writer", Scopes: []string{ScopeMessagesWrite}},
+			wantErr: ErrScopeNotHeld,
+		},
+		{
+			name:    "platform scope on a tenant key",
+			ctx:     callerContext("acme", ScopeAdminKeys, ScopeAdminTenants),
+			request: CreateAPIKeyRequest{Name: "tenants", Scopes: []string{ScopeAdminTenants}},
+			wantErr: ErrPlatformScope,
+		},
+		{
+			name:    "tenant key creates a key for another tenant",
+			ctx:     callerContext("acme", ScopeAdminKeys, ScopeMessagesRead),
+			request: CreateAPIKeyRequest{Name: "reader", TenantID: "globex", Scopes: []string{ScopeMessagesRead}},
+			wantErr: ErrForeignTenant,
+		},
+		{
+			name:    "tenant key creates a platform key",
+			ctx:     callerContext("acme", ScopeAdminKeys, ScopeMessagesRead),
+			request: CreateAPIKeyRequest{Name: "platform", Platform: true, Scopes: []string{ScopeMessagesRead}},
+			wantErr: ErrForeignTenant,
+		},
+		{
+			name:    "platform key without a tenant",
+			ctx:     callerContext("", Scopes...),
+			request: CreateAPIKeyRequest{Name: "reader", Scopes: []string{ScopeMessagesRead}},
+			wantErr: ErrTenantRequired,
+		},
+		{
+			name:    "platform key for an unknown tenant",
+			ctx:     callerContext("", Scopes...),
+			request: CreateAPIKeyRequest{Name: "reader", TenantID: "initech", Scopes: []string{ScopeMessagesRead}},
+			wantErr: ErrUnknownTenant,
+		},
+		{
+			name:       "platform key grants a tenant its scopes",
+			ctx:        callerContext("", Scopes...),
+			request:    CreateAPIKeyRequest{Name: "writer", TenantID: "globex", Scopes: []string{ScopeMessagesWrite}},
+			wantTenant: "globex",
+		},
+		{
+			name:    "platform key grants a tenant a platform scope",
+			ctx:     callerContext("", Scopes...),
+			request: CreateAPIKeyRequest{Name: "dispatcher", TenantID: "globex", Scopes: []string{ScopeAdminDispatcher}},
+			wantErr: ErrPlatformScope,
+		},
+		{
+			name:    "platform key creates a platform key",
+			ctx:     callerContext("", Scopes...),
+			request: CreateAPIKeyRequest{Name: "ops", Platform: true, Scopes: []string{ScopeAdminDispatcher, ScopeAdminTenants}},
+		},
+		{
+			name:    "unauthenticated caller",
+			ctx:     context.Background(),
+			request: CreateAPIKeyRequest{Name: "ops", Platform: true, Scopes: []string{ScopeMessagesRead}},
+			wantErr: ErrScopeNotHeld,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memoryRepo{}
+			resp, err := newTestUseCase(repo).CreateAPIKey(tt.ctx, tt.request)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("CreateAPIKey() error = %v, want %v", err, tt.wantErr)
+				}
+				if len(repo.created) != 0 {
+					t.Errorf("a key was stored although the request was refused")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateAPIKey() error = %v", err)
+			}
+
+			if resp.TenantID != tt.wantTenant {
+				t.Errorf("tenant = %q, want %q", resp.TenantID, tt.wantTenant)
+			}
+			if !strings.HasPrefix(resp.Key, keyPrefix) || !strings.HasPrefix(resp.Key, resp.Prefix) {
+				t.Errorf("key %q does not start with its prefix %q", resp.Key, resp.Prefix)
+			}
+			if stored := repo.created[0]; stored.Hash != hashKey(resp.Key) {
+				t.Errorf("stored hash %q is not the hash of the key", stored.Hash)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        *APIKey
+		scope      string
+		wantStatus int
+	}{
+		{"no key", nil, ScopeMessagesRead, http.StatusUnauthorized},
+		{"scope not granted", &APIKey{TenantID: "acme", Scopes: []string{ScopeMessagesRead}}, ScopeMessagesWrite, http.StatusForbidden},
+		{"scope granted", &APIKey{TenantID: "acme", Scopes: []string{ScopeMessagesWrite}}, ScopeMessagesWrite, http.StatusOK},
+		{"platform scope on a tenant key", &APIKey{TenantID: "acme", Scopes: []string{ScopeAdminDispatcher}}, ScopeAdminDispatcher, http.StatusForbidden},
+		{"platform scope on a platform key", &APIKey{Scopes: []string{ScopeAdminDispatcher}}, ScopeAdminDispatcher, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.key != nil {
+				req = req.WithContext(NewContext(req.Context(), tt.key))
+			}
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			err := RequireScope(tt.scope)(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})(c)
+
+			status := http.StatusOK
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			} else if err != nil {
+				t.Fatalf("RequireScope() error = %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
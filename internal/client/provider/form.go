@@ -0,0 +1,46 @@
+package provider
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+const Form = "form"
+
+func init() {
+	Register(Form, newFormProvider)
+}
+
+// formProvider posts an application/x-www-form-urlencoded body, which most classic SMS gateways expect.
+// The response is still read as JSON when MessageIDPath or StatePath is configured.
+type formProvider struct {
+	sender       *httpSender
+	toField      string
+	contentField string
+}
+
+func newFormProvider(cfg Config) (Provider, error) {
+	sender, err := newHTTPSender(Form, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &formProvider{
+		sender:       sender,
+		toField:      valueOrDefault(cfg.ToField, "to"),
+		contentField: valueOrDefault(cfg.ContentField, "content"),
+	}, nil
+}
+
+func (p *formProvider) Name() string {
+	return Form
+}
+
+func (p *formProvider) Send(ctx context.Context, req SendRequest) (*SendResult, error) {
+	form := url.Values{}
+	form.Set(p.toField, req.To)
+	form.Set(p.contentField, req.Content)
+
+	return p.sender.send(ctx, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+}
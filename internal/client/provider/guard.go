@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrNonPublicAddress = errors.New("provider address is not a public address")
+
+// carrierGradeNAT is shared address space (RFC 6598), private to the carrier network.
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// checkURL accepts absolute http(s) URLs. With publicOnly, hosts which are obviously internal are
+// refused up front; names resolving to internal addresses are refused when connecting.
+func checkURL(rawURL string, publicOnly bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid provider URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("provider URL must use http or https, got %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return errors.New("provider URL has no host")
+	}
+	if !publicOnly {
+		return nil
+	}
+	// The URL is shown in the tenant responses, the header values are not
+	if u.User != nil {
+		return errors.New("provider URL cannot carry credentials, use the headers instead")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrNonPublicAddress, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrNonPublicAddress, host)
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || carrierGradeNAT.Contains(ip))
+}
+
+// newHTTPClient returns a client which, with publicOnly, checks the address of every connection after
+// name resolution, so neither a DNS answer nor a redirect can reach the internal network.
+func newHTTPClient(timeout time.Duration, publicOnly bool) *http.Client {
+	if !publicOnly {
+		return &http.Client{Timeout: timeout}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrNonPublicAddress, host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// Through a proxy only the proxy address would be checked
+	transport.Proxy = nil
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
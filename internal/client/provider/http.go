@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jiin-yang/messageBird/internal/client/webhook"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// httpSender holds what the generic HTTP providers have in common: the target, static headers and
+// the mapping of the JSON response.
+type httpSender struct {
+	name          string
+	url           string
+	headers       map[string]string
+	messageIDPath string
+	statePath     string
+	httpClient    *http.Client
+}
+
+func newHTTPSender(name string, cfg Config) (*httpSender, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("%s provider requires a URL", name)
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &httpSender{
+		name:          name,
+		url:           cfg.URL,
+		headers:       cfg.Headers,
+		messageIDPath: cfg.MessageIDPath,
+		statePath:     cfg.StatePath,
+		httpClient:    newHTTPClient(timeout, cfg.PublicOnly),
+	}, nil
+}
+
+func (s *httpSender) send(ctx context.Context, contentType string, body io.Reader) (*SendResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, body)
+	if err != nil {
+		return nil, &Error{Provider: s.name, Permanent: true, Err: err}
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, newError(s.name, webhook.ErrorFromTransport(err))
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warn().Err(err).Str("provider", s.name).Msg("Failed to close response body")
+		}
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newError(s.name, webhook.ErrorFromTransport(err))
+	}
+
+	if resp.StatusCode >= 400 {
+		message := fmt.Sprintf("gateway answered %s", http.StatusText(resp.StatusCode))
+		return nil, newError(s.name, webhook.ErrorFromResponse(resp.StatusCode, resp.Header, message))
+	}
+
+	result := &SendResult{
+		Provider:   s.name,
+		StatusCode: resp.StatusCode,
+	}
+
+	if s.messageIDPath == "" && s.statePath == "" {
+		return result, nil
+	}
+
+	// The gateway accepted the message, retrying because its answer cannot be read would send it twice
+	var decoded interface{}
+	if err = json.Unmarshal(respBody, &decoded); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("provider", s.name).Int("statusCode", resp.StatusCode).
+			Msg("Failed to parse the response of an accepted message, it is recorded without a provider message id")
+		return result, nil
+	}
+
+	result.ProviderMessageID = lookupString(decoded, s.messageIDPath)
+	result.State = lookupString(decoded, s.statePath)
+	if s.messageIDPath != "" && result.ProviderMessageID == "" {
+		log.Ctx(ctx).Warn().Str("provider", s.name).Str("messageIdPath", s.messageIDPath).Int("statusCode", resp.StatusCode).
+			Msg("Response of an accepted message has no message id, it is recorded without one")
+	}
+
+	return result, nil
+}
+
+// lookupString walks a dot separated path like `data.messages.0.id` through a decoded JSON value.
+func lookupString(value interface{}, path string) string {
+	if path == "" {
+		return ""
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			var index int
+			if _, err := fmt.Sscanf(key, "%d", &index); err != nil || index < 0 || index >= len(v) {
+				return ""
+			}
+			value = v[index]
+		default:
+			return ""
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
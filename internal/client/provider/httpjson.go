@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+)
+
+const HTTPJSON = "httpjson"
+
+func init() {
+	Register(HTTPJSON, newHTTPJSONProvider)
+}
+
+// httpJSONProvider posts a JSON object whose field names come from the config, for gateways
+// which only differ from webhook.site by their field names.
+type httpJSONProvider struct {
+	sender       *httpSender
+	toField      string
+	contentField string
+}
+
+func newHTTPJSONProvider(cfg Config) (Provider, error) {
+	sender, err := newHTTPSender(HTTPJSON, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpJSONProvider{
+		sender:       sender,
+		toField:      valueOrDefault(cfg.ToField, "to"),
+		contentField: valueOrDefault(cfg.ContentField, "content"),
+	}, nil
+}
+
+func (p *httpJSONProvider) Name() string {
+	return HTTPJSON
+}
+
+func (p *httpJSONProvider) Send(ctx context.Context, req SendRequest) (*SendResult, error) {
+	body, err := json.Marshal(map[string]string{
+		p.toField:      req.To,
+		p.contentField: req.Content,
+	})
+	if err != nil {
+		return nil, &Error{Provider: HTTPJSON, Permanent: true, Err: err}
+	}
+
+	return p.sender.send(ctx, "application/json", bytes.NewReader(body))
+}
+
+func valueOrDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jiin-yang/messageBird/internal/client/webhook"
+	"time"
+)
+
+type SendRequest struct {
+	To      string
+	Content string
+}
+
+// SendResult is the normalized answer of a provider for an accepted message.
+type SendResult struct {
+	Provider          string
+	ProviderMessageID string
+	State             string
+	StatusCode        int
+}
+
+// Provider sends a single SMS through a gateway.
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, req SendRequest) (*SendResult, error)
+}
+
+// Error is returned by providers when a message could not be delivered. Permanent errors will not
+// succeed on retry (e.g. the gateway rejected the phone number). RetryAfter is set when the gateway
+// throttled the request and asked to wait. Err keeps the typed error of the webhook package.
+type Error struct {
+	Provider   string
+	StatusCode int
+	Permanent  bool
+	RetryAfter time.Duration
+	Err        error
+}
+
+// newError classifies err with the typed errors of the webhook package.
+func newError(providerName string, err error) *Error {
+	return &Error{
+		Provider:   providerName,
+		StatusCode: webhook.StatusCode(err),
+		Permanent:  webhook.IsPermanent(err),
+		RetryAfter: webhook.RetryAfter(err),
+		Err:        err,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s: status %d: %v", e.Provider, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Provider, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent reports whether err is a provider error which should not be retried.
+func IsPermanent(err error) bool {
+	var providerErr *Error
+	return errors.As(err, &providerErr) && providerErr.Permanent
+}
+
+// StatusCode returns the HTTP status code carried by a provider error, or 0.
+func StatusCode(err error) int {
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return providerErr.StatusCode
+	}
+	return 0
+}
+
+// RetryAfter returns the delay the gateway asked for before the next attempt, or 0.
+func RetryAfter(err error) time.Duration {
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return providerErr.RetryAfter
+	}
+	return 0
+}
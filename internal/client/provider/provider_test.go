@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPProvidersClassifyFailures(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		header        map[string]string
+		body          string
+		wantPermanent bool
+		wantAfter     time.Duration
+	}{
+		{name: "rejected number", status: http.StatusUnprocessableEntity, wantPermanent: true},
+		{name: "unauthorized", status: http.StatusUnauthorized, wantPermanent: true},
+		{name: "gateway down", status: http.StatusBadGateway},
+		{name: "throttled", status: http.StatusTooManyRequests, header: map[string]string{"Retry-After": "120"}, wantAfter: 2 * time.Minute},
+	}
+
+	for _, name := range []string{HTTPJSON, Form, WebhookSite} {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					for key, value := range tt.header {
+						w.Header().Set(key, value)
+					}
+					w.WriteHeader(tt.status)
+					_, _ = fmt.Fprint(w, tt.body)
+				}))
+				defer server.Close()
+
+				p, err := New(Config{Name: name, URL: server.URL, MessageIDPath: "data.id"})
+				if err != nil {
+					t.Fatalf("New() error = %v", err)
+				}
+
+				_, err = p.Send(context.Background(), SendRequest{To: "+905551112233", Content: "hi"})
+				var providerErr *Error
+				if !errors.As(err, &providerErr) {
+					t.Fatalf("Send() error = %v, want a provider Error", err)
+				}
+				if providerErr.Provider != name {
+					t.Errorf("provider = %q, want %q", providerErr.Provider, name)
+				}
+				if got := IsPermanent(err); got != tt.wantPermanent {
+					t.Errorf("IsPermanent() = %v, want %v", got, tt.wantPermanent)
+				}
+				if got := StatusCode(err); got != tt.status {
+					t.Errorf("StatusCode() = %d, want %d", got, tt.status)
+				}
+				if got := RetryAfter(err); got != tt.wantAfter {
+					t.Errorf("RetryAfter() = %v, want %v", got, tt.wantAfter)
+				}
+			})
+		}
+	}
+}
+
+// An accepted message is never retried, even if the answer of the gateway cannot be read.
+func TestHTTPProvidersAcceptUnreadableSuccess(t *testing.T) {
+	bodies := map[string]string{
+		"message id missing": `{"status":"queued"}`,
+		"not json":           `<html></html>`,
+		"empty":              ``,
+	}
+
+	for _, name := range []string{HTTPJSON, Form, WebhookSite} {
+		for bodyName, body := range bodies {
+			t.Run(name+"/"+bodyName, func(t *testing.T) {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+					_, _ = fmt.Fprint(w, body)
+				}))
+				defer server.Close()
+
+				p, err := New(Config{Name: name, URL: server.URL, MessageIDPath: "data.id"})
+				if err != nil {
+					t.Fatalf("New() error = %v", err)
+				}
+
+				result, err := p.Send(context.Background(), SendRequest{To: "+905551112233", Content: "hi"})
+				if err != nil {
+					t.Fatalf("Send() error = %v, want the message accepted", err)
+				}
+				if result.Provider != name || result.ProviderMessageID != "" || result.StatusCode != http.StatusOK {
+					t.Errorf("result = %+v, want %s with status 200 and no message id", result, name)
+				}
+			})
+		}
+	}
+}
+
+func TestHTTPJSONProviderReadsTheResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprint(w, `{"data":{"messages":[{"id":42,"status":"queued"}]}}`)
+	}))
+	defer server.Close()
+
+	p, err := New(Config{
+		Name:          HTTPJSON,
+		URL:           server.URL,
+		MessageIDPath: "data.messages.0.id",
+		StatePath:     "data.messages.0.status",
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	result, err := p.Send(context.Background(), SendRequest{To: "+905551112233", Content: "hi"})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if result.ProviderMessageID != "42" || result.State != "queued" || result.StatusCode != http.StatusCreated {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestProviderTimeoutIsRetryable(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	p, err := New(Config{Name: HTTPJSON, URL: server.URL, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	_, err = p.Send(context.Background(), SendRequest{To: "+905551112233", Content: "hi"})
+	if err == nil || IsPermanent(err) || StatusCode(err) != 0 {
+		t.Errorf("Send() error = %v, want a retryable error without status", err)
+	}
+}
@@ -0,0 +1,76 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config holds the settings of a provider. Fields which do not apply to a provider are ignored.
+type Config struct {
+	Name    string
+	URL     string
+	Timeout time.Duration
+	Headers map[string]string
+
+	// Request field names used by the httpjson and form providers.
+	ToField      string
+	ContentField string
+
+	// Dot separated paths into the JSON response used by the httpjson and form providers.
+	MessageIDPath string
+	StatePath     string
+
+	// PublicOnly refuses to reach loopback, private and link-local addresses. It is set for the senders
+	// tenants configure through the API.
+	PublicOnly bool
+}
+
+type Factory func(cfg Config) (Provider, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a provider available by name. It panics when the name is registered twice,
+// like database/sql drivers do.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("provider: Register called twice for %s", name))
+	}
+	registry[name] = factory
+}
+
+// New builds the provider registered as cfg.Name.
+func New(cfg Config) (Provider, error) {
+	registryMu.RLock()
+	factory, ok := registry[cfg.Name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown sms provider %q, available: %v", cfg.Name, Names())
+	}
+	if cfg.URL != "" {
+		if err := checkURL(cfg.URL, cfg.PublicOnly); err != nil {
+			return nil, err
+		}
+	}
+	return factory(cfg)
+}
+
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
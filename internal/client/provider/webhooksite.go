@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jiin-yang/messageBird/internal/client/webhook"
+)
+
+const WebhookSite = "webhooksite"
+
+func init() {
+	Register(WebhookSite, newWebhookSiteProvider)
+}
+
+// webhookSiteProvider adapts the webhook.site client, which expects `to`/`content` and answers with
+// `state`/`responseId`.
+type webhookSiteProvider struct {
+	client webhook.Client
+}
+
+func newWebhookSiteProvider(cfg Config) (Provider, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("%s provider requires a URL", WebhookSite)
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &webhookSiteProvider{
+		client: webhook.NewWebhookClient(&webhook.NewClientOptions{
+			URL:        cfg.URL,
+			HTTPClient: newHTTPClient(timeout, cfg.PublicOnly),
+		}),
+	}, nil
+}
+
+func (p *webhookSiteProvider) Name() string {
+	return WebhookSite
+}
+
+func (p *webhookSiteProvider) Send(ctx context.Context, req SendRequest) (*SendResult, error) {
+	resp, err := p.client.SendMessage(ctx, webhook.SendMessageRequest{
+		To:      req.To,
+		Content: req.Content,
+	})
+	if err != nil {
+		return nil, newError(WebhookSite, err)
+	}
+
+	result := &SendResult{
+		Provider:   WebhookSite,
+		State:      resp.State,
+		StatusCode: resp.StatusCode,
+	}
+	// An answer without a responseId leaves the zero UUID behind
+	if resp.ResponseId != uuid.Nil {
+		result.ProviderMessageID = resp.ResponseId.String()
+	}
+	return result, nil
+}
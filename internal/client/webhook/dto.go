@@ -11,4 +11,5 @@ type SendMessageRequest struct {
 type SendMessageResponseFromWebhook struct {
 	State      string    `json:"state"`
 	ResponseId uuid.UUID `json:"responseId"`
+	StatusCode int       `json:"-"`
 }
@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TimeoutError is returned when the webhook did not answer in time. It is retryable.
+type TimeoutError struct {
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("webhook request timed out: %v", e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// ThrottledError is returned for 429 responses. RetryAfter is zero when the Retry-After header was missing.
+type ThrottledError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *ThrottledError) Error() string {
+	return e.Message
+}
+
+// ServerError is returned for 5xx (and 408) responses. It is retryable.
+type ServerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	return e.Message
+}
+
+// ClientError is returned for the remaining 4xx responses. The webhook will never accept the same
+// request, so it must not be retried.
+type ClientError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ClientError) Error() string {
+	return e.Message
+}
+
+// ErrorFromResponse builds the typed error matching a non-success status code.
+func ErrorFromResponse(statusCode int, header http.Header, message string) error {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return &ThrottledError{
+			StatusCode: statusCode,
+			RetryAfter: ParseRetryAfter(header.Get("Retry-After"), time.Now()),
+			Message:    message,
+		}
+	case statusCode >= 500 || statusCode == http.StatusRequestTimeout:
+		return &ServerError{StatusCode: statusCode, Message: message}
+	default:
+		return &ClientError{StatusCode: statusCode, Message: message}
+	}
+}
+
+// ErrorFromTransport wraps errors of http.Client.Do, turning timeouts into TimeoutError.
+func ErrorFromTransport(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &TimeoutError{Err: err}
+	}
+	return err
+}
+
+// ParseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// IsPermanent reports whether err can never succeed on retry.
+func IsPermanent(err error) bool {
+	var clientErr *ClientError
+	return errors.As(err, &clientErr)
+}
+
+// RetryAfter returns the delay requested by a throttled response, or 0.
+func RetryAfter(err error) time.Duration {
+	var throttledErr *ThrottledError
+	if errors.As(err, &throttledErr) {
+		return throttledErr.RetryAfter
+	}
+	return 0
+}
+
+// StatusCode returns the HTTP status code carried by err, or 0 if there was no response.
+func StatusCode(err error) int {
+	var (
+		throttledErr *ThrottledError
+		serverErr    *ServerError
+		clientErr    *ClientError
+	)
+	switch {
+	case errors.As(err, &throttledErr):
+		return throttledErr.StatusCode
+	case errors.As(err, &serverErr):
+		return serverErr.StatusCode
+	case errors.As(err, &clientErr):
+		return clientErr.StatusCode
+	}
+	return 0
+}
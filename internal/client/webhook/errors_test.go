@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendMessageClassifiesFailures(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		header        map[string]string
+		body          string
+		wantPermanent bool
+		wantStatus    int
+		wantAfter     time.Duration
+		wantType      string
+	}{
+		{name: "bad request", status: http.StatusBadRequest, wantPermanent: true, wantStatus: 400, wantType: "*webhook.ClientError"},
+		{name: "not found", status: http.StatusNotFound, wantPermanent: true, wantStatus: 404, wantType: "*webhook.ClientError"},
+		{name: "request timeout", status: http.StatusRequestTimeout, wantStatus: 408, wantType: "*webhook.ServerError"},
+		{name: "server error", status: http.StatusInternalServerError, wantStatus: 500, wantType: "*webhook.ServerError"},
+		{name: "unavailable", status: http.StatusServiceUnavailable, wantStatus: 503, wantType: "*webhook.ServerError"},
+		{
+			name:       "throttled",
+			status:     http.StatusTooManyRequests,
+			header:     map[string]string{"Retry-After": "7"},
+			wantStatus: 429,
+			wantAfter:  7 * time.Second,
+			wantType:   "*webhook.ThrottledError",
+		},
+		{name: "throttled without retry after", status: http.StatusTooManyRequests, wantStatus: 429, wantType: "*webhook.ThrottledError"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, value := range tt.header {
+					w.Header().Set(name, value)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			c := NewWebhookClient(&NewClientOptions{URL: server.URL})
+			_, err := c.SendMessage(context.Background(), SendMessageRequest{To: "+905551112233", Content: "hi"})
+			if err == nil {
+				t.Fatal("SendMessage() error = nil")
+			}
+
+			if got := IsPermanent(err); got != tt.wantPermanent {
+				t.Errorf("IsPermanent() = %v, want %v", got, tt.wantPermanent)
+			}
+			if got := StatusCode(err); got != tt.wantStatus {
+				t.Errorf("StatusCode() = %d, want %d", got, tt.wantStatus)
+			}
+			if got := RetryAfter(err); got != tt.wantAfter {
+				t.Errorf("RetryAfter() = %v, want %v", got, tt.wantAfter)
+			}
+			if got := fmt.Sprintf("%T", err); got != tt.wantType {
+				t.Errorf("error is a %s, want %s", got, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestSendMessageTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := NewWebhookClient(&NewClientOptions{URL: server.URL, Timeout: 50 * time.Millisecond})
+	_, err := c.SendMessage(context.Background(), SendMessageRequest{To: "+905551112233", Content: "hi"})
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("SendMessage() error = %v, want a TimeoutError", err)
+	}
+	if IsPermanent(err) || StatusCode(err) != 0 {
+		t.Errorf("timeout is permanent = %v, status = %d; want retryable without status", IsPermanent(err), StatusCode(err))
+	}
+}
+
+func TestSendMessageSuccess(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = fmt.Fprint(w, `{"state":"accepted","responseId":"0b7d1b9c-7c43-4c3a-9d8e-3f1c2a8e9b10"}`)
+	}))
+	defer server.Close()
+
+	c := NewWebhookClient(&NewClientOptions{URL: server.URL})
+	resp, err := c.SendMessage(context.Background(), SendMessageRequest{To: "+905551112233", Content: "hi"})
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if resp.State != "accepted" || resp.StatusCode != http.StatusAccepted || resp.ResponseId.String() != "0b7d1b9c-7c43-4c3a-9d8e-3f1c2a8e9b10" {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestSendMessageAcceptsAnUnreadableSuccess(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, "not json")
+	}))
+	defer server.Close()
+
+	c := NewWebhookClient(&NewClientOptions{URL: server.URL})
+	resp, err := c.SendMessage(context.Background(), SendMessageRequest{To: "+905551112233", Content: "hi"})
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v, want the message accepted", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.ResponseId != uuid.Nil || resp.State != "" {
+		t.Errorf("response = %+v, want status 200 without a response id", resp)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{"Fri, 31 Jan 2025 12:02:00 GMT", 2 * time.Minute},
+		{"Fri, 31 Jan 2025 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := ParseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
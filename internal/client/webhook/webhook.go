@@ -2,32 +2,77 @@ package webhook
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"github.com/jiin-yang/messageBird/internal/tracing"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/html"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
+const defaultTimeout = 10 * time.Second
+
 type Client interface {
-	SendMessage(message SendMessageRequest) (*SendMessageResponseFromWebhook, error)
+	SendMessage(ctx context.Context, message SendMessageRequest) (*SendMessageResponseFromWebhook, error)
 }
 
 type client struct {
-	url string
+	url        string
+	httpClient *http.Client
 }
 
 type NewClientOptions struct {
-	URL string
+	URL     string
+	Timeout time.Duration
+	// HTTPClient replaces the default client, Timeout is ignored then.
+	HTTPClient *http.Client
 }
 
 func NewWebhookClient(opts *NewClientOptions) Client {
-	return &client{url: opts.URL}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: timeout}
+	}
+
+	return &client{
+		url:        opts.URL,
+		httpClient: httpClient,
+	}
+}
+
+func (c client) SendMessage(ctx context.Context, requestMsg SendMessageRequest) (*SendMessageResponseFromWebhook, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "webhook.SendMessage",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.request.method", http.MethodPost), attribute.String("url.full", c.url)))
+	defer span.End()
+
+	response, err := c.sendMessage(ctx, requestMsg)
+	if err != nil {
+		tracing.RecordError(span, err)
+		if statusCode := StatusCode(err); statusCode > 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+		}
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
+
+	return response, nil
 }
 
-func (c client) SendMessage(requestMsg SendMessageRequest) (*SendMessageResponseFromWebhook, error) {
+func (c client) sendMessage(ctx context.Context, requestMsg SendMessageRequest) (*SendMessageResponseFromWebhook, error) {
 	requestBody := SendMessageRequest{
 		To:      requestMsg.To,
 		Content: requestMsg.Content,
@@ -43,7 +88,7 @@ func (c client) SendMessage(requestMsg SendMessageRequest) (*SendMessageResponse
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", c.url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -52,16 +97,16 @@ func (c client) SendMessage(requestMsg SendMessageRequest) (*SendMessageResponse
 			Msg("Failed to create HTTP request")
 		return nil, err
 	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
-	httpClient := &http.Client{}
-	resp, err := httpClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		log.Error().
 			Err(err).
 			Str("method", "SendMessage-Webhook Client").
 			Str("url", c.url).
 			Msg("Failed to send HTTP request")
-		return nil, err
+		return nil, ErrorFromTransport(err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -80,7 +125,7 @@ func (c client) SendMessage(requestMsg SendMessageRequest) (*SendMessageResponse
 			Str("method", "SendMessage-Webhook Client").
 			Str("url", c.url).
 			Msg("Failed to read response body")
-		return nil, err
+		return nil, ErrorFromTransport(err)
 	}
 
 	if resp.StatusCode >= 400 {
@@ -95,23 +140,25 @@ func (c client) SendMessage(requestMsg SendMessageRequest) (*SendMessageResponse
 					Err(parseErr).
 					Msg("Failed to extract error message from HTML response")
 			} else {
-				return nil, errors.New(htmlTitle)
+				return nil, ErrorFromResponse(resp.StatusCode, resp.Header, htmlTitle)
 			}
 		}
 
-		return nil, errors.New("HTTP error occurred with non-HTML response")
+		return nil, ErrorFromResponse(resp.StatusCode, resp.Header, "HTTP error occurred with non-HTML response")
 	}
 
+	// The webhook accepted the message, retrying because its answer cannot be read would send it twice
 	var response SendMessageResponseFromWebhook
 	if err = json.Unmarshal(body, &response); err != nil {
-		log.Error().
+		log.Warn().
 			Err(err).
 			Str("method", "SendMessage-Webhook Client").
 			Str("url", c.url).
-			RawJSON("response_body", body).
-			Msg("Failed to parse response JSON")
-		return nil, err
+			Int("status_code", resp.StatusCode).
+			Msg("Failed to parse response JSON of an accepted message, it is recorded without a response id")
+		response = SendMessageResponseFromWebhook{}
 	}
+	response.StatusCode = resp.StatusCode
 
 	log.Info().
 		Str("method", "SendMessage-Webhook Client").
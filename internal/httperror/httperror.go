@@ -0,0 +1,55 @@
+package httperror
+
+import (
+	"fmt"
+	"github.com/go-playground/validator/v10"
+)
+
+type ErrorField struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ErrorResponse struct {
+	Message string       `json:"message"`
+	Errors  []ErrorField `json:"errors,omitempty"`
+	// RequestID is the X-Request-ID of the failed request, to be quoted when reporting the error.
+	RequestID string `json:"requestId,omitempty"`
+}
+
+func NewValidationErrorResponse(ve validator.ValidationErrors) *ErrorResponse {
+	return &ErrorResponse{
+		Message: "Validation Failed",
+		Errors:  BuildValidationErrors(ve),
+	}
+}
+
+func BuildValidationErrors(ve validator.ValidationErrors) []ErrorField {
+	out := make([]ErrorField, len(ve))
+
+	for i, fe := range ve {
+		fieldName := fe.Field()
+		tag := fe.Tag()
+		param := fe.Param()
+
+		var message string
+		switch tag {
+		case "required":
+			message = "This field is required."
+		case "max":
+			message = fmt.Sprintf("Length cannot be more than %s.", param)
+		case "min":
+			message = fmt.Sprintf("Length cannot be less than %s.", param)
+		case "e164":
+			message = "Invalid phone number format. (E.164 required)"
+		default:
+			message = fmt.Sprintf("Validation failed on the '%s' tag.", tag)
+		}
+
+		out[i] = ErrorField{
+			Field:   fieldName,
+			Message: message,
+		}
+	}
+	return out
+}
@@ -0,0 +1,207 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	minReconnectBackoff = 1 * time.Second
+	maxReconnectBackoff = 30 * time.Second
+)
+
+type ConnectionState string
+
+const (
+	StateConnecting   ConnectionState = "connecting"
+	StateConnected    ConnectionState = "connected"
+	StateReconnecting ConnectionState = "reconnecting"
+	StateClosed       ConnectionState = "closed"
+)
+
+var (
+	ErrNotConnected        = errors.New("rabbitmq is not connected")
+	ErrClientClosed        = errors.New("rabbitmq client is closed")
+	ErrPublishNotConfirmed = errors.New("rabbitmq did not confirm the publish")
+)
+
+// State reports the current state of the broker connection. A connection or channel that was closed
+// but not noticed by the watcher yet is already reported as reconnecting.
+func (c *client) State() ConnectionState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.state == StateConnected && (c.conn.IsClosed() || c.channel.IsClosed()) {
+		return StateReconnecting
+	}
+	return c.state
+}
+
+// connect dials the broker, opens the shared channel and declares the topology. Once connected, a
+// watcher reconnects in the background whenever the connection or the channel is closed.
+func (c *client) connect() error {
+	conn, err := amqp091.Dial(c.url)
+	if err != nil {
+		return err
+	}
+
+	ch, err := openConfirmChannel(conn)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	err = c.declareTopology(ch)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	// Qos is a channel setting, so it is applied again on every new channel
+	err = ch.Qos(c.prefetchCount, 0, false)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	connClosed := conn.NotifyClose(make(chan *amqp091.Error, 1))
+	chanClosed := ch.NotifyClose(make(chan *amqp091.Error, 1))
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		_ = conn.Close()
+		return ErrClientClosed
+	}
+	c.conn = conn
+	c.channel = ch
+	c.state = StateConnected
+	close(c.reconnected)
+	c.reconnected = make(chan struct{})
+	c.mu.Unlock()
+
+	go c.watch(conn, connClosed, chanClosed)
+	return nil
+}
+
+func (c *client) watch(conn *amqp091.Connection, connClosed, chanClosed <-chan *amqp091.Error) {
+	var amqpErr *amqp091.Error
+	select {
+	case amqpErr = <-connClosed:
+	case amqpErr = <-chanClosed:
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.state = StateReconnecting
+	c.mu.Unlock()
+
+	event := log.Warn()
+	if amqpErr != nil {
+		event = event.Err(amqpErr)
+	}
+	event.Msg("RabbitMQ connection lost, reconnecting")
+
+	// Sadece channel kapandiysa connection hala acik olabilir, yeni connection acmadan once kapatilir
+	_ = conn.Close()
+
+	c.reconnect()
+}
+
+func (c *client) reconnect() {
+	backoff := minReconnectBackoff
+	for {
+		err := c.connect()
+		if err == nil {
+			log.Info().Msg("Reconnected to RabbitMQ")
+			return
+		}
+		if errors.Is(err, ErrClientClosed) || c.State() == StateClosed {
+			return
+		}
+
+		log.Warn().Err(err).Dur("backoff", backoff).Msg("Failed to reconnect to RabbitMQ, retrying...")
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// Ping checks the broker answers by opening and closing a channel on the current connection.
+func (c *client) Ping() error {
+	c.mu.RLock()
+	conn, state := c.conn, c.state
+	c.mu.RUnlock()
+
+	if state != StateConnected {
+		return ErrNotConnected
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	return ch.Close()
+}
+
+// currentChannel returns the shared channel together with a signal that is closed on the next
+// successful reconnection.
+func (c *client) currentChannel() (*amqp091.Channel, <-chan struct{}, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	switch c.state {
+	case StateConnected:
+		return c.channel, c.reconnected, nil
+	case StateClosed:
+		return nil, c.reconnected, ErrClientClosed
+	default:
+		return nil, c.reconnected, ErrNotConnected
+	}
+}
+
+// openChannel opens a dedicated channel in confirm mode on the current connection.
+func (c *client) openChannel() (*amqp091.Channel, error) {
+	c.mu.RLock()
+	conn, state := c.conn, c.state
+	c.mu.RUnlock()
+
+	if state != StateConnected {
+		return nil, ErrNotConnected
+	}
+	return openConfirmChannel(conn)
+}
+
+// openConfirmChannel opens a channel with publisher confirms enabled, so every publish is only
+// considered done once the broker has taken responsibility for the message.
+func openConfirmChannel(conn *amqp091.Connection) (*amqp091.Channel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+	return ch, nil
+}
+
+// waitForReconnect blocks until the client reconnects and reports whether the caller should resume.
+func (c *client) waitForReconnect(ctx context.Context, reconnected <-chan struct{}) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-reconnected:
+		return c.State() == StateConnected
+	}
+}
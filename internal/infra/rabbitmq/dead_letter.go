@@ -0,0 +1,203 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jiin-yang/messageBird/internal/metrics"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	DeadReasonMaxRetries       = "max_retries"
+	DeadReasonPermanentFailure = "permanent_failure"
+	DeadReasonRepublishFailed  = "republish_failed"
+	DeadReasonMalformedPayload = "malformed_payload"
+)
+
+// DeadLetter is a message that left the retry flow for good, together with the reason and the
+// error history of its attempts.
+type DeadLetter struct {
+	FailedMessage
+	Reason string    `json:"reason"`
+	DeadAt time.Time `json:"deadAt"`
+	// RawBody keeps the original delivery when it could not be parsed as a FailedMessage.
+	RawBody string `json:"rawBody,omitempty"`
+}
+
+func (c *client) deadLetterExchangeName() string {
+	return c.failQueueName + ".dlx"
+}
+
+func (c *client) deadLetterQueueName() string {
+	return c.failQueueName + ".dead"
+}
+
+func (c *client) declareDeadLetterTopology(ch *amqp091.Channel) error {
+	err := ch.ExchangeDeclare(c.deadLetterExchangeName(), amqp091.ExchangeDirect, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(c.deadLetterQueueName(), true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	return ch.QueueBind(c.deadLetterQueueName(), c.failQueueName, c.deadLetterExchangeName(), false, nil)
+}
+
+// deadLetter publishes the message to the dead-letter exchange. The caller keeps the delivery when it
+// fails, the message is only marked 'Dead' once it is in the dead-letter queue.
+func (c *client) deadLetter(ctx context.Context, dl DeadLetter, cause error) error {
+	dl.DeadAt = time.Now()
+	if cause != nil {
+		dl.LastError = cause.Error()
+	}
+
+	err := c.publish(ctx, c.deadLetterExchangeName(), c.failQueueName, dl)
+	if err != nil {
+		log.Error().Err(err).Str("messageId", dl.MessageID).Msg("Failed to publish message to dead-letter queue")
+		return err
+	}
+	metrics.MessagesDead.WithLabelValues(dl.Reason).Inc()
+	return nil
+}
+
+// ListDeadLetters returns up to limit messages from the head of the dead-letter queue without
+// removing them.
+func (c *client) ListDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	var deadLetters []DeadLetter
+	err := c.scanDeadLetters(ctx, limit, func(ch *amqp091.Channel, delivery amqp091.Delivery, dl DeadLetter) (bool, error) {
+		deadLetters = append(deadLetters, dl)
+		return false, nil
+	})
+	return deadLetters, err
+}
+
+// ReplayDeadLetters puts the given messages back to the work queue with their attempt counter reset.
+// prepare is called before each message is republished; returning ErrSkipMessage drops the dead
+// letter without replaying it, any other error leaves it in the dead-letter queue. When the publish
+// is not confirmed after prepare succeeded, restore undoes it and the dead letter stays in the queue.
+func (c *client) ReplayDeadLetters(ctx context.Context, messageIDs []string, prepare, restore func(DeadLetter) error) ([]string, error) {
+	ids := toSet(messageIDs)
+
+	var replayed []string
+	err := c.scanDeadLetters(ctx, 0, func(ch *amqp091.Channel, delivery amqp091.Delivery, dl DeadLetter) (bool, error) {
+		if _, ok := ids[dl.MessageID]; !ok || dl.MessageID == "" {
+			return false, nil
+		}
+
+		err := prepare(dl)
+		if errors.Is(err, ErrSkipMessage) {
+			log.Info().Str("messageId", dl.MessageID).Msg("Dead letter is no longer replayable, dropping it")
+			return true, delivery.Ack(false)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("messageId", dl.MessageID).Msg("Failed to prepare dead letter for replay")
+			return false, nil
+		}
+
+		msg := dl.FailedMessage
+		msg.Attempt = 0
+		msg.RetryAfterSeconds = 0
+		if err := c.publishOn(ctx, ch, "", c.failQueueName, msg); err != nil {
+			if restoreErr := restore(dl); restoreErr != nil {
+				log.Error().Err(restoreErr).Str("messageId", dl.MessageID).Msg("Failed to restore dead letter after a failed replay")
+			}
+			return false, err
+		}
+
+		replayed = append(replayed, dl.MessageID)
+		return true, delivery.Ack(false)
+	})
+	return replayed, err
+}
+
+// PurgeDeadLetters removes the given messages from the dead-letter queue, or every message when no
+// id is given, and returns how many were removed.
+func (c *client) PurgeDeadLetters(ctx context.Context, messageIDs []string) (int, error) {
+	if len(messageIDs) == 0 {
+		ch, err := c.openChannel()
+		if err != nil {
+			return 0, err
+		}
+		defer ch.Close()
+
+		return ch.QueuePurge(c.deadLetterQueueName(), false)
+	}
+
+	ids := toSet(messageIDs)
+
+	purged := 0
+	err := c.scanDeadLetters(ctx, 0, func(ch *amqp091.Channel, delivery amqp091.Delivery, dl DeadLetter) (bool, error) {
+		if _, ok := ids[dl.MessageID]; !ok || dl.MessageID == "" {
+			return false, nil
+		}
+
+		purged++
+		return true, delivery.Ack(false)
+	})
+	return purged, err
+}
+
+// scanDeadLetters walks the dead-letter queue on a dedicated channel, so the consumer's unacked
+// deliveries are never touched. The visitor reports whether it acked the delivery; every other
+// delivery is put back to the queue once the scan is done. A limit of zero scans the whole queue.
+func (c *client) scanDeadLetters(ctx context.Context, limit int,
+	visit func(ch *amqp091.Channel, delivery amqp091.Delivery, dl DeadLetter) (bool, error)) error {
+	ch, err := c.openChannel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	var lastUnackedTag uint64
+	defer func() {
+		if lastUnackedTag == 0 {
+			return
+		}
+		if err := ch.Nack(lastUnackedTag, true, true); err != nil {
+			log.Warn().Err(err).Msg("Failed to requeue scanned dead letters")
+		}
+	}()
+
+	for count := 0; limit <= 0 || count < limit; count++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		delivery, ok, err := ch.Get(c.deadLetterQueueName(), false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+
+		var dl DeadLetter
+		if err := json.Unmarshal(delivery.Body, &dl); err != nil {
+			dl = DeadLetter{Reason: DeadReasonMalformedPayload, RawBody: string(delivery.Body)}
+		}
+
+		acked, err := visit(ch, delivery, dl)
+		if !acked {
+			lastUnackedTag = delivery.DeliveryTag
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
@@ -3,9 +3,16 @@ package rabbitmq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/google/uuid"
+	"github.com/jiin-yang/messageBird/internal/metrics"
+	"github.com/jiin-yang/messageBird/internal/tracing"
 	"github.com/rabbitmq/amqp091-go"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"sync"
 	"time"
 )
 
@@ -19,12 +26,42 @@ const (
 	Dead
 )
 
+// ErrSkipMessage can be returned by the retry task to acknowledge a delivery without retrying it
+// or touching its status, e.g. when the message was cancelled in the meantime.
+var ErrSkipMessage = errors.New("message skipped")
+
+// ErrRequeueMessage can be returned by the retry task to put a delivery back to the queue without
+// counting an attempt, e.g. when the consumer is stopped before the attempt could start.
+var ErrRequeueMessage = errors.New("message requeued")
+
+// RetryError lets the retry task tell the consumer how to continue: permanent failures go straight
+// to `Dead`, RetryAfter delays the next attempt at least that long.
+type RetryError struct {
+	Permanent  bool
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
 type Client interface {
 	PublishFailMessage(ctx context.Context, msg FailedMessage) error
 	Close() error
 	ConsumeFailures(ctx context.Context,
-		retryTask func(FailedMessage) error,
-		updateStatus func(messageID string, status uint8) error, maxRetries int) error
+		retryTask func(context.Context, FailedMessage) error,
+		updateStatus func(messageID string, status uint8) error,
+		scheduleRetry func(ctx context.Context, msg FailedMessage, delay time.Duration) error, maxRetries int) error
+	ListDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error)
+	ReplayDeadLetters(ctx context.Context, messageIDs []string, prepare, restore func(DeadLetter) error) ([]string, error)
+	PurgeDeadLetters(ctx context.Context, messageIDs []string) (int, error)
+	State() ConnectionState
+	Ping() error
 }
 
 type FailedMessage struct {
@@ -33,79 +70,292 @@ type FailedMessage struct {
 	Content     string `json:"content"`
 	Attempt     int    `json:"attempt"`
 	Status      uint8  `json:"status"`
+	// RetryAfterSeconds is the delay requested by a throttled provider for the next attempt.
+	RetryAfterSeconds int              `json:"retryAfterSeconds,omitempty"`
+	LastError         string           `json:"lastError,omitempty"`
+	Failures          []AttemptFailure `json:"failures,omitempty"`
+	// TraceParent is the trace context of the request that created the message, so every retry
+	// attempt can be linked back to it.
+	TraceParent string `json:"traceParent,omitempty"`
+}
+
+// AttemptFailure is the error of a single failed delivery attempt, carried along with the message
+// so the dead-letter queue keeps the whole history.
+type AttemptFailure struct {
+	Attempt  int       `json:"attempt"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// RecordFailure appends the error of the given attempt to the message's failure history.
+func (m *FailedMessage) RecordFailure(attempt int, err error) {
+	m.LastError = err.Error()
+	m.Failures = append(m.Failures, AttemptFailure{
+		Attempt:  attempt,
+		Error:    err.Error(),
+		FailedAt: time.Now(),
+	})
+}
+
+const (
+	retryDelayUnit = 10 * time.Second
+	confirmTimeout = 5 * time.Second
+	// requeueDelay keeps a delivery which cannot be dead-lettered from coming straight back in a tight loop
+	requeueDelay           = 1 * time.Second
+	defaultPrefetchCount   = 10
+	defaultConsumerWorkers = 1
+)
+
+// throttleDelays are the TTLs of the throttle queues. A wait the provider asks for is rounded up to the
+// next one, waits over an hour come back after an hour and are throttled again if need be.
+var throttleDelays = []time.Duration{
+	10 * time.Second,
+	30 * time.Second,
+	1 * time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	30 * time.Minute,
+	1 * time.Hour,
 }
 
 type client struct {
-	conn          *amqp091.Connection
-	channel       *amqp091.Channel
+	url           string
 	failQueueName string
+	maxRetries    int
+
+	prefetchCount   int
+	consumerWorkers int
+
+	mu      sync.RWMutex
+	conn    *amqp091.Connection
+	channel *amqp091.Channel
+	state   ConnectionState
+	closed  bool
+	// reconnected is closed and replaced every time a new connection is established.
+	reconnected chan struct{}
 }
 
-func NewRabbitMQClient(amqpURL, failQueueName string) (Client, error) {
+type NewClientOptions struct {
+	URL           string
+	FailQueueName string
+	// MaxRetries is the number of delay queues declared, one per retry attempt.
+	MaxRetries int
+	// PrefetchCount caps the unacked deliveries the broker pushes to the consumer.
+	PrefetchCount int
+	// ConsumerWorkers is the number of deliveries processed concurrently.
+	ConsumerWorkers int
+}
+
+func NewRabbitMQClient(opts *NewClientOptions) (Client, error) {
+	prefetchCount := opts.PrefetchCount
+	if prefetchCount <= 0 {
+		prefetchCount = defaultPrefetchCount
+	}
+	consumerWorkers := opts.ConsumerWorkers
+	if consumerWorkers <= 0 {
+		consumerWorkers = defaultConsumerWorkers
+	}
+
+	c := &client{
+		url:             opts.URL,
+		failQueueName:   opts.FailQueueName,
+		maxRetries:      opts.MaxRetries,
+		prefetchCount:   prefetchCount,
+		consumerWorkers: consumerWorkers,
+		state:           StateConnecting,
+		reconnected:     make(chan struct{}),
+	}
+
 	for i := 0; i < 5; i++ {
-		conn, err := amqp091.Dial(amqpURL)
+		err := c.connect()
 		if err == nil {
-			ch, err := conn.Channel()
-			if err == nil {
-				_, err = ch.QueueDeclare(failQueueName, true, false, false, false, nil)
-				if err == nil {
-					return &client{conn: conn, channel: ch, failQueueName: failQueueName}, nil
-				}
-			}
-			log.Warn().Err(err).Msg("Retrying RabbitMQ connection...")
-			time.Sleep(5 * time.Second)
-		} else {
-			log.Warn().Err(err).Msg("Failed to connect to RabbitMQ, retrying...")
-			time.Sleep(5 * time.Second)
+			return c, nil
 		}
+
+		log.Warn().Err(err).Msg("Failed to connect to RabbitMQ, retrying...")
+		time.Sleep(5 * time.Second)
 	}
 
 	return nil, fmt.Errorf("could not connect to RabbitMQ after retries")
 }
 
+// declareTopology declares the work queue, the delay queues used for retries and the dead-letter queue. A retry is published
+// to the delay queue of its attempt; when the queue TTL expires, RabbitMQ dead-letters it back to the
+// work queue through the default exchange. When the provider asked to wait longer than the attempt's
+// delay, the retry goes to the throttle queue of the next longer delay in throttleDelays instead. Every
+// queue has a single TTL, so a message never waits behind one which expires later.
+func (c *client) declareTopology(ch *amqp091.Channel) error {
+	_, err := ch.QueueDeclare(c.failQueueName, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; attempt <= c.maxRetries; attempt++ {
+		_, err = ch.QueueDeclare(c.delayQueueName(attempt), true, false, false, false, amqp091.Table{
+			"x-message-ttl":             retryDelay(attempt).Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": c.failQueueName,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, delay := range throttleDelays {
+		_, err = ch.QueueDeclare(c.throttleQueueName(delay), true, false, false, false, amqp091.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": c.failQueueName,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.declareDeadLetterTopology(ch)
+}
+
+func (c *client) delayQueueName(attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", c.failQueueName, attempt)
+}
+
+// throttleQueueName names the throttle queue holding messages for at least delay, e.g.
+// fail_messages.retry.throttle.300 for five minutes. Delays over the longest one are cut to it.
+func (c *client) throttleQueueName(delay time.Duration) string {
+	bucket := throttleDelays[len(throttleDelays)-1]
+	for _, d := range throttleDelays {
+		if d >= delay {
+			bucket = d
+			break
+		}
+	}
+	return fmt.Sprintf("%s.retry.throttle.%d", c.failQueueName, int(bucket.Seconds()))
+}
+
+// PublishFailMessage hands a message over to the retry flow. A message carrying RetryAfterSeconds is
+// parked in a throttle queue until the delay has passed instead of being retried right away.
 func (c *client) PublishFailMessage(ctx context.Context, msg FailedMessage) error {
-	body, err := json.Marshal(msg)
+	retryAfter := time.Duration(msg.RetryAfterSeconds) * time.Second
+	msg.RetryAfterSeconds = 0
+
+	if retryAfter > 0 {
+		return c.publish(ctx, "", c.throttleQueueName(retryAfter), msg)
+	}
+	return c.publish(ctx, "", c.failQueueName, msg)
+}
+
+// publishRetry schedules the next attempt through the delay queue of the attempt, or through a
+// throttle queue when the provider asked to wait longer than that.
+func (c *client) publishRetry(ctx context.Context, msg FailedMessage, retryAfter time.Duration) error {
+	attempt := min(msg.Attempt, c.maxRetries)
+	if attempt >= 1 && retryAfter <= retryDelay(attempt) {
+		return c.publish(ctx, "", c.delayQueueName(attempt), msg)
+	}
+	return c.publish(ctx, "", c.throttleQueueName(c.nextRetryDelay(msg.Attempt, retryAfter)), msg)
+}
+
+// nextRetryDelay is how long publishRetry parks an attempt: the delay of the attempt, or the longer wait
+// the provider asked for.
+func (c *client) nextRetryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	delay := retryDelay(min(attempt, c.maxRetries))
+	if retryAfter > delay {
+		return retryAfter
+	}
+	return delay
+}
+
+func (c *client) publish(ctx context.Context, exchange, routingKey string, payload interface{}) error {
+	ch, _, err := c.currentChannel()
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("exchange", exchange).
+			Str("routingKey", routingKey).
+			Msg("Failed to publish message to RabbitMQ")
+		return err
+	}
+
+	return c.publishOn(ctx, ch, exchange, routingKey, payload)
+}
+
+func (c *client) publishOn(ctx context.Context, ch *amqp091.Channel, exchange, routingKey string, payload interface{}) error {
+	body, err := json.Marshal(payload)
 	if err != nil {
 		log.Error().
 			Err(err).
-			Interface("failedMessage", msg).
-			Msg("Failed to marshal FailedMessage")
+			Interface("payload", payload).
+			Msg("Failed to marshal RabbitMQ payload")
 		return err
 	}
 
-	err = c.channel.PublishWithContext(
+	destination := routingKey
+	if exchange != "" {
+		destination = exchange
+	}
+
+	ctx, span := c.startPublishSpan(ctx, destination)
+	defer span.End()
+
+	headers := amqp091.Table{}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+
+	publishing := amqp091.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp091.Persistent,
+		Headers:      headers,
+		Body:         body,
+	}
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(
 		ctx,
-		"",
-		c.failQueueName,
+		exchange,
+		routingKey,
 		false,
 		false,
-		amqp091.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
+		publishing,
 	)
+	if err == nil {
+		err = waitForConfirm(ctx, confirmation)
+	}
+
+	tracing.RecordError(span, err)
+	metrics.RabbitMQPublished.WithLabelValues(destination, metrics.Result(err)).Inc()
 	if err != nil {
 		log.Error().
 			Err(err).
-			Str("queue", c.failQueueName).
+			Str("exchange", exchange).
+			Str("routingKey", routingKey).
 			Msg("Failed to publish message to RabbitMQ")
 		return err
 	}
 
 	log.Info().
-		Str("queue", c.failQueueName).
-		Str("messageId", msg.MessageID).
-		Msg("Fail message published to RabbitMQ successfully")
+		Str("exchange", exchange).
+		Str("routingKey", routingKey).
+		Msg("Message published to RabbitMQ successfully")
 
 	return nil
 }
 
 func (c *client) Close() error {
-	if err := c.channel.Close(); err != nil {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.state = StateClosed
+	conn, ch := c.conn, c.channel
+	// Consumers waiting for a reconnection wake up and see the client is closed
+	close(c.reconnected)
+	c.mu.Unlock()
+
+	if err := ch.Close(); err != nil {
 		log.Warn().Err(err).Msg("Failed to close RabbitMQ channel")
 	}
 
-	if err := c.conn.Close(); err != nil {
+	if err := conn.Close(); err != nil {
 		log.Warn().Err(err).Msg("Failed to close RabbitMQ connection")
 	}
 	return nil
@@ -113,86 +363,274 @@ func (c *client) Close() error {
 
 func (c *client) ConsumeFailures(
 	ctx context.Context,
-	retryTask func(FailedMessage) error,
+	retryTask func(context.Context, FailedMessage) error,
 	updateStatus func(messageID string, status uint8) error,
+	scheduleRetry func(ctx context.Context, msg FailedMessage, delay time.Duration) error,
 	maxRetries int,
 ) error {
-	messages, err := c.channel.Consume(
-		c.failQueueName,
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to start consuming from fail_messages queue")
-		return err
+	handler := func(ctx context.Context, delivery amqp091.Delivery) {
+		c.handleDelivery(ctx, delivery, retryTask, updateStatus, scheduleRetry, maxRetries)
 	}
 
+	// The outer loop resumes consuming on the new channel after every reconnection
 	for {
-		select {
-		case <-ctx.Done():
-			log.Info().Msg("Stopping consumer due to context cancellation")
-			return nil
-		case delivery, ok := <-messages:
-			if !ok {
-				log.Warn().Msg("RabbitMQ messages channel closed")
+		ch, reconnected, err := c.currentChannel()
+		consumerTag := fmt.Sprintf("%s-%s", c.failQueueName, uuid.NewString())
+		var messages <-chan amqp091.Delivery
+		if err == nil {
+			messages, err = ch.Consume(
+				c.failQueueName,
+				consumerTag,
+				false,
+				false,
+				false,
+				false,
+				nil,
+			)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to start consuming from fail_messages queue, waiting for reconnection")
+			if !c.waitForReconnect(ctx, reconnected) {
 				return nil
 			}
+			continue
+		}
+
+		if !c.consume(ctx, ch, consumerTag, messages, handler) {
+			return nil
+		}
+
+		log.Warn().Msg("RabbitMQ messages channel closed, waiting for reconnection")
+		if !c.waitForReconnect(ctx, reconnected) {
+			return nil
+		}
+	}
+}
+
+// consumerCanceler is the part of *amqp091.Channel consume needs to stop the consumer on the broker.
+type consumerCanceler interface {
+	Cancel(consumer string, noWait bool) error
+}
 
-			var failMsg FailedMessage
-			if err := json.Unmarshal(delivery.Body, &failMsg); err != nil {
-				log.Error().Err(err).Msg("Failed to parse message")
-				if err := updateStatus(failMsg.MessageID, uint8(Dead)); err != nil {
-					log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to update message status to Dead")
+// consume hands deliveries to the configured number of workers until the context is cancelled, in which
+// case it returns false, or the delivery channel is closed by a lost connection, in which case it returns
+// true. On cancellation the consumer is cancelled on the broker, the workers finish the delivery they are
+// working on and every prefetched delivery no worker picked up is nacked back to the queue.
+func (c *client) consume(
+	ctx context.Context,
+	ch consumerCanceler,
+	consumerTag string,
+	messages <-chan amqp091.Delivery,
+	handler func(context.Context, amqp091.Delivery),
+) bool {
+	var wg sync.WaitGroup
+	for i := 0; i < c.consumerWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				// A worker done with its delivery stops before taking a prefetched one
+				if ctx.Err() != nil {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case delivery, ok := <-messages:
+					if !ok {
+						return
+					}
+					handler(ctx, delivery)
 				}
-				delivery.Ack(false)
-				continue
 			}
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() == nil {
+		return true
+	}
 
-			log.Info().Msgf("Processing messageId: %v, Content: %v, Attempt: %d", failMsg.MessageID, failMsg.Content, failMsg.Attempt)
+	log.Info().Msg("Stopping consumer due to context cancellation")
+	if err := ch.Cancel(consumerTag, false); err != nil {
+		log.Warn().Err(err).Msg("Failed to cancel RabbitMQ consumer")
+		return false
+	}
 
-			if failMsg.Attempt >= maxRetries {
-				log.Warn().Str("messageId", failMsg.MessageID).Msg("Max retries reached, discarding message")
-				if err := updateStatus(failMsg.MessageID, uint8(Dead)); err != nil {
-					log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to update message status to Dead")
-				}
-				delivery.Ack(false)
-				continue
+	// After basic.cancel the delivery channel is closed once the deliveries already sent are flushed
+	requeued := 0
+	for delivery := range messages {
+		if err := delivery.Nack(false, true); err != nil {
+			log.Warn().Err(err).Msg("Failed to requeue prefetched delivery")
+			continue
+		}
+		requeued++
+	}
+	if requeued > 0 {
+		log.Info().Int("requeued", requeued).Msg("Requeued prefetched deliveries of the stopped consumer")
+	}
+	return false
+}
+
+// handleDelivery runs one retry attempt. The delivery is only acked once the message went on through the
+// delay queues, the outbox or the dead-letter queue; when none of them can be written it is requeued.
+// scheduleRetry stores the next attempt in the outbox for when the delay queues cannot be published to.
+// Cancelling ctx does not abort the attempt, it only cuts the wait before a requeue short.
+func (c *client) handleDelivery(
+	ctx context.Context,
+	delivery amqp091.Delivery,
+	retryTask func(context.Context, FailedMessage) error,
+	updateStatus func(messageID string, status uint8) error,
+	scheduleRetry func(ctx context.Context, msg FailedMessage, delay time.Duration) error,
+	maxRetries int,
+) {
+	// In-flight deliveries are finished even if the consumer is stopped meanwhile
+	stopped := ctx.Done()
+	ctx = context.WithoutCancel(ctx)
+
+	ack := func(messageID string) {
+		if err := delivery.Ack(false); err != nil {
+			log.Error().Err(err).Str("messageId", messageID).Msg("Failed to ack delivery")
+		}
+	}
+
+	var failMsg FailedMessage
+	parseErr := json.Unmarshal(delivery.Body, &failMsg)
+
+	ctx, span := c.startConsumeSpan(ctx, delivery, failMsg.TraceParent)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("messagebird.message.id", failMsg.MessageID),
+		attribute.Int("messagebird.attempt", failMsg.Attempt),
+	)
+
+	consumed := func(outcome string) {
+		metrics.RabbitMQConsumed.WithLabelValues(outcome).Inc()
+		span.SetAttributes(attribute.String("messagebird.outcome", outcome))
+	}
+
+	// toDead moves the message to the dead-letter queue. If that publish fails too the delivery goes back
+	// to the work queue rather than being dropped; a retry attempt which already ran is then skipped as a
+	// duplicate and the message, still in 'Process', is picked up by the reaper.
+	toDead := func(dl DeadLetter, cause error) {
+		if err := c.deadLetter(ctx, dl, cause); err != nil {
+			log.Error().Err(err).Str("messageId", dl.MessageID).Msg("Dead-letter queue unreachable, requeueing delivery")
+			// A stopping consumer requeues at once instead of holding up the shutdown
+			timer := time.NewTimer(requeueDelay)
+			select {
+			case <-timer.C:
+			case <-stopped:
+				timer.Stop()
+			}
+			if err := delivery.Nack(false, true); err != nil {
+				log.Error().Err(err).Str("messageId", dl.MessageID).Msg("Failed to requeue delivery")
 			}
+			consumed("requeued")
+			return
+		}
+		if err := updateStatus(dl.MessageID, uint8(Dead)); err != nil {
+			log.Error().Err(err).Str("messageId", dl.MessageID).Msg("Failed to update message status to Dead")
+		}
+		ack(dl.MessageID)
+		consumed("dead")
+	}
 
-			err := retryTask(failMsg)
-			if err != nil {
-				log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to process message, retrying")
+	if err := parseErr; err != nil {
+		tracing.RecordError(span, err)
+		log.Error().Err(err).Msg("Failed to parse message")
+		toDead(DeadLetter{Reason: DeadReasonMalformedPayload, RawBody: string(delivery.Body)}, err)
+		return
+	}
 
-				failMsg.Attempt++
-				waitSeconds := fibonacci(failMsg.Attempt) * 10
-				time.Sleep(time.Duration(waitSeconds) * time.Second)
+	log.Info().Msgf("Processing messageId: %v, Content: %v, Attempt: %d", failMsg.MessageID, failMsg.Content, failMsg.Attempt)
 
-				err = c.PublishFailMessage(ctx, failMsg)
-				if err != nil {
-					log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to republish fail message to RabbitMQ")
-					if err := updateStatus(failMsg.MessageID, uint8(Dead)); err != nil {
-						log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to update message status to Dead")
-					}
-				} else {
-					if err := updateStatus(failMsg.MessageID, uint8(Fail)); err != nil {
-						log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to update message status to Fail")
-					}
-				}
-				delivery.Ack(false)
-			} else {
-				log.Info().Str("messageId", failMsg.MessageID).Msg("Message processed successfully")
+	if failMsg.Attempt >= maxRetries {
+		log.Warn().Str("messageId", failMsg.MessageID).Msg("Max retries reached, moving message to dead-letter queue")
+		toDead(DeadLetter{FailedMessage: failMsg, Reason: DeadReasonMaxRetries}, nil)
+		return
+	}
 
-				if err := updateStatus(failMsg.MessageID, uint8(Sent)); err != nil {
-					log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to update message status to Sent")
-				}
-				delivery.Ack(false)
+	err := retryTask(ctx, failMsg)
+	if errors.Is(err, ErrSkipMessage) {
+		log.Info().Str("messageId", failMsg.MessageID).Msg("Message skipped by retry task")
+		ack(failMsg.MessageID)
+		consumed("skipped")
+		return
+	}
+	if errors.Is(err, ErrRequeueMessage) {
+		log.Info().Str("messageId", failMsg.MessageID).Msg("Message requeued by retry task")
+		if err := delivery.Nack(false, true); err != nil {
+			log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to requeue delivery")
+		}
+		consumed("requeued")
+		return
+	}
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) && retryErr.Permanent {
+		log.Warn().Err(err).Str("messageId", failMsg.MessageID).Msg("Permanent failure, not retrying")
+		tracing.RecordError(span, err)
+		failMsg.RecordFailure(failMsg.Attempt+1, err)
+		toDead(DeadLetter{FailedMessage: failMsg, Reason: DeadReasonPermanentFailure}, nil)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to process message, retrying")
+		tracing.RecordError(span, err)
+
+		// The consumer never waits here; the next attempt comes back through the delay queue
+		var retryAfter time.Duration
+		if retryErr != nil {
+			retryAfter = retryErr.RetryAfter
+		}
+		failMsg.RecordFailure(failMsg.Attempt+1, err)
+		failMsg.Attempt++
+		metrics.MessagesFailed.WithLabelValues("retry").Inc()
+
+		err = c.publishRetry(ctx, failMsg, retryAfter)
+		if err != nil {
+			log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to republish fail message to RabbitMQ, scheduling it through the outbox")
+			// The outbox relay publishes the attempt once the delay has passed and RabbitMQ takes messages again
+			if scheduleErr := scheduleRetry(ctx, failMsg, c.nextRetryDelay(failMsg.Attempt, retryAfter)); scheduleErr != nil {
+				log.Error().Err(scheduleErr).Str("messageId", failMsg.MessageID).Msg("Failed to schedule retry through the outbox")
+				toDead(DeadLetter{FailedMessage: failMsg, Reason: DeadReasonRepublishFailed}, errors.Join(err, scheduleErr))
+				return
 			}
 		}
+
+		if err := updateStatus(failMsg.MessageID, uint8(Fail)); err != nil {
+			log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to update message status to Fail")
+		}
+		ack(failMsg.MessageID)
+		consumed("retried")
+		return
+	}
+
+	log.Info().Str("messageId", failMsg.MessageID).Msg("Message processed successfully")
+	metrics.MessagesSent.WithLabelValues("retry").Inc()
+	consumed("sent")
+
+	if err := updateStatus(failMsg.MessageID, uint8(Sent)); err != nil {
+		log.Error().Err(err).Str("messageId", failMsg.MessageID).Msg("Failed to update message status to Sent")
 	}
+	ack(failMsg.MessageID)
+}
+
+func waitForConfirm(ctx context.Context, confirmation *amqp091.DeferredConfirmation) error {
+	ctx, cancel := context.WithTimeout(ctx, confirmTimeout)
+	defer cancel()
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishNotConfirmed, err)
+	}
+	if !acked {
+		return ErrPublishNotConfirmed
+	}
+	return nil
+}
+
+func retryDelay(attempt int) time.Duration {
+	return time.Duration(fibonacci(attempt)) * retryDelayUnit
 }
 
 func fibonacci(n int) int {
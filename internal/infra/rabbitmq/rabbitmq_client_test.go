@@ -0,0 +1,323 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rabbitmq/amqp091-go"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type acknowledger struct {
+	acked   bool
+	nacked  bool
+	requeue bool
+}
+
+func (a *acknowledger) Ack(uint64, bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *acknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	a.nacked = true
+	a.requeue = requeue
+	return nil
+}
+
+func (a *acknowledger) Reject(_ uint64, requeue bool) error {
+	a.nacked = true
+	a.requeue = requeue
+	return nil
+}
+
+type scheduledRetry struct {
+	msg   FailedMessage
+	delay time.Duration
+}
+
+// disconnectedClient fails every publish with ErrNotConnected.
+func disconnectedClient() *client {
+	return &client{failQueueName: "fail_messages", maxRetries: 5, state: StateConnecting}
+}
+
+func newDelivery(t *testing.T, msg FailedMessage) (amqp091.Delivery, *acknowledger) {
+	t.Helper()
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack := &acknowledger{}
+	return amqp091.Delivery{Acknowledger: ack, Body: body}, ack
+}
+
+func TestHandleDeliveryFallsBackToTheOutbox(t *testing.T) {
+	c := disconnectedClient()
+	delivery, ack := newDelivery(t, FailedMessage{MessageID: "m1", Attempt: 1})
+
+	var scheduled []scheduledRetry
+	statuses := map[string]uint8{}
+	c.handleDelivery(context.Background(), delivery,
+		func(context.Context, FailedMessage) error {
+			return &RetryError{RetryAfter: 45 * time.Second, Err: errors.New("throttled")}
+		},
+		func(messageID string, status uint8) error {
+			statuses[messageID] = status
+			return nil
+		},
+		func(_ context.Context, msg FailedMessage, delay time.Duration) error {
+			scheduled = append(scheduled, scheduledRetry{msg: msg, delay: delay})
+			return nil
+		},
+		5,
+	)
+
+	if len(scheduled) != 1 {
+		t.Fatalf("scheduled %d retries, want 1", len(scheduled))
+	}
+	if got := scheduled[0]; got.msg.Attempt != 2 || got.delay != 45*time.Second || len(got.msg.Failures) != 1 {
+		t.Errorf("scheduled retry = attempt %d, delay %v, %d failures; want attempt 2, 45s, 1 failure",
+			got.msg.Attempt, got.delay, len(got.msg.Failures))
+	}
+	if statuses["m1"] != uint8(Fail) {
+		t.Errorf("status = %d, want Fail", statuses["m1"])
+	}
+	if !ack.acked || ack.nacked {
+		t.Errorf("delivery acked = %v, nacked = %v; want acked", ack.acked, ack.nacked)
+	}
+}
+
+func TestHandleDeliveryRequeuesWhenNothingCanBeWritten(t *testing.T) {
+	c := disconnectedClient()
+	delivery, ack := newDelivery(t, FailedMessage{MessageID: "m1", Attempt: 1})
+
+	statuses := map[string]uint8{}
+	c.handleDelivery(context.Background(), delivery,
+		func(context.Context, FailedMessage) error { return errors.New("timeout") },
+		func(messageID string, status uint8) error {
+			statuses[messageID] = status
+			return nil
+		},
+		func(context.Context, FailedMessage, time.Duration) error { return errors.New("mongo down") },
+		5,
+	)
+
+	if len(statuses) != 0 {
+		t.Errorf("statuses = %v, want none changed", statuses)
+	}
+	if ack.acked || !ack.nacked || !ack.requeue {
+		t.Errorf("delivery acked = %v, nacked = %v, requeue = %v; want requeued", ack.acked, ack.nacked, ack.requeue)
+	}
+}
+
+func TestHandleDeliveryRequeuesWhenTheDeadLetterQueueIsUnreachable(t *testing.T) {
+	c := disconnectedClient()
+	delivery, ack := newDelivery(t, FailedMessage{MessageID: "m1", Attempt: 5})
+
+	retried := false
+	statuses := map[string]uint8{}
+	c.handleDelivery(context.Background(), delivery,
+		func(context.Context, FailedMessage) error {
+			retried = true
+			return nil
+		},
+		func(messageID string, status uint8) error {
+			statuses[messageID] = status
+			return nil
+		},
+		func(context.Context, FailedMessage, time.Duration) error { return nil },
+		5,
+	)
+
+	if retried {
+		t.Error("retry task ran for a message over the retry limit")
+	}
+	if _, ok := statuses["m1"]; ok {
+		t.Errorf("status changed to %d before the message reached the dead-letter queue", statuses["m1"])
+	}
+	if ack.acked || !ack.requeue {
+		t.Errorf("delivery acked = %v, requeue = %v; want requeued", ack.acked, ack.requeue)
+	}
+}
+
+func TestHandleDeliveryRequeuesWithoutAnAttempt(t *testing.T) {
+	c := disconnectedClient()
+	delivery, ack := newDelivery(t, FailedMessage{MessageID: "m1", Attempt: 1})
+
+	scheduled := false
+	c.handleDelivery(context.Background(), delivery,
+		func(context.Context, FailedMessage) error { return ErrRequeueMessage },
+		func(string, uint8) error { return nil },
+		func(context.Context, FailedMessage, time.Duration) error {
+			scheduled = true
+			return nil
+		},
+		5,
+	)
+
+	if scheduled {
+		t.Error("a requeued delivery was scheduled as a failed attempt")
+	}
+	if ack.acked || !ack.requeue {
+		t.Errorf("delivery acked = %v, requeue = %v; want requeued", ack.acked, ack.requeue)
+	}
+}
+
+func TestThrottleQueueName(t *testing.T) {
+	c := &client{failQueueName: "fail_messages"}
+
+	tests := []struct {
+		delay time.Duration
+		want  string
+	}{
+		{0, "fail_messages.retry.throttle.10"},
+		{10 * time.Second, "fail_messages.retry.throttle.10"},
+		{11 * time.Second, "fail_messages.retry.throttle.30"},
+		{4 * time.Minute, "fail_messages.retry.throttle.300"},
+		{3 * time.Hour, "fail_messages.retry.throttle.3600"},
+	}
+	for _, tt := range tests {
+		if got := c.throttleQueueName(tt.delay); got != tt.want {
+			t.Errorf("throttleQueueName(%v) = %s, want %s", tt.delay, got, tt.want)
+		}
+	}
+}
+
+func TestHandleDeliveryRequeuesAtOnceWhenTheConsumerStops(t *testing.T) {
+	c := disconnectedClient()
+	delivery, ack := newDelivery(t, FailedMessage{MessageID: "m1", Attempt: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var taskErr error
+	start := time.Now()
+	c.handleDelivery(ctx, delivery,
+		func(ctx context.Context, _ FailedMessage) error {
+			taskErr = ctx.Err()
+			return &RetryError{Permanent: true, Err: errors.New("invalid number")}
+		},
+		func(string, uint8) error { return nil },
+		func(context.Context, FailedMessage, time.Duration) error { return nil },
+		5,
+	)
+
+	if taskErr != nil {
+		t.Errorf("retry task context error = %v, want the attempt to run to the end", taskErr)
+	}
+	if elapsed := time.Since(start); elapsed >= requeueDelay {
+		t.Errorf("requeue took %v, want it without waiting for the requeue delay", elapsed)
+	}
+	if ack.acked || !ack.requeue {
+		t.Errorf("delivery acked = %v, requeue = %v; want requeued", ack.acked, ack.requeue)
+	}
+}
+
+func TestNextRetryDelay(t *testing.T) {
+	c := &client{failQueueName: "fail_messages", maxRetries: 4}
+
+	tests := []struct {
+		attempt    int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{attempt: 1, want: 10 * time.Second},
+		{attempt: 2, want: 10 * time.Second},
+		{attempt: 3, want: 20 * time.Second},
+		{attempt: 4, want: 30 * time.Second},
+		// Attempts past the last delay queue keep its delay
+		{attempt: 5, want: 30 * time.Second},
+		{attempt: 9, want: 30 * time.Second},
+		// A longer wait asked for by the provider wins, a shorter one does not
+		{attempt: 2, retryAfter: 2 * time.Minute, want: 2 * time.Minute},
+		{attempt: 3, retryAfter: 5 * time.Second, want: 20 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := c.nextRetryDelay(tt.attempt, tt.retryAfter); got != tt.want {
+			t.Errorf("nextRetryDelay(%d, %v) = %v, want %v", tt.attempt, tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+// brokerChannel closes the delivery channel on basic.cancel, as the broker does once the prefetched
+// deliveries are flushed.
+type brokerChannel struct {
+	messages  chan amqp091.Delivery
+	cancelled string
+}
+
+func (b *brokerChannel) Cancel(consumer string, _ bool) error {
+	b.cancelled = consumer
+	close(b.messages)
+	return nil
+}
+
+func TestConsumeRequeuesPrefetchedDeliveriesOnCancel(t *testing.T) {
+	c := &client{consumerWorkers: 2}
+
+	const prefetched = 5
+	ch := &brokerChannel{messages: make(chan amqp091.Delivery, prefetched)}
+	acks := map[string]*acknowledger{}
+	for i := range prefetched {
+		id := fmt.Sprintf("m%d", i+1)
+		delivery, ack := newDelivery(t, FailedMessage{MessageID: id, Attempt: 1})
+		delivery.MessageId = id
+		acks[id] = ack
+		ch.messages <- delivery
+	}
+
+	var (
+		mu      sync.Mutex
+		handled []string
+	)
+	started := make(chan struct{}, c.consumerWorkers)
+	release := make(chan struct{})
+	handler := func(_ context.Context, delivery amqp091.Delivery) {
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		handled = append(handled, delivery.MessageId)
+		mu.Unlock()
+		_ = delivery.Ack(false)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan bool, 1)
+	go func() { result <- c.consume(ctx, ch, "consumer-1", ch.messages, handler) }()
+
+	for range c.consumerWorkers {
+		<-started
+	}
+	cancel()
+	close(release)
+
+	select {
+	case reconnect := <-result:
+		if reconnect {
+			t.Error("consume() = true, want false after the context is cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("consume() did not return after the context was cancelled")
+	}
+
+	if ch.cancelled != "consumer-1" {
+		t.Errorf("cancelled consumer = %q, want consumer-1", ch.cancelled)
+	}
+	if len(handled) != c.consumerWorkers {
+		t.Errorf("handled = %v, want only the %d deliveries in flight", handled, c.consumerWorkers)
+	}
+	for id, ack := range acks {
+		inFlight := slices.Contains(handled, id)
+		switch {
+		case inFlight && (!ack.acked || ack.nacked):
+			t.Errorf("%s in flight: acked = %v, nacked = %v; want it finished and acked", id, ack.acked, ack.nacked)
+		case !inFlight && (!ack.nacked || !ack.requeue):
+			t.Errorf("%s prefetched: nacked = %v, requeue = %v; want it requeued", id, ack.nacked, ack.requeue)
+		}
+	}
+}
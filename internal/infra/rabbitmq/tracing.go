@@ -0,0 +1,58 @@
+package rabbitmq
+
+import (
+	"context"
+	"github.com/jiin-yang/messageBird/internal/tracing"
+	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier lets the OpenTelemetry propagator read and write the trace context in AMQP headers.
+type headerCarrier amqp091.Table
+
+func (h headerCarrier) Get(key string) string {
+	value, ok := h[key].(string)
+	if !ok {
+		return ""
+	}
+	return value
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h[key] = value
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (c *client) startPublishSpan(ctx context.Context, destination string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, destination+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.operation.type", "publish"),
+			attribute.String("messaging.destination.name", destination),
+		))
+}
+
+// startConsumeSpan continues the trace of the publisher found in the delivery headers. The span is also
+// linked to the request that created the message, which the publisher may no longer be part of.
+func (c *client) startConsumeSpan(ctx context.Context, delivery amqp091.Delivery, traceParent string) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(delivery.Headers))
+
+	opts := append(tracing.LinkTo(traceParent),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.operation.type", "process"),
+			attribute.String("messaging.destination.name", c.failQueueName),
+		))
+	return tracing.Tracer().Start(ctx, c.failQueueName+" process", opts...)
+}
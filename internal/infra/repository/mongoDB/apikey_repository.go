@@ -0,0 +1,139 @@
+package mongoDB
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jiin-yang/messageBird/internal/apikey"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+const apiKeysCollection = "api_keys"
+
+type apiKeyRepo struct {
+	client     *Client
+	collection *mongo.Collection
+}
+
+type NewAPIKeyRepositoryOpts struct {
+	Client *Client
+}
+
+func NewAPIKeyRepository(opts *NewAPIKeyRepositoryOpts) apikey.Repository {
+	return &apiKeyRepo{
+		client:     opts.Client,
+		collection: opts.Client.Database.Collection(apiKeysCollection),
+	}
+}
+
+func (r apiKeyRepo) CreateAPIKey(ctx context.Context, key apikey.APIKey) (*apikey.APIKey, error) {
+	dbData := newAPIKeyDocument(key, time.Now())
+
+	_, err := r.collection.InsertOne(ctx, dbData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	created := dbData.toDomain()
+	return &created, nil
+}
+
+func (r apiKeyRepo) GetActiveAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
+	var dbKey APIKey
+	err := r.collection.FindOne(ctx, bson.M{"hash": hash, "revokedAt": bson.M{"$exists": false}}).Decode(&dbKey)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, apikey.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+
+	key := dbKey.toDomain()
+	return &key, nil
+}
+
+func (r apiKeyRepo) ListAPIKeys(ctx context.Context) ([]apikey.APIKey, error) {
+	cur, err := r.collection.Find(ctx, scopeToTenant(ctx, bson.M{}), options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	var keys []apikey.APIKey
+	for cur.Next(ctx) {
+		var dbKey APIKey
+		if decodeErr := cur.Decode(&dbKey); decodeErr != nil {
+			return nil, decodeErr
+		}
+		keys = append(keys, dbKey.toDomain())
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r apiKeyRepo) RevokeAPIKey(ctx context.Context, keyID string) (*apikey.APIKey, error) {
+	objID, err := bson.ObjectIDFromHex(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", apikey.ErrInvalidAPIKeyID, err)
+	}
+
+	return r.revoke(ctx, objID, time.Now())
+}
+
+func (r apiKeyRepo) RotateAPIKey(ctx context.Context, keyID string, replacement apikey.APIKey) (*apikey.APIKey, error) {
+	objID, err := bson.ObjectIDFromHex(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", apikey.ErrInvalidAPIKeyID, err)
+	}
+
+	timeNow := time.Now()
+	var rotated APIKey
+	err = r.client.WithTransaction(ctx, func(ctx context.Context) error {
+		revoked, err := r.revoke(ctx, objID, timeNow)
+		if err != nil {
+			return err
+		}
+
+		replacement.Name = revoked.Name
+		replacement.Scopes = revoked.Scopes
+		replacement.TenantID = revoked.TenantID
+		rotated = newAPIKeyDocument(replacement, timeNow)
+
+		_, err = r.collection.InsertOne(ctx, rotated)
+		if err != nil {
+			return fmt.Errorf("failed to create api key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key := rotated.toDomain()
+	return &key, nil
+}
+
+// revoke only matches keys that are still active, so a revoked key cannot be rotated again.
+func (r apiKeyRepo) revoke(ctx context.Context, objID bson.ObjectID, timeNow time.Time) (*apikey.APIKey, error) {
+	filter := scopeToTenant(ctx, bson.M{"_id": objID, "revokedAt": bson.M{"$exists": false}})
+	update := bson.M{"$set": bson.M{"revokedAt": timeNow}}
+
+	var dbKey APIKey
+	err := r.collection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&dbKey)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, apikey.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	key := dbKey.toDomain()
+	return &key, nil
+}
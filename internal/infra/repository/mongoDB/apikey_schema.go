@@ -0,0 +1,46 @@
+package mongoDB
+
+import (
+	"github.com/jiin-yang/messageBird/internal/apikey"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+type APIKey struct {
+	ID          bson.ObjectID `bson:"_id"`
+	Name        string        `bson:"name"`
+	Prefix      string        `bson:"prefix"`
+	Hash        string        `bson:"hash"`
+	Scopes      []string      `bson:"scopes"`
+	TenantID    string        `bson:"tenantId"`
+	RotatedFrom string        `bson:"rotatedFrom,omitempty"`
+	CreatedAt   *time.Time    `bson:"createdAt"`
+	RevokedAt   *time.Time    `bson:"revokedAt,omitempty"`
+}
+
+func (k APIKey) toDomain() apikey.APIKey {
+	return apikey.APIKey{
+		Id:          k.ID.Hex(),
+		Name:        k.Name,
+		Prefix:      k.Prefix,
+		Hash:        k.Hash,
+		Scopes:      k.Scopes,
+		TenantID:    k.TenantID,
+		RotatedFrom: k.RotatedFrom,
+		CreatedAt:   k.CreatedAt,
+		RevokedAt:   k.RevokedAt,
+	}
+}
+
+func newAPIKeyDocument(key apikey.APIKey, timeNow time.Time) APIKey {
+	return APIKey{
+		ID:          bson.NewObjectID(),
+		Name:        key.Name,
+		Prefix:      key.Prefix,
+		Hash:        key.Hash,
+		Scopes:      key.Scopes,
+		TenantID:    key.TenantID,
+		RotatedFrom: key.RotatedFrom,
+		CreatedAt:   &timeNow,
+	}
+}
@@ -1,9 +1,11 @@
 package mongoDB
 
 import (
+	"context"
 	"github.com/jiin-yang/messageBird/config"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
 )
 
 type Client struct {
@@ -12,7 +14,10 @@ type Client struct {
 
 func NewClient(config *config.MongoDBConfig) (c *Client, err error) {
 	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
-	opts := options.Client().ApplyURI(config.Host).SetServerAPIOptions(serverAPI)
+	opts := options.Client().
+		ApplyURI(config.Host).
+		SetServerAPIOptions(serverAPI).
+		SetMonitor(newCommandMonitor())
 	mongoClient, err := mongo.Connect(opts)
 	if err != nil {
 		return nil, err
@@ -23,3 +28,26 @@ func NewClient(config *config.MongoDBConfig) (c *Client, err error) {
 
 	return &client, nil
 }
+
+// WithTransaction runs fn inside a transaction. fn may be retried on transient errors, so it must only
+// use the context it is given. Transactions require MongoDB to run as a replica set.
+func (c *Client) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := c.Database.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	return c.Database.Client().Ping(ctx, readpref.Primary())
+}
+
+func (c *Client) Close(ctx context.Context) error {
+	return c.Database.Client().Disconnect(ctx)
+}
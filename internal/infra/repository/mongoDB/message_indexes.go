@@ -0,0 +1,181 @@
+package mongoDB
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+func messageIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// cron claim, reaper and listing by status, all paged/sorted by _id
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("status_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "phoneNumber", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("phoneNumber_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "createdAt", Value: 1}},
+			Options: options.Index().SetName("createdAt"),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "updatedAt", Value: 1}},
+			Options: options.Index().SetName("status_updatedAt"),
+		},
+		{
+			// expiry sweep of undelivered messages
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt").SetSparse(true),
+		},
+		{
+			// listing of a single tenant
+			Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("tenantId_status_id"),
+		},
+		{
+			// only messages created with an Idempotency-Key header carry the field, keys are unique per tenant
+			Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "idempotencyKey", Value: 1}},
+			Options: options.Index().SetName("tenantId_idempotencyKey_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"idempotencyKey": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "content", Value: "text"}},
+			Options: options.Index().SetName("content_text"),
+		},
+	}
+}
+
+// EnsureMessageIndexes creates the indexes the message repository relies on. CreateMany is a no-op
+// for indexes that already exist with the same definition, so it is safe to run on every start.
+func EnsureMessageIndexes(ctx context.Context, client *Client) error {
+	indexes := client.Database.Collection(messagesCollection).Indexes()
+
+	// Idempotency keys used to be unique across tenants
+	err := indexes.DropOne(ctx, "idempotencyKey_unique")
+	if err != nil && !isIndexNotFound(err) {
+		return fmt.Errorf("failed to drop the idempotencyKey_unique index: %w", err)
+	}
+
+	_, err = indexes.CreateMany(ctx, messageIndexes())
+	if err != nil {
+		return fmt.Errorf("failed to create message indexes: %w", err)
+	}
+	return nil
+}
+
+const deliveredRetention = 7 * 24 * time.Hour
+
+func outboxIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// relay claim of entries that are due
+			Keys:    bson.D{{Key: "nextAttemptAt", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("nextAttemptAt_id"),
+		},
+		{
+			// delivered entries are only kept for troubleshooting, undelivered ones have no deliveredAt
+			Keys: bson.D{{Key: "deliveredAt", Value: 1}},
+			Options: options.Index().SetName("deliveredAt_ttl").
+				SetExpireAfterSeconds(int32(deliveredRetention.Seconds())),
+		},
+	}
+}
+
+func processedDeliveryIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// consumer side dedupe of queue messages
+			Keys:    bson.D{{Key: "messageId", Value: 1}, {Key: "attempt", Value: 1}},
+			Options: options.Index().SetName("messageId_attempt_unique").SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "processedAt", Value: 1}},
+			Options: options.Index().SetName("processedAt_ttl").
+				SetExpireAfterSeconds(int32(deliveredRetention.Seconds())),
+		},
+	}
+}
+
+// EnsureOutboxIndexes creates the indexes the outbox relay and the consumer dedupe rely on.
+func EnsureOutboxIndexes(ctx context.Context, client *Client) error {
+	_, err := client.Database.Collection(outboxCollection).Indexes().CreateMany(ctx, outboxIndexes())
+	if err != nil {
+		return fmt.Errorf("failed to create outbox indexes: %w", err)
+	}
+
+	_, err = client.Database.Collection(processedDeliveriesCollection).Indexes().CreateMany(ctx, processedDeliveryIndexes())
+	if err != nil {
+		return fmt.Errorf("failed to create processed delivery indexes: %w", err)
+	}
+	return nil
+}
+
+func apiKeyIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// every authenticated request looks its key up by hash
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash_unique").SetUnique(true),
+		},
+	}
+}
+
+// EnsureAPIKeyIndexes creates the indexes the API key repository relies on.
+func EnsureAPIKeyIndexes(ctx context.Context, client *Client) error {
+	_, err := client.Database.Collection(apiKeysCollection).Indexes().CreateMany(ctx, apiKeyIndexes())
+	if err != nil {
+		return fmt.Errorf("failed to create api key indexes: %w", err)
+	}
+	return nil
+}
+
+func tenantUsageIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// counters of past quota periods are removed once expireAt passed
+			Keys:    bson.D{{Key: "expireAt", Value: 1}},
+			Options: options.Index().SetName("expireAt_ttl").SetExpireAfterSeconds(0),
+		},
+	}
+}
+
+// EnsureTenantIndexes creates the indexes of the tenant usage counters.
+func EnsureTenantIndexes(ctx context.Context, client *Client) error {
+	_, err := client.Database.Collection(tenantUsageCollection).Indexes().CreateMany(ctx, tenantUsageIndexes())
+	if err != nil {
+		return fmt.Errorf("failed to create tenant usage indexes: %w", err)
+	}
+	return nil
+}
+
+func rateLimitIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// counters of past windows are removed once expireAt passed
+			Keys:    bson.D{{Key: "expireAt", Value: 1}},
+			Options: options.Index().SetName("expireAt_ttl").SetExpireAfterSeconds(0),
+		},
+	}
+}
+
+// EnsureRateLimitIndexes creates the indexes of the rate limit window counters.
+func EnsureRateLimitIndexes(ctx context.Context, client *Client) error {
+	_, err := client.Database.Collection(rateLimitWindowsCollection).Indexes().CreateMany(ctx, rateLimitIndexes())
+	if err != nil {
+		return fmt.Errorf("failed to create rate limit indexes: %w", err)
+	}
+	return nil
+}
+
+// isIndexNotFound reports a dropped index or collection which did not exist.
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 27 || cmdErr.Code == 26)
+}
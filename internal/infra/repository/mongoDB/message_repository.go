@@ -2,6 +2,7 @@ package mongoDB
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/jiin-yang/messageBird/internal/message"
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -11,13 +12,16 @@ import (
 )
 
 const (
-	messagesCollection = "messages"
-	getMessageLimit    = 2
+	messagesCollection  = "messages"
+	recoverMessageLimit = 100
 )
 
 type repo struct {
 	client     *Client
 	collection *mongo.Collection
+	outbox     *mongo.Collection
+
+	processedDeliveries *mongo.Collection
 }
 
 type NewMessageRepositoryOpts struct {
@@ -28,6 +32,9 @@ func NewMessageRepository(opts *NewMessageRepositoryOpts) message.Repository {
 	return &repo{
 		client:     opts.Client,
 		collection: opts.Client.Database.Collection(messagesCollection),
+		outbox:     opts.Client.Database.Collection(outboxCollection),
+
+		processedDeliveries: opts.Client.Database.Collection(processedDeliveriesCollection),
 	}
 }
 
@@ -40,11 +47,26 @@ func (r repo) CreateMessage(ctx context.Context, msgData message.CreateMessage)
 		PhoneNumber: msgData.PhoneNumber,
 		Content:     msgData.Content,
 		Status:      msgData.Status,
-		CreatedAt:   &timeNow,
+		SendAt:      msgData.SendAt,
+		ExpiresAt:   msgData.ExpiresAt,
+
+		IdempotencyKey:     msgData.IdempotencyKey,
+		RequestFingerprint: msgData.RequestFingerprint,
+		TraceParent:        msgData.TraceParent,
+		RequestID:          msgData.RequestID,
+		TenantID:           msgData.TenantID,
+
+		History: []Transition{
+			{To: msgData.Status, At: &timeNow},
+		},
+		CreatedAt: &timeNow,
 	}
 
 	insertResult, err := r.collection.InsertOne(ctx, dbData)
 	if err != nil {
+		if msgData.IdempotencyKey != "" && mongo.IsDuplicateKeyError(err) {
+			return nil, message.ErrDuplicateIdempotencyKey
+		}
 		return nil, err
 	}
 
@@ -56,82 +78,428 @@ func (r repo) CreateMessage(ctx context.Context, msgData message.CreateMessage)
 		PhoneNumber: msgData.PhoneNumber,
 		Content:     msgData.Content,
 		Status:      msgData.Status,
+		SendAt:      msgData.SendAt,
+		ExpiresAt:   msgData.ExpiresAt,
 		CreatedAt:   &timeNow,
 	}
 
 	return &createdMessage, nil
 }
 
-func (r repo) GetOldestStatusNewMessages(ctx context.Context) ([]message.Message, error) {
-	filter := bson.M{"status": message.New}
+// CreateMessages inserts all messages with a single unordered insertMany, so one failing document
+// does not stop the rest of the batch. Failures are reported per item.
+func (r repo) CreateMessages(ctx context.Context, msgData []message.CreateMessage) ([]message.CreateMessagesResult, error) {
+	timeNow := time.Now()
+
+	dbData := make([]Message, 0, len(msgData))
+	results := make([]message.CreateMessagesResult, 0, len(msgData))
+	for _, msg := range msgData {
+		objID := bson.NewObjectID()
+
+		dbData = append(dbData, Message{
+			ID:          objID,
+			PhoneNumber: msg.PhoneNumber,
+			Content:     msg.Content,
+			Status:      msg.Status,
+			SendAt:      msg.SendAt,
+			ExpiresAt:   msg.ExpiresAt,
+			TraceParent: msg.TraceParent,
+			RequestID:   msg.RequestID,
+			TenantID:    msg.TenantID,
+			History: []Transition{
+				{To: msg.Status, At: &timeNow},
+			},
+			CreatedAt: &timeNow,
+		})
+
+		results = append(results, message.CreateMessagesResult{
+			Message: &message.CreatedMessageDbResponse{
+				Id:          objID.Hex(),
+				PhoneNumber: msg.PhoneNumber,
+				Content:     msg.Content,
+				Status:      msg.Status,
+				SendAt:      msg.SendAt,
+				ExpiresAt:   msg.ExpiresAt,
+				CreatedAt:   &timeNow,
+			},
+		})
+	}
+
+	_, err := r.collection.InsertMany(ctx, dbData, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || len(bulkErr.WriteErrors) == 0 {
+			return nil, fmt.Errorf("failed to insert messages: %w", err)
+		}
 
+		for _, writeErr := range bulkErr.WriteErrors {
+			results[writeErr.Index].Message = nil
+			results[writeErr.Index].Err = fmt.Errorf("failed to insert message: %w", writeErr)
+		}
+	}
+
+	return results, nil
+}
+
+// ClaimMessages atomically moves the oldest due `New`/`Scheduled` messages to `Process` one by one with
+// findOneAndUpdate, so two instances running the cron at the same time can never pick the same message.
+// The lease owner and expiry are written on the document so a stuck claim can be detected later.
+func (r repo) ClaimMessages(ctx context.Context, owner string, limit int, leaseDuration time.Duration) ([]message.Message, error) {
 	// NOT: Sort isleminde neden `_id` kullandim ?
 	// mongoDB object id'si time bazli oldugu icin ve indexli oldugu icin createdAt yerine _id kullanmayi uygun gordum
 	// Eger ki Create isleminin birden fazla server, client veya instance tarafindan yapilacagi bir senaryo olsa idi
 	// createdAt alanini kullanirdim.
-	findOpts := options.Find().
+	findOpts := options.FindOneAndUpdate().
 		SetSort(bson.D{{Key: "_id", Value: 1}}).
-		SetLimit(getMessageLimit)
+		SetReturnDocument(options.After)
 
-	cur, err := r.collection.Find(ctx, filter, findOpts)
-	if err != nil {
-		return nil, err
+	var result []message.Message
+
+	for i := 0; i < limit; i++ {
+		timeNow := time.Now()
+		filter := scopeToTenant(ctx, dueMessagesFilter(timeNow))
+		update := mongo.Pipeline{
+			statusTransitionSet(message.Process, timeNow, bson.M{
+				"leaseOwner":     bson.M{"$literal": owner},
+				"leaseExpiresAt": timeNow.Add(leaseDuration),
+			}),
+		}
+
+		var dbMsg Message
+		err := r.collection.FindOneAndUpdate(ctx, filter, update, findOpts).Decode(&dbMsg)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to claim message: %w", err)
+		}
+
+		result = append(result, dbMsg.toDomain())
 	}
-	defer cur.Close(ctx)
+
+	return result, nil
+}
+
+// RecoverStuckMessages moves `Process` messages that were not touched since stuckBefore and whose lease
+// has expired (or legacy ones claimed before leases existed) to newStatus. Each message is released with its
+// own findOneAndUpdate so a message that finishes sending in the meantime is not overwritten.
+func (r repo) RecoverStuckMessages(ctx context.Context, stuckBefore time.Time, newStatus message.Status) ([]message.Message, error) {
+	timeNow := time.Now()
+	filter := stuckFilter(ctx, stuckBefore, timeNow)
+	update := statusTransitionUpdate(newStatus, timeNow)
+	findOpts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
 
 	var result []message.Message
 
-	for cur.Next(ctx) {
+	for i := 0; i < recoverMessageLimit; i++ {
 		var dbMsg Message
-		if decodeErr := cur.Decode(&dbMsg); decodeErr != nil {
-			return nil, decodeErr
+		err := r.collection.FindOneAndUpdate(ctx, filter, update, findOpts).Decode(&dbMsg)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
 		}
-
-		msg := message.Message{
-			Id:          dbMsg.ID.Hex(),
-			PhoneNumber: dbMsg.PhoneNumber,
-			Content:     dbMsg.Content,
-			Status:      dbMsg.Status,
-			CreatedAt:   dbMsg.CreatedAt,
+		if err != nil {
+			return result, fmt.Errorf("failed to recover stuck message: %w", err)
 		}
 
-		result = append(result, msg)
+		result = append(result, dbMsg.toDomain())
 	}
 
-	if err = cur.Err(); err != nil {
-		return nil, err
+	return result, nil
+}
+
+// stuckFilter matches `Process` messages not touched since stuckBefore whose lease has expired. Both the cron
+// and the retry consumer lease every message they move to `Process`, a message without a lease is a legacy
+// document claimed before leases existed.
+func stuckFilter(ctx context.Context, stuckBefore, timeNow time.Time) bson.M {
+	return scopeToTenant(ctx, bson.M{
+		"status":    message.Process,
+		"updatedAt": bson.M{"$lt": stuckBefore},
+		"$or": bson.A{
+			bson.M{"leaseExpiresAt": bson.M{"$exists": false}},
+			bson.M{"leaseExpiresAt": bson.M{"$lt": timeNow}},
+		},
+	})
+}
+
+// ExpireMessages marks every message whose expiresAt has passed before it could be delivered as `Expired`.
+func (r repo) ExpireMessages(ctx context.Context, timeNow time.Time) (int64, error) {
+	filter := scopeToTenant(ctx, bson.M{
+		"status":    bson.M{"$in": bson.A{message.New, message.Scheduled, message.Fail}},
+		"expiresAt": bson.M{"$lte": timeNow},
+	})
+
+	res, err := r.collection.UpdateMany(ctx, filter, statusTransitionUpdate(message.Expired, timeNow))
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire messages: %w", err)
 	}
 
-	return result, nil
+	return res.ModifiedCount, nil
+}
+
+// TransitionMessageStatus changes the status only if the message is currently in one of the from statuses.
+// It returns message.ErrStatusConflict when the message exists but is in another status.
+func (r repo) TransitionMessageStatus(ctx context.Context, messageID string, from []message.Status, to message.Status) (*message.Message, error) {
+	objID, err := bson.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", message.ErrInvalidMessageID, err)
+	}
+
+	filter := scopeToTenant(ctx, bson.M{"_id": objID, "status": bson.M{"$in": statusList(from)}})
+	findOpts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var dbMsg Message
+	err = r.collection.FindOneAndUpdate(ctx, filter, statusTransitionUpdate(to, time.Now()), findOpts).Decode(&dbMsg)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.notFoundOrConflict(ctx, objID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to change message status: %w", err)
+	}
+
+	msg := dbMsg.toDomain()
+	return &msg, nil
+}
+
+// RescheduleMessage moves sendAt/expiresAt of a message that has not been dispatched yet. The status becomes
+// `Scheduled` for a future sendAt and `New` otherwise.
+func (r repo) RescheduleMessage(ctx context.Context, messageID string, sendAt, expiresAt *time.Time) (*message.Message, error) {
+	objID, err := bson.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", message.ErrInvalidMessageID, err)
+	}
+
+	timeNow := time.Now()
+	newStatus := message.New
+	if sendAt != nil && sendAt.After(timeNow) {
+		newStatus = message.Scheduled
+	}
+
+	filter := scopeToTenant(ctx, bson.M{"_id": objID, "status": bson.M{"$in": bson.A{message.New, message.Scheduled}}})
+	update := mongo.Pipeline{
+		statusTransitionSet(newStatus, timeNow, bson.M{
+			"sendAt":    sendAt,
+			"expiresAt": expiresAt,
+		}),
+	}
+	findOpts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var dbMsg Message
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, findOpts).Decode(&dbMsg)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.notFoundOrConflict(ctx, objID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reschedule message: %w", err)
+	}
+
+	msg := dbMsg.toDomain()
+	return &msg, nil
 }
-func (r repo) UpdateMessageStatus(ctx context.Context, messageID string, newStatus message.Status) error {
+
+func (r repo) notFoundOrConflict(ctx context.Context, objID bson.ObjectID) error {
+	count, err := r.collection.CountDocuments(ctx, scopeToTenant(ctx, bson.M{"_id": objID}))
+	if err != nil {
+		return fmt.Errorf("failed to check message: %w", err)
+	}
+	if count == 0 {
+		return message.ErrMessageNotFound
+	}
+	return message.ErrStatusConflict
+}
+
+func (r repo) RenewLease(ctx context.Context, messageID, owner string, leaseDuration time.Duration) error {
 	objID, err := bson.ObjectIDFromHex(messageID)
 	if err != nil {
-		return fmt.Errorf("invalid message ID: %w", err)
+		return fmt.Errorf("%w: %v", message.ErrInvalidMessageID, err)
 	}
 
-	filter := bson.M{"_id": objID}
+	timeNow := time.Now()
+	filter := scopeToTenant(ctx, bson.M{"_id": objID, "status": message.Process, "leaseOwner": owner})
+	update := bson.M{"$set": bson.M{"leaseExpiresAt": timeNow.Add(leaseDuration), "updatedAt": timeNow}}
+
+	res, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to renew message lease: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return r.notFoundOrConflict(ctx, objID)
+	}
+	return nil
+}
+
+// TransitionClaimedMessage only matches the message while this owner's claim is still in place, so an
+// instance whose lease expired and was taken over cannot overwrite the status set by the new owner.
+func (r repo) TransitionClaimedMessage(ctx context.Context, messageID, owner string, to message.Status) error {
+	objID, err := bson.ObjectIDFromHex(messageID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", message.ErrInvalidMessageID, err)
+	}
+
+	filter := scopeToTenant(ctx, bson.M{"_id": objID, "status": message.Process, "leaseOwner": owner})
+	res, err := r.collection.UpdateOne(ctx, filter, statusTransitionUpdate(to, time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to change message status: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return r.notFoundOrConflict(ctx, objID)
+	}
+	return nil
+}
+
+func (r repo) RecordDeliveryAttempt(ctx context.Context, messageID string, attempt message.DeliveryAttempt) error {
+	objID, err := bson.ObjectIDFromHex(messageID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", message.ErrInvalidMessageID, err)
+	}
+
+	dbAttempt := Attempt{
+		Attempt:    attempt.Attempt,
+		Provider:   attempt.Provider,
+		ResponseId: attempt.ResponseId,
+		HTTPStatus: attempt.HTTPStatus,
+		Error:      attempt.Error,
+		At:         attempt.AttemptedAt,
+	}
+
+	filter := scopeToTenant(ctx, bson.M{"_id": objID})
 	update := bson.M{
-		"$set": bson.M{
-			"status":    newStatus,
-			"updatedAt": time.Now(),
-		},
+		"$push": bson.M{"attempts": dbAttempt},
+		"$max":  bson.M{"retryCount": attempt.Attempt},
 	}
 
 	_, err = r.collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return fmt.Errorf("failed to update message status: %w", err)
+		return fmt.Errorf("failed to record delivery attempt: %w", err)
 	}
 
 	return nil
 }
 
-func (r repo) GetSentStatusMessages(ctx context.Context) ([]message.Message, error) {
-	filter := bson.M{"status": message.Sent}
+func (r repo) GetMessageByID(ctx context.Context, messageID string) (*message.Message, error) {
+	objID, err := bson.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", message.ErrInvalidMessageID, err)
+	}
 
-	cur, err := r.collection.Find(ctx, filter)
+	var dbMsg Message
+	err = r.collection.FindOne(ctx, scopeToTenant(ctx, bson.M{"_id": objID})).Decode(&dbMsg)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, message.ErrMessageNotFound
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	msg := dbMsg.toDomainWithHistory()
+	return &msg, nil
+}
+
+func (r repo) GetMessageByIdempotencyKey(ctx context.Context, idempotencyKey string) (*message.Message, error) {
+	var dbMsg Message
+	err := r.collection.FindOne(ctx, scopeToTenant(ctx, bson.M{"idempotencyKey": idempotencyKey})).Decode(&dbMsg)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, message.ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message by idempotency key: %w", err)
+	}
+
+	msg := dbMsg.toDomain()
+	return &msg, nil
+}
+
+// statusTransitionUpdate builds an update pipeline which changes the status, records the transition
+// and drops the lease.
+func statusTransitionUpdate(newStatus message.Status, timeNow time.Time) mongo.Pipeline {
+	return mongo.Pipeline{
+		statusTransitionSet(newStatus, timeNow, nil),
+		{{Key: "$unset", Value: bson.A{"leaseOwner", "leaseExpiresAt"}}},
+	}
+}
+
+// statusTransitionSet is a `$set` pipeline stage which appends the transition from the current status to
+// newStatus to the history in the same write that changes the status. Values in extraSet are pipeline
+// expressions, so user supplied strings must be wrapped in `$literal`.
+func statusTransitionSet(newStatus message.Status, timeNow time.Time, extraSet bson.M) bson.D {
+	set := bson.M{
+		"status":    newStatus,
+		"updatedAt": timeNow,
+		"history": bson.M{"$concatArrays": bson.A{
+			bson.M{"$ifNull": bson.A{"$history", bson.A{}}},
+			bson.A{bson.M{"from": "$status", "to": newStatus, "at": timeNow}},
+		}},
+	}
+	for key, value := range extraSet {
+		set[key] = value
+	}
+
+	return bson.D{{Key: "$set", Value: set}}
+}
+
+// dueMessagesFilter matches messages which are waiting to be sent and whose sendAt (if any) has come
+// and whose expiresAt (if any) has not passed yet.
+func dueMessagesFilter(timeNow time.Time) bson.M {
+	return bson.M{
+		"status":    bson.M{"$in": bson.A{message.New, message.Scheduled}},
+		"sendAt":    bson.M{"$not": bson.M{"$gt": timeNow}},
+		"expiresAt": bson.M{"$not": bson.M{"$lte": timeNow}},
+	}
+}
+
+func statusList(statuses []message.Status) bson.A {
+	list := make(bson.A, 0, len(statuses))
+	for _, status := range statuses {
+		list = append(list, status)
+	}
+	return list
+}
+
+// ListMessages pages through messages with a keyset cursor on `_id` instead of skip/limit,
+// so every page is an index range scan regardless of how deep the client has paged.
+func (r repo) ListMessages(ctx context.Context, listFilter message.ListMessagesFilter) ([]message.Message, error) {
+	filter := scopeToTenant(ctx, bson.M{})
+
+	if listFilter.Status != 0 {
+		filter["status"] = listFilter.Status
+	}
+	if listFilter.PhoneNumber != "" {
+		filter["phoneNumber"] = listFilter.PhoneNumber
+	}
+	if createdAt := timeRange(listFilter.CreatedFrom, listFilter.CreatedTo); createdAt != nil {
+		filter["createdAt"] = createdAt
+	}
+	if updatedAt := timeRange(listFilter.UpdatedFrom, listFilter.UpdatedTo); updatedAt != nil {
+		filter["updatedAt"] = updatedAt
+	}
+	if listFilter.Search != "" {
+		filter["$text"] = bson.M{"$search": listFilter.Search}
+	}
+
+	sortOrder := 1
+	cursorOperator := "$gt"
+	if listFilter.Descending {
+		sortOrder = -1
+		cursorOperator = "$lt"
+	}
+
+	if listFilter.AfterID != "" {
+		afterID, err := bson.ObjectIDFromHex(listFilter.AfterID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", message.ErrInvalidCursor, err)
+		}
+		filter["_id"] = bson.M{cursorOperator: afterID}
+	}
+
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: sortOrder}}).
+		SetLimit(int64(listFilter.Limit)).
+		SetProjection(bson.M{"history": 0, "attempts": 0})
+
+	cur, err := r.collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
 	}
 	defer cur.Close(ctx)
 
@@ -143,15 +511,7 @@ func (r repo) GetSentStatusMessages(ctx context.Context) ([]message.Message, err
 			return nil, decodeErr
 		}
 
-		msg := message.Message{
-			Id:          dbMsg.ID.Hex(),
-			PhoneNumber: dbMsg.PhoneNumber,
-			Content:     dbMsg.Content,
-			Status:      dbMsg.Status,
-			CreatedAt:   dbMsg.CreatedAt,
-		}
-
-		result = append(result, msg)
+		result = append(result, dbMsg.toDomain())
 	}
 
 	if err = cur.Err(); err != nil {
@@ -160,3 +520,49 @@ func (r repo) GetSentStatusMessages(ctx context.Context) ([]message.Message, err
 
 	return result, nil
 }
+
+func timeRange(from, to time.Time) bson.M {
+	if from.IsZero() && to.IsZero() {
+		return nil
+	}
+
+	rangeFilter := bson.M{}
+	if !from.IsZero() {
+		rangeFilter["$gte"] = from
+	}
+	if !to.IsZero() {
+		rangeFilter["$lte"] = to
+	}
+	return rangeFilter
+}
+
+// CountMessagesByStatus groups the messages by status, served by the status_id index.
+func (r repo) CountMessagesByStatus(ctx context.Context) (map[message.Status]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: scopeToTenant(ctx, bson.M{})}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$status"},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count messages by status: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Status message.Status `bson:"_id"`
+		Count  int64          `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to decode message counts: %w", err)
+	}
+
+	counts := make(map[message.Status]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
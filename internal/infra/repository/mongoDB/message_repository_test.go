@@ -0,0 +1,624 @@
+package mongoDB
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jiin-yang/messageBird/config"
+	"github.com/jiin-yang/messageBird/internal/message"
+	"github.com/jiin-yang/messageBird/internal/tenant"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestClient connects to the MongoDB in MONGODB_TEST_URI and returns a client on a database of its own,
+// dropped when the test ends. The tests using transactions need the server to run as a replica set, e.g.
+// mongodb://localhost:27017/?replicaSet=rs0&directConnection=true
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+
+	client, err := NewClient(&config.MongoDBConfig{
+		Host: uri,
+		Name: fmt.Sprintf("messagebird_test_%d", time.Now().UnixNano()),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to MongoDB: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("failed to ping MongoDB: %v", err)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := client.Database.Drop(ctx); err != nil {
+			t.Errorf("failed to drop the test database: %v", err)
+		}
+		_ = client.Close(ctx)
+	})
+	return client
+}
+
+func createNewMessages(t *testing.T, r message.Repository, count int) []string {
+	t.Helper()
+
+	ids := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		created, err := r.CreateMessage(context.Background(), message.CreateMessage{
+			PhoneNumber: fmt.Sprintf("+9055500%05d", i),
+			Content:     "hello",
+			Status:      message.New,
+		})
+		if err != nil {
+			t.Fatalf("failed to create message: %v", err)
+		}
+		ids = append(ids, created.Id)
+	}
+	return ids
+}
+
+func TestClaimMessagesConcurrently(t *testing.T) {
+	client := newTestClient(t)
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+
+	const (
+		messageCount = 60
+		instances    = 6
+		batchSize    = 7
+	)
+	ids := createNewMessages(t, r, messageCount)
+
+	var (
+		mu      sync.Mutex
+		claimed = make(map[string]string)
+		dupes   []string
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < instances; i++ {
+		owner := fmt.Sprintf("instance-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				messages, err := r.ClaimMessages(context.Background(), owner, batchSize, time.Minute)
+				if err != nil {
+					t.Errorf("%s failed to claim messages: %v", owner, err)
+					return
+				}
+				if len(messages) == 0 {
+					return
+				}
+
+				mu.Lock()
+				for _, msg := range messages {
+					if msg.Status != message.Process {
+						t.Errorf("claimed message %s is in status %v", msg.Id, msg.Status)
+					}
+					if previous, ok := claimed[msg.Id]; ok {
+						dupes = append(dupes, fmt.Sprintf("%s by %s and %s", msg.Id, previous, owner))
+					}
+					claimed[msg.Id] = owner
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(dupes) > 0 {
+		t.Fatalf("messages claimed more than once: %v", dupes)
+	}
+	if len(claimed) != messageCount {
+		t.Fatalf("claimed %d messages, want %d", len(claimed), messageCount)
+	}
+	for _, id := range ids {
+		if _, ok := claimed[id]; !ok {
+			t.Errorf("message %s was never claimed", id)
+		}
+	}
+}
+
+func TestClaimedMessageOnlyFinishedByItsOwner(t *testing.T) {
+	client := newTestClient(t)
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+	ctx := context.Background()
+
+	createNewMessages(t, r, 1)
+	messages, err := r.ClaimMessages(ctx, "owner", 1, time.Minute)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("ClaimMessages() = %d messages, %v; want 1 message", len(messages), err)
+	}
+	id := messages[0].Id
+
+	if err := r.RenewLease(ctx, id, "other", time.Minute); !errors.Is(err, message.ErrStatusConflict) {
+		t.Errorf("RenewLease() by another owner = %v, want ErrStatusConflict", err)
+	}
+	if err := r.TransitionClaimedMessage(ctx, id, "other", message.Sent); !errors.Is(err, message.ErrStatusConflict) {
+		t.Errorf("TransitionClaimedMessage() by another owner = %v, want ErrStatusConflict", err)
+	}
+
+	if err := r.RenewLease(ctx, id, "owner", time.Minute); err != nil {
+		t.Errorf("RenewLease() by the owner = %v", err)
+	}
+	if err := r.TransitionClaimedMessage(ctx, id, "owner", message.Sent); err != nil {
+		t.Fatalf("TransitionClaimedMessage() by the owner = %v", err)
+	}
+	if err := r.TransitionClaimedMessage(ctx, id, "owner", message.Dead); !errors.Is(err, message.ErrStatusConflict) {
+		t.Errorf("TransitionClaimedMessage() after it was finished = %v, want ErrStatusConflict", err)
+	}
+
+	msg, err := r.GetMessageByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetMessageByID() = %v", err)
+	}
+	if msg.Status != message.Sent {
+		t.Errorf("status = %v, want Sent", msg.Status)
+	}
+}
+
+// claimWithLease creates a message and claims it with the given lease, a negative one is already expired.
+func claimWithLease(t *testing.T, r message.Repository, owner string, leaseDuration time.Duration) string {
+	t.Helper()
+
+	createNewMessages(t, r, 1)
+	messages, err := r.ClaimMessages(context.Background(), owner, 1, leaseDuration)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("ClaimMessages() = %d messages, %v; want 1 message", len(messages), err)
+	}
+	return messages[0].Id
+}
+
+func TestRecoverStuckMessagesOnlyTakesExpiredLeases(t *testing.T) {
+	client := newTestClient(t)
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+	ctx := context.Background()
+
+	expired := claimWithLease(t, r, "crashed", -time.Minute)
+	live := claimWithLease(t, r, "sending", time.Minute)
+
+	// Both claims are older than stuckBefore, only the lease tells them apart
+	recovered, err := r.RecoverStuckMessages(ctx, time.Now().Add(time.Second), message.New)
+	if err != nil {
+		t.Fatalf("RecoverStuckMessages() = %v", err)
+	}
+	if len(recovered) != 1 || recovered[0].Id != expired {
+		t.Fatalf("recovered %v, want only %s", recovered, expired)
+	}
+
+	msg, err := r.GetMessageByID(ctx, expired)
+	if err != nil {
+		t.Fatalf("GetMessageByID() = %v", err)
+	}
+	if msg.Status != message.New {
+		t.Errorf("status of the expired claim = %v, want New", msg.Status)
+	}
+
+	msg, err = r.GetMessageByID(ctx, live)
+	if err != nil {
+		t.Fatalf("GetMessageByID() = %v", err)
+	}
+	if msg.Status != message.Process {
+		t.Errorf("status of the live claim = %v, want Process", msg.Status)
+	}
+	// The crashed instance cannot finish the message the reaper took back
+	if err := r.TransitionClaimedMessage(ctx, expired, "crashed", message.Sent); !errors.Is(err, message.ErrStatusConflict) {
+		t.Errorf("TransitionClaimedMessage() after recovery = %v, want ErrStatusConflict", err)
+	}
+}
+
+func TestRecoverStuckMessagesWithOutboxWritesTheRetry(t *testing.T) {
+	client := newTestClient(t)
+	if err := EnsureOutboxIndexes(context.Background(), client); err != nil {
+		t.Fatalf("EnsureOutboxIndexes() = %v", err)
+	}
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+	ctx := context.Background()
+
+	expired := claimWithLease(t, r, "crashed", -time.Minute)
+	claimWithLease(t, r, "sending", time.Minute)
+
+	newOutbox := func(msg message.Message) (message.OutboxMessage, error) {
+		return message.OutboxMessage{MessageID: msg.Id, Payload: []byte(`{"messageId":"` + msg.Id + `"}`)}, nil
+	}
+	recovered, outboxMessages, err := r.RecoverStuckMessagesWithOutbox(ctx, time.Now().Add(time.Second), newOutbox)
+	if err != nil {
+		t.Fatalf("RecoverStuckMessagesWithOutbox() = %v", err)
+	}
+	if len(recovered) != 1 || recovered[0].Id != expired {
+		t.Fatalf("recovered %v, want only %s", recovered, expired)
+	}
+	if recovered[0].Status != message.Fail {
+		t.Errorf("status = %v, want Fail", recovered[0].Status)
+	}
+	if len(outboxMessages) != 1 || outboxMessages[0].MessageID != expired {
+		t.Fatalf("outbox messages = %v, want one for %s", outboxMessages, expired)
+	}
+
+	count, err := client.Database.Collection(outboxCollection).CountDocuments(ctx, bson.M{"messageId": expired})
+	if err != nil {
+		t.Fatalf("CountDocuments() = %v", err)
+	}
+	if count != 1 {
+		t.Errorf("outbox holds %d entries for the recovered message, want 1", count)
+	}
+}
+
+// listAll pages through the messages matching listFilter, pageSize at a time, following the cursor.
+func listAll(t *testing.T, r message.Repository, listFilter message.ListMessagesFilter, pageSize int) []string {
+	t.Helper()
+
+	var ids []string
+	listFilter.Limit = pageSize
+	for {
+		messages, err := r.ListMessages(context.Background(), listFilter)
+		if err != nil {
+			t.Fatalf("ListMessages() = %v", err)
+		}
+		for _, msg := range messages {
+			ids = append(ids, msg.Id)
+		}
+		if len(messages) < pageSize {
+			return ids
+		}
+		listFilter.AfterID = messages[len(messages)-1].Id
+	}
+}
+
+func TestListMessagesPagesAcrossEqualCreatedAt(t *testing.T) {
+	client := newTestClient(t)
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+
+	batch := make([]message.CreateMessage, 7)
+	for i := range batch {
+		batch[i] = message.CreateMessage{PhoneNumber: fmt.Sprintf("+9055500%05d", i), Content: "hello", Status: message.New}
+	}
+	// A batch is stored with one createdAt, only the _id cursor tells its messages apart
+	results, err := r.CreateMessages(context.Background(), batch)
+	if err != nil {
+		t.Fatalf("CreateMessages() = %v", err)
+	}
+	var created []string
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("CreateMessages() item = %v", result.Err)
+		}
+		created = append(created, result.Message.Id)
+	}
+
+	ascending := listAll(t, r, message.ListMessagesFilter{}, 2)
+	if fmt.Sprint(ascending) != fmt.Sprint(created) {
+		t.Errorf("ascending pages = %v, want %v", ascending, created)
+	}
+
+	descending := listAll(t, r, message.ListMessagesFilter{Descending: true}, 3)
+	for i, id := range descending {
+		if want := created[len(created)-1-i]; id != want {
+			t.Fatalf("descending pages = %v, want the reverse of %v", descending, created)
+		}
+	}
+	if len(descending) != len(created) {
+		t.Errorf("descending pages returned %d messages, want %d", len(descending), len(created))
+	}
+}
+
+func TestListMessagesRejectsMalformedCursor(t *testing.T) {
+	client := newTestClient(t)
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+
+	_, err := r.ListMessages(context.Background(), message.ListMessagesFilter{AfterID: "not-an-id", Limit: 10})
+	if !errors.Is(err, message.ErrInvalidCursor) {
+		t.Errorf("ListMessages() = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestListMessagesFilters(t *testing.T) {
+	client := newTestClient(t)
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+	ctx := context.Background()
+
+	create := func(phoneNumber string) *message.CreatedMessageDbResponse {
+		t.Helper()
+		created, err := r.CreateMessage(ctx, message.CreateMessage{PhoneNumber: phoneNumber, Content: "hello", Status: message.New})
+		if err != nil {
+			t.Fatalf("CreateMessage() = %v", err)
+		}
+		// createdAt is stored with millisecond precision
+		time.Sleep(5 * time.Millisecond)
+		return created
+	}
+	first := create("+905550000001")
+	second := create("+905550000002")
+	third := create("+905550000001")
+
+	claimed, err := r.ClaimMessages(ctx, "owner", 1, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].Id != first.Id {
+		t.Fatalf("ClaimMessages() = %v, %v; want %s", claimed, err, first.Id)
+	}
+
+	tests := []struct {
+		name   string
+		filter message.ListMessagesFilter
+		want   []string
+	}{
+		{name: "status", filter: message.ListMessagesFilter{Status: message.Process}, want: []string{first.Id}},
+		{name: "phone number", filter: message.ListMessagesFilter{PhoneNumber: "+905550000001"}, want: []string{first.Id, third.Id}},
+		{name: "created from", filter: message.ListMessagesFilter{CreatedFrom: *second.CreatedAt}, want: []string{second.Id, third.Id}},
+		{name: "created to", filter: message.ListMessagesFilter{CreatedTo: *second.CreatedAt}, want: []string{first.Id, second.Id}},
+		{
+			name:   "combined",
+			filter: message.ListMessagesFilter{Status: message.New, PhoneNumber: "+905550000001", CreatedFrom: *first.CreatedAt},
+			want:   []string{third.Id},
+		},
+		{name: "no match", filter: message.ListMessagesFilter{Status: message.Sent}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listAll(t, r, tt.filter, 10)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("ListMessages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListMessagesSearchesContent(t *testing.T) {
+	client := newTestClient(t)
+	if err := EnsureMessageIndexes(context.Background(), client); err != nil {
+		t.Fatalf("EnsureMessageIndexes() = %v", err)
+	}
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+	ctx := context.Background()
+
+	var ids []string
+	for _, content := range []string{"Your verification code is 1234", "Your order has shipped", "Use code SPRING for a discount"} {
+		created, err := r.CreateMessage(ctx, message.CreateMessage{PhoneNumber: "+905550000001", Content: content, Status: message.New})
+		if err != nil {
+			t.Fatalf("CreateMessage() = %v", err)
+		}
+		ids = append(ids, created.Id)
+	}
+
+	got := listAll(t, r, message.ListMessagesFilter{Search: "code"}, 10)
+	if want := []string{ids[0], ids[2]}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("search for code = %v, want %v", got, want)
+	}
+
+	got = listAll(t, r, message.ListMessagesFilter{Search: "shipped", PhoneNumber: "+905550000002"}, 10)
+	if len(got) != 0 {
+		t.Errorf("search with another phone number = %v, want none", got)
+	}
+}
+
+func createMessage(t *testing.T, r message.Repository, status message.Status, sendAt, expiresAt *time.Time) string {
+	t.Helper()
+
+	created, err := r.CreateMessage(context.Background(), message.CreateMessage{
+		PhoneNumber: "+905550000001",
+		Content:     "hello",
+		Status:      status,
+		SendAt:      sendAt,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("CreateMessage() = %v", err)
+	}
+	return created.Id
+}
+
+func statusOf(t *testing.T, r message.Repository, messageID string) message.Status {
+	t.Helper()
+
+	msg, err := r.GetMessageByID(context.Background(), messageID)
+	if err != nil {
+		t.Fatalf("GetMessageByID() = %v", err)
+	}
+	return msg.Status
+}
+
+func timeRef(t time.Time) *time.Time {
+	return &t
+}
+
+func TestClaimMessagesOnlyTakesDueMessages(t *testing.T) {
+	client := newTestClient(t)
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+	timeNow := time.Now()
+
+	due := createMessage(t, r, message.New, nil, nil)
+	dueScheduled := createMessage(t, r, message.Scheduled, timeRef(timeNow.Add(-time.Second)), timeRef(timeNow.Add(time.Hour)))
+	notDue := createMessage(t, r, message.Scheduled, timeRef(timeNow.Add(time.Hour)), nil)
+	expired := createMessage(t, r, message.New, nil, timeRef(timeNow.Add(-time.Second)))
+	cancelled := createMessage(t, r, message.Cancelled, nil, nil)
+
+	claimed, err := r.ClaimMessages(context.Background(), "owner", 10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimMessages() = %v", err)
+	}
+	var ids []string
+	for _, msg := range claimed {
+		ids = append(ids, msg.Id)
+	}
+	if want := []string{due, dueScheduled}; fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Errorf("claimed %v, want %v", ids, want)
+	}
+
+	for id, want := range map[string]message.Status{notDue: message.Scheduled, expired: message.New, cancelled: message.Cancelled} {
+		if got := statusOf(t, r, id); got != want {
+			t.Errorf("status of %s = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestExpireMessages(t *testing.T) {
+	client := newTestClient(t)
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+	timeNow := time.Now()
+	expiredAt := timeRef(timeNow.Add(-time.Second))
+
+	want := map[string]message.Status{
+		createMessage(t, r, message.New, nil, expiredAt):                                    message.Expired,
+		createMessage(t, r, message.Scheduled, timeRef(timeNow.Add(-time.Hour)), expiredAt): message.Expired,
+		createMessage(t, r, message.Fail, nil, expiredAt):                                   message.Expired,
+		createMessage(t, r, message.New, nil, timeRef(timeNow.Add(time.Hour))):              message.New,
+		createMessage(t, r, message.Sent, nil, expiredAt):                                   message.Sent,
+		createMessage(t, r, message.Process, nil, expiredAt):                                message.Process,
+		createMessage(t, r, message.Scheduled, timeRef(timeNow.Add(time.Hour)), nil):        message.Scheduled,
+	}
+
+	expired, err := r.ExpireMessages(context.Background(), timeNow)
+	if err != nil {
+		t.Fatalf("ExpireMessages() = %v", err)
+	}
+	if expired != 3 {
+		t.Errorf("ExpireMessages() = %d, want 3", expired)
+	}
+	for id, wantStatus := range want {
+		if got := statusOf(t, r, id); got != wantStatus {
+			t.Errorf("status of %s = %v, want %v", id, got, wantStatus)
+		}
+	}
+}
+
+func TestRescheduleMessage(t *testing.T) {
+	client := newTestClient(t)
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+	ctx := context.Background()
+	timeNow := time.Now()
+
+	id := createMessage(t, r, message.New, nil, nil)
+	msg, err := r.RescheduleMessage(ctx, id, timeRef(timeNow.Add(time.Hour)), timeRef(timeNow.Add(2*time.Hour)))
+	if err != nil {
+		t.Fatalf("RescheduleMessage() to the future = %v", err)
+	}
+	if msg.Status != message.Scheduled || msg.SendAt == nil || msg.ExpiresAt == nil {
+		t.Errorf("rescheduled to the future = %v, sendAt %v, expiresAt %v; want Scheduled with both set", msg.Status, msg.SendAt, msg.ExpiresAt)
+	}
+	if last := msg.History[len(msg.History)-1]; last.From != message.New || last.To != message.Scheduled {
+		t.Errorf("last transition = %v -> %v, want New -> Scheduled", last.From, last.To)
+	}
+
+	msg, err = r.RescheduleMessage(ctx, id, timeRef(timeNow.Add(-time.Minute)), nil)
+	if err != nil {
+		t.Fatalf("RescheduleMessage() to the past = %v", err)
+	}
+	if msg.Status != message.New || msg.ExpiresAt != nil {
+		t.Errorf("rescheduled to the past = %v, expiresAt %v; want New without expiresAt", msg.Status, msg.ExpiresAt)
+	}
+
+	for _, status := range []message.Status{message.Process, message.Sent, message.Fail, message.Dead, message.Expired, message.Cancelled} {
+		id := createMessage(t, r, status, nil, nil)
+		if _, err := r.RescheduleMessage(ctx, id, timeRef(timeNow.Add(time.Hour)), nil); !errors.Is(err, message.ErrStatusConflict) {
+			t.Errorf("RescheduleMessage() of a %v message = %v, want ErrStatusConflict", status, err)
+		}
+	}
+
+	if _, err := r.RescheduleMessage(ctx, bson.NewObjectID().Hex(), timeRef(timeNow), nil); !errors.Is(err, message.ErrMessageNotFound) {
+		t.Errorf("RescheduleMessage() of an unknown message = %v, want ErrMessageNotFound", err)
+	}
+	if _, err := r.RescheduleMessage(ctx, "not-an-id", timeRef(timeNow), nil); !errors.Is(err, message.ErrInvalidMessageID) {
+		t.Errorf("RescheduleMessage() with a malformed id = %v, want ErrInvalidMessageID", err)
+	}
+}
+
+func TestCancelTransitions(t *testing.T) {
+	client := newTestClient(t)
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+	ctx := context.Background()
+	cancellable := []message.Status{message.New, message.Scheduled, message.Fail}
+
+	for status := message.New; status <= message.Cancelled; status++ {
+		id := createMessage(t, r, status, nil, nil)
+
+		msg, err := r.TransitionMessageStatus(ctx, id, cancellable, message.Cancelled)
+		allowed := status == message.New || status == message.Scheduled || status == message.Fail
+		switch {
+		case allowed && err != nil:
+			t.Errorf("cancelling a %v message = %v", status, err)
+		case allowed && msg.Status != message.Cancelled:
+			t.Errorf("cancelled %v message is in status %v", status, msg.Status)
+		case !allowed && !errors.Is(err, message.ErrStatusConflict):
+			t.Errorf("cancelling a %v message = %v, want ErrStatusConflict", status, err)
+		case !allowed && statusOf(t, r, id) != status:
+			t.Errorf("refused cancel changed the status of a %v message", status)
+		}
+	}
+
+	if _, err := r.TransitionMessageStatus(ctx, bson.NewObjectID().Hex(), cancellable, message.Cancelled); !errors.Is(err, message.ErrMessageNotFound) {
+		t.Errorf("cancelling an unknown message = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestClaimRetryDeliverySkipsCancelledMessages(t *testing.T) {
+	client := newTestClient(t)
+	if err := EnsureOutboxIndexes(context.Background(), client); err != nil {
+		t.Fatalf("EnsureOutboxIndexes() = %v", err)
+	}
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+	ctx := context.Background()
+
+	id := createMessage(t, r, message.Fail, nil, nil)
+	if _, err := r.TransitionMessageStatus(ctx, id, []message.Status{message.Fail}, message.Cancelled); err != nil {
+		t.Fatalf("TransitionMessageStatus() = %v", err)
+	}
+
+	if _, err := r.ClaimRetryDelivery(ctx, id, "owner", 1, time.Minute); !errors.Is(err, message.ErrStatusConflict) {
+		t.Errorf("ClaimRetryDelivery() of a cancelled message = %v, want ErrStatusConflict", err)
+	}
+	if got := statusOf(t, r, id); got != message.Cancelled {
+		t.Errorf("status = %v, want Cancelled", got)
+	}
+}
+
+func TestIdempotencyKeysAreUniquePerTenant(t *testing.T) {
+	client := newTestClient(t)
+	if err := EnsureMessageIndexes(context.Background(), client); err != nil {
+		t.Fatalf("EnsureMessageIndexes() = %v", err)
+	}
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+
+	create := func(tenantID, idempotencyKey string) error {
+		_, err := r.CreateMessage(context.Background(), message.CreateMessage{
+			TenantID:       tenantID,
+			PhoneNumber:    "+905550000001",
+			Content:        "hello",
+			Status:         message.New,
+			IdempotencyKey: idempotencyKey,
+		})
+		return err
+	}
+
+	if err := create("acme", "order-42"); err != nil {
+		t.Fatalf("CreateMessage() = %v", err)
+	}
+	if err := create("acme", "order-42"); !errors.Is(err, message.ErrDuplicateIdempotencyKey) {
+		t.Errorf("CreateMessage() reusing the key of the tenant = %v, want ErrDuplicateIdempotencyKey", err)
+	}
+	if err := create("globex", "order-42"); err != nil {
+		t.Errorf("CreateMessage() with the key of another tenant = %v", err)
+	}
+	// The index is partial, messages created without a key never collide
+	for i := 0; i < 2; i++ {
+		if err := create("acme", ""); err != nil {
+			t.Errorf("CreateMessage() without a key = %v", err)
+		}
+	}
+
+	msg, err := r.GetMessageByIdempotencyKey(tenant.NewContext(context.Background(), "globex"), "order-42")
+	if err != nil {
+		t.Fatalf("GetMessageByIdempotencyKey() = %v", err)
+	}
+	if msg.TenantID != "globex" {
+		t.Errorf("GetMessageByIdempotencyKey() for globex returned the message of %s", msg.TenantID)
+	}
+}
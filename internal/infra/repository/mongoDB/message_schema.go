@@ -7,10 +7,87 @@ import (
 )
 
 type Message struct {
-	ID          bson.ObjectID  `bson:"_id"`
-	PhoneNumber string         `bson:"phoneNumber"`
-	Content     string         `bson:"content"`
-	Status      message.Status `bson:"status"`
-	CreatedAt   *time.Time     `bson:"createdAt"`
-	UpdatedAt   *time.Time     `bson:"updatedAt,omitempty"`
+	ID             bson.ObjectID  `bson:"_id"`
+	TenantID       string         `bson:"tenantId"`
+	PhoneNumber    string         `bson:"phoneNumber"`
+	Content        string         `bson:"content"`
+	Status         message.Status `bson:"status"`
+	LeaseOwner     string         `bson:"leaseOwner,omitempty"`
+	LeaseExpiresAt *time.Time     `bson:"leaseExpiresAt,omitempty"`
+	RetryCount     int            `bson:"retryCount"`
+	History        []Transition   `bson:"history,omitempty"`
+	Attempts       []Attempt      `bson:"attempts,omitempty"`
+	SendAt         *time.Time     `bson:"sendAt,omitempty"`
+	ExpiresAt      *time.Time     `bson:"expiresAt,omitempty"`
+
+	IdempotencyKey     string `bson:"idempotencyKey,omitempty"`
+	RequestFingerprint string `bson:"requestFingerprint,omitempty"`
+	TraceParent        string `bson:"traceParent,omitempty"`
+	RequestID          string `bson:"requestId,omitempty"`
+
+	CreatedAt *time.Time `bson:"createdAt"`
+	UpdatedAt *time.Time `bson:"updatedAt,omitempty"`
+}
+
+type Transition struct {
+	From message.Status `bson:"from,omitempty"`
+	To   message.Status `bson:"to"`
+	At   *time.Time     `bson:"at"`
+}
+
+type Attempt struct {
+	Attempt    int        `bson:"attempt"`
+	Provider   string     `bson:"provider,omitempty"`
+	ResponseId string     `bson:"responseId,omitempty"`
+	HTTPStatus int        `bson:"httpStatus,omitempty"`
+	Error      string     `bson:"error,omitempty"`
+	At         *time.Time `bson:"at"`
+}
+
+func (m Message) toDomain() message.Message {
+	return message.Message{
+		Id:          m.ID.Hex(),
+		TenantID:    m.TenantID,
+		PhoneNumber: m.PhoneNumber,
+		Content:     m.Content,
+		Status:      m.Status,
+		RetryCount:  m.RetryCount,
+		SendAt:      m.SendAt,
+		ExpiresAt:   m.ExpiresAt,
+		CreatedAt:   m.CreatedAt,
+		UpdatedAt:   m.UpdatedAt,
+
+		RequestFingerprint: m.RequestFingerprint,
+		TraceParent:        m.TraceParent,
+		RequestID:          m.RequestID,
+	}
+}
+
+// toDomainWithHistory also maps the transition and attempt history, which is only needed when a
+// single message is looked up.
+func (m Message) toDomainWithHistory() message.Message {
+	msg := m.toDomain()
+
+	msg.History = make([]message.StatusTransition, 0, len(m.History))
+	for _, t := range m.History {
+		msg.History = append(msg.History, message.StatusTransition{
+			From: t.From,
+			To:   t.To,
+			At:   t.At,
+		})
+	}
+
+	msg.Attempts = make([]message.DeliveryAttempt, 0, len(m.Attempts))
+	for _, a := range m.Attempts {
+		msg.Attempts = append(msg.Attempts, message.DeliveryAttempt{
+			Attempt:     a.Attempt,
+			Provider:    a.Provider,
+			ResponseId:  a.ResponseId,
+			HTTPStatus:  a.HTTPStatus,
+			Error:       a.Error,
+			AttemptedAt: a.At,
+		})
+	}
+
+	return msg
 }
@@ -0,0 +1,284 @@
+package mongoDB
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jiin-yang/messageBird/internal/message"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+const (
+	outboxCollection              = "outbox"
+	processedDeliveriesCollection = "processed_deliveries"
+)
+
+// FailMessageWithOutbox moves a `Process` message to `Fail` and stores the queue message scheduling its retry
+// in the outbox within one transaction, so the status change and the handoff to RabbitMQ are never torn
+// apart by a crash. Returns ErrStatusConflict when the message is no longer in `Process`, or no longer leased
+// to owner when one is given.
+func (r repo) FailMessageWithOutbox(ctx context.Context, messageID, owner string, outboxMsg message.OutboxMessage) (*message.OutboxMessage, error) {
+	objID, err := bson.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", message.ErrInvalidMessageID, err)
+	}
+
+	timeNow := time.Now()
+	dbData := newOutboxDocument(outboxMsg, timeNow)
+
+	err = r.client.WithTransaction(ctx, func(ctx context.Context) error {
+		filter := scopeToTenant(ctx, bson.M{"_id": objID, "status": message.Process})
+		if owner != "" {
+			filter["leaseOwner"] = owner
+		}
+		res, err := r.collection.UpdateOne(ctx, filter, statusTransitionUpdate(message.Fail, timeNow))
+		if err != nil {
+			return fmt.Errorf("failed to update message status: %w", err)
+		}
+		if res.MatchedCount == 0 {
+			return r.notFoundOrConflict(ctx, objID)
+		}
+
+		_, err = r.outbox.InsertOne(ctx, dbData)
+		if err != nil {
+			return fmt.Errorf("failed to save outbox message: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	saved := dbData.toDomain()
+	return &saved, nil
+}
+
+// RecoverStuckMessagesWithOutbox moves stuck `Process` messages (see RecoverStuckMessages) to `Fail` for a
+// retry starting over from the first attempt. Each message is moved in its own transaction together with the
+// outbox entry newOutbox builds for it and the removal of its processed deliveries.
+func (r repo) RecoverStuckMessagesWithOutbox(
+	ctx context.Context,
+	stuckBefore time.Time,
+	newOutbox func(message.Message) (message.OutboxMessage, error),
+) ([]message.Message, []message.OutboxMessage, error) {
+	findOpts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var messages []message.Message
+	var outboxMessages []message.OutboxMessage
+
+	for i := 0; i < recoverMessageLimit; i++ {
+		var recovered *message.Message
+		var saved OutboxMessage
+
+		err := r.client.WithTransaction(ctx, func(ctx context.Context) error {
+			recovered = nil
+			timeNow := time.Now()
+
+			var dbMsg Message
+			err := r.collection.FindOneAndUpdate(ctx, stuckFilter(ctx, stuckBefore, timeNow), statusTransitionUpdate(message.Fail, timeNow), findOpts).Decode(&dbMsg)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to recover stuck message: %w", err)
+			}
+			msg := dbMsg.toDomain()
+
+			outboxMsg, err := newOutbox(msg)
+			if err != nil {
+				return err
+			}
+			saved = newOutboxDocument(outboxMsg, timeNow)
+			if _, err := r.outbox.InsertOne(ctx, saved); err != nil {
+				return fmt.Errorf("failed to save outbox message: %w", err)
+			}
+
+			if _, err := r.processedDeliveries.DeleteMany(ctx, bson.M{"messageId": msg.Id}); err != nil {
+				return fmt.Errorf("failed to clear processed deliveries: %w", err)
+			}
+
+			recovered = &msg
+			return nil
+		})
+		if err != nil {
+			return messages, outboxMessages, err
+		}
+		if recovered == nil {
+			break
+		}
+
+		messages = append(messages, *recovered)
+		outboxMessages = append(outboxMessages, saved.toDomain())
+	}
+
+	return messages, outboxMessages, nil
+}
+
+func (r repo) SaveOutboxMessage(ctx context.Context, outboxMsg message.OutboxMessage) (*message.OutboxMessage, error) {
+	dbData := newOutboxDocument(outboxMsg, time.Now())
+
+	_, err := r.outbox.InsertOne(ctx, dbData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save outbox message: %w", err)
+	}
+
+	saved := dbData.toDomain()
+	return &saved, nil
+}
+
+// ClaimOutboxMessages leases up to limit due outbox entries to owner, one findOneAndUpdate at a time, so
+// two relays never publish the same entry while the lease holds.
+func (r repo) ClaimOutboxMessages(ctx context.Context, owner string, limit int, leaseDuration time.Duration) ([]message.OutboxMessage, error) {
+	findOpts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var result []message.OutboxMessage
+
+	for i := 0; i < limit; i++ {
+		timeNow := time.Now()
+		filter := bson.M{
+			"deliveredAt":   bson.M{"$exists": false},
+			"nextAttemptAt": bson.M{"$lte": timeNow},
+			"$or": bson.A{
+				bson.M{"leaseExpiresAt": bson.M{"$exists": false}},
+				bson.M{"leaseExpiresAt": bson.M{"$lt": timeNow}},
+			},
+		}
+		update := bson.M{
+			"$set": bson.M{
+				"leaseOwner":     owner,
+				"leaseExpiresAt": timeNow.Add(leaseDuration),
+				"updatedAt":      timeNow,
+			},
+		}
+
+		var dbMsg OutboxMessage
+		err := r.outbox.FindOneAndUpdate(ctx, filter, update, findOpts).Decode(&dbMsg)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to claim outbox message: %w", err)
+		}
+
+		result = append(result, dbMsg.toDomain())
+	}
+
+	return result, nil
+}
+
+func (r repo) MarkOutboxMessageDelivered(ctx context.Context, outboxID string) error {
+	objID, err := bson.ObjectIDFromHex(outboxID)
+	if err != nil {
+		return fmt.Errorf("invalid outbox id: %w", err)
+	}
+
+	timeNow := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"deliveredAt": timeNow,
+			"updatedAt":   timeNow,
+		},
+		"$inc":   bson.M{"publishAttempts": 1},
+		"$unset": bson.M{"leaseOwner": "", "leaseExpiresAt": ""},
+	}
+
+	_, err = r.outbox.UpdateByID(ctx, objID, update)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox message delivered: %w", err)
+	}
+	return nil
+}
+
+// RescheduleOutboxMessage releases the lease of an entry whose publish failed again and schedules the next try.
+func (r repo) RescheduleOutboxMessage(ctx context.Context, outboxID string, lastError string, nextAttemptAt time.Time) error {
+	objID, err := bson.ObjectIDFromHex(outboxID)
+	if err != nil {
+		return fmt.Errorf("invalid outbox id: %w", err)
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"lastError":     lastError,
+			"nextAttemptAt": nextAttemptAt,
+			"updatedAt":     time.Now(),
+		},
+		"$inc":   bson.M{"publishAttempts": 1},
+		"$unset": bson.M{"leaseOwner": "", "leaseExpiresAt": ""},
+	}
+
+	_, err = r.outbox.UpdateByID(ctx, objID, update)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule outbox message: %w", err)
+	}
+	return nil
+}
+
+// ClaimRetryDelivery records the queue message identified by messageID and attempt as processed and moves the
+// message from `Fail` to `Process` in one transaction. The message is leased to owner like a claim of the cron,
+// so the reaper only recovers it once the retry stopped renewing the lease. A redelivered or relayed-twice
+// queue message fails with ErrDuplicateDelivery, a message that left the retry flow with ErrStatusConflict.
+func (r repo) ClaimRetryDelivery(ctx context.Context, messageID, owner string, attempt int, leaseDuration time.Duration) (*message.Message, error) {
+	objID, err := bson.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", message.ErrInvalidMessageID, err)
+	}
+
+	timeNow := time.Now()
+	processed := ProcessedDelivery{
+		ID:          bson.NewObjectID(),
+		MessageID:   messageID,
+		Attempt:     attempt,
+		ProcessedAt: &timeNow,
+	}
+
+	var dbMsg Message
+	err = r.client.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.processedDeliveries.InsertOne(ctx, processed)
+		if mongo.IsDuplicateKeyError(err) {
+			return message.ErrDuplicateDelivery
+		}
+		if err != nil {
+			return fmt.Errorf("failed to record processed delivery: %w", err)
+		}
+
+		filter := scopeToTenant(ctx, bson.M{"_id": objID, "status": message.Fail})
+		update := mongo.Pipeline{
+			statusTransitionSet(message.Process, timeNow, bson.M{
+				"leaseOwner":     bson.M{"$literal": owner},
+				"leaseExpiresAt": timeNow.Add(leaseDuration),
+			}),
+		}
+		findOpts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = r.collection.FindOneAndUpdate(ctx, filter, update, findOpts).Decode(&dbMsg)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return r.notFoundOrConflict(ctx, objID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to change message status: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msg := dbMsg.toDomain()
+	return &msg, nil
+}
+
+// ClearProcessedDeliveries forgets the processed queue messages of a message whose attempt counter starts over,
+// e.g. when it is replayed from the dead-letter queue.
+func (r repo) ClearProcessedDeliveries(ctx context.Context, messageID string) error {
+	_, err := r.processedDeliveries.DeleteMany(ctx, bson.M{"messageId": messageID})
+	if err != nil {
+		return fmt.Errorf("failed to clear processed deliveries: %w", err)
+	}
+	return nil
+}
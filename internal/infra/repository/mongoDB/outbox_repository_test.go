@@ -0,0 +1,67 @@
+package mongoDB
+
+import (
+	"context"
+	"errors"
+	"github.com/jiin-yang/messageBird/internal/message"
+	"testing"
+	"time"
+)
+
+// newFailedMessage creates a message and takes it through a failed first send, so it waits for its retry.
+func newFailedMessage(t *testing.T, r message.Repository) string {
+	t.Helper()
+	ctx := context.Background()
+
+	createNewMessages(t, r, 1)
+	messages, err := r.ClaimMessages(ctx, "cron", 1, time.Minute)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("ClaimMessages() = %d messages, %v; want 1 message", len(messages), err)
+	}
+	id := messages[0].Id
+
+	outboxMsg := message.OutboxMessage{MessageID: id, Payload: []byte(`{}`)}
+	if _, err := r.FailMessageWithOutbox(ctx, id, "cron", outboxMsg); err != nil {
+		t.Fatalf("FailMessageWithOutbox() = %v", err)
+	}
+	return id
+}
+
+func TestClaimRetryDeliveryLeasesTheMessage(t *testing.T) {
+	client := newTestClient(t)
+	if err := EnsureOutboxIndexes(context.Background(), client); err != nil {
+		t.Fatalf("EnsureOutboxIndexes() = %v", err)
+	}
+	r := NewMessageRepository(&NewMessageRepositoryOpts{Client: client})
+	ctx := context.Background()
+
+	id := newFailedMessage(t, r)
+
+	claimed, err := r.ClaimRetryDelivery(ctx, id, "consumer", 1, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimRetryDelivery() = %v", err)
+	}
+	if claimed.Status != message.Process {
+		t.Errorf("status = %v, want Process", claimed.Status)
+	}
+
+	if err := r.RenewLease(ctx, id, "other", time.Minute); !errors.Is(err, message.ErrStatusConflict) {
+		t.Errorf("RenewLease() by another owner = %v, want ErrStatusConflict", err)
+	}
+	if err := r.RenewLease(ctx, id, "consumer", time.Minute); err != nil {
+		t.Errorf("RenewLease() by the consumer = %v", err)
+	}
+
+	// A live lease keeps the reaper away even when the message looks stuck by its last update
+	recovered, err := r.RecoverStuckMessages(ctx, time.Now().Add(time.Second), message.New)
+	if err != nil {
+		t.Fatalf("RecoverStuckMessages() = %v", err)
+	}
+	if len(recovered) != 0 {
+		t.Errorf("recovered %d messages with a live retry lease, want 0", len(recovered))
+	}
+
+	if _, err := r.ClaimRetryDelivery(ctx, id, "consumer", 1, time.Minute); !errors.Is(err, message.ErrDuplicateDelivery) {
+		t.Errorf("second ClaimRetryDelivery() of the attempt = %v, want ErrDuplicateDelivery", err)
+	}
+}
@@ -0,0 +1,62 @@
+package mongoDB
+
+import (
+	"github.com/jiin-yang/messageBird/internal/message"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+type OutboxMessage struct {
+	ID              bson.ObjectID `bson:"_id"`
+	MessageID       string        `bson:"messageId"`
+	Payload         string        `bson:"payload"`
+	PublishAttempts int           `bson:"publishAttempts"`
+	LastError       string        `bson:"lastError,omitempty"`
+	NextAttemptAt   *time.Time    `bson:"nextAttemptAt"`
+	LeaseOwner      string        `bson:"leaseOwner,omitempty"`
+	LeaseExpiresAt  *time.Time    `bson:"leaseExpiresAt,omitempty"`
+	DeliveredAt     *time.Time    `bson:"deliveredAt,omitempty"`
+	CreatedAt       *time.Time    `bson:"createdAt"`
+	UpdatedAt       *time.Time    `bson:"updatedAt,omitempty"`
+}
+
+// ProcessedDelivery records that the retry consumer took over a queue message, keyed by message id and attempt.
+type ProcessedDelivery struct {
+	ID          bson.ObjectID `bson:"_id"`
+	MessageID   string        `bson:"messageId"`
+	Attempt     int           `bson:"attempt"`
+	ProcessedAt *time.Time    `bson:"processedAt"`
+}
+
+func (m OutboxMessage) toDomain() message.OutboxMessage {
+	return message.OutboxMessage{
+		Id:              m.ID.Hex(),
+		MessageID:       m.MessageID,
+		Payload:         []byte(m.Payload),
+		PublishAttempts: m.PublishAttempts,
+		LastError:       m.LastError,
+		NextAttemptAt:   m.NextAttemptAt,
+		LeaseOwner:      m.LeaseOwner,
+		LeaseExpiresAt:  m.LeaseExpiresAt,
+		DeliveredAt:     m.DeliveredAt,
+		CreatedAt:       m.CreatedAt,
+	}
+}
+
+func newOutboxDocument(outboxMsg message.OutboxMessage, timeNow time.Time) OutboxMessage {
+	nextAttemptAt := outboxMsg.NextAttemptAt
+	if nextAttemptAt == nil {
+		nextAttemptAt = &timeNow
+	}
+
+	return OutboxMessage{
+		ID:             bson.NewObjectID(),
+		MessageID:      outboxMsg.MessageID,
+		Payload:        string(outboxMsg.Payload),
+		LastError:      outboxMsg.LastError,
+		NextAttemptAt:  nextAttemptAt,
+		LeaseOwner:     outboxMsg.LeaseOwner,
+		LeaseExpiresAt: outboxMsg.LeaseExpiresAt,
+		CreatedAt:      &timeNow,
+	}
+}
@@ -0,0 +1,57 @@
+package mongoDB
+
+import (
+	"context"
+	"fmt"
+	"github.com/jiin-yang/messageBird/internal/ratelimit"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+const rateLimitWindowsCollection = "rate_limit_windows"
+
+type rateLimitRepo struct {
+	collection *mongo.Collection
+}
+
+type NewRateLimitRepositoryOpts struct {
+	Client *Client
+}
+
+func NewRateLimitRepository(opts *NewRateLimitRepositoryOpts) ratelimit.Repository {
+	return &rateLimitRepo{
+		collection: opts.Client.Database.Collection(rateLimitWindowsCollection),
+	}
+}
+
+// ReserveWindow works like the tenant usage counters: a full window makes the upsert insert a second
+// document with the same _id, which fails with a duplicate key error.
+func (r rateLimitRepo) ReserveWindow(ctx context.Context, windowID string, count, limit int, expireAt time.Time) error {
+	filter := bson.M{"_id": windowID, "count": bson.M{"$lte": limit - count}}
+	update := bson.M{
+		"$inc":         bson.M{"count": count},
+		"$setOnInsert": bson.M{"expireAt": expireAt},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, filter, update, options.UpdateOne().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		_, err = r.collection.UpdateOne(ctx, filter, update, options.UpdateOne().SetUpsert(true))
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return ratelimit.ErrRateLimited
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reserve rate limit window: %w", err)
+	}
+	return nil
+}
+
+func (r rateLimitRepo) ReleaseWindow(ctx context.Context, windowID string, count int) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": windowID}, bson.M{"$inc": bson.M{"count": -count}})
+	if err != nil {
+		return fmt.Errorf("failed to release rate limit window: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package mongoDB
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jiin-yang/messageBird/internal/tenant"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+const (
+	tenantsCollection     = "tenants"
+	tenantUsageCollection = "tenant_usage"
+)
+
+type tenantRepo struct {
+	collection *mongo.Collection
+	usage      *mongo.Collection
+}
+
+type NewTenantRepositoryOpts struct {
+	Client *Client
+}
+
+func NewTenantRepository(opts *NewTenantRepositoryOpts) tenant.Repository {
+	return &tenantRepo{
+		collection: opts.Client.Database.Collection(tenantsCollection),
+		usage:      opts.Client.Database.Collection(tenantUsageCollection),
+	}
+}
+
+func (r tenantRepo) CreateTenant(ctx context.Context, t tenant.Tenant) (*tenant.Tenant, error) {
+	timeNow := time.Now()
+	dbData := Tenant{
+		ID:           t.Id,
+		Name:         t.Name,
+		Sender:       newSenderConfigDocument(t.Sender),
+		DailyQuota:   t.DailyQuota,
+		MonthlyQuota: t.MonthlyQuota,
+		CreatedAt:    &timeNow,
+	}
+
+	_, err := r.collection.InsertOne(ctx, dbData)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, tenant.ErrTenantExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tenant: %w", err)
+	}
+
+	created := dbData.toDomain()
+	return &created, nil
+}
+
+func (r tenantRepo) EnsureTenant(ctx context.Context, t tenant.Tenant) error {
+	timeNow := time.Now()
+	update := bson.M{"$setOnInsert": bson.M{
+		"name":         t.Name,
+		"dailyQuota":   t.DailyQuota,
+		"monthlyQuota": t.MonthlyQuota,
+		"createdAt":    timeNow,
+	}}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": t.Id}, update, options.UpdateOne().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to ensure tenant: %w", err)
+	}
+	return nil
+}
+
+func (r tenantRepo) GetTenant(ctx context.Context, tenantID string) (*tenant.Tenant, error) {
+	var dbTenant Tenant
+	err := r.collection.FindOne(ctx, bson.M{"_id": tenantID}).Decode(&dbTenant)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, tenant.ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+
+	t := dbTenant.toDomain()
+	return &t, nil
+}
+
+func (r tenantRepo) ListTenants(ctx context.Context) ([]tenant.Tenant, error) {
+	cur, err := r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	var tenants []tenant.Tenant
+	for cur.Next(ctx) {
+		var dbTenant Tenant
+		if decodeErr := cur.Decode(&dbTenant); decodeErr != nil {
+			return nil, decodeErr
+		}
+		tenants = append(tenants, dbTenant.toDomain())
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+func (r tenantRepo) UpdateTenant(ctx context.Context, t tenant.Tenant) (*tenant.Tenant, error) {
+	set := bson.M{
+		"name":         t.Name,
+		"dailyQuota":   t.DailyQuota,
+		"monthlyQuota": t.MonthlyQuota,
+		"updatedAt":    time.Now(),
+	}
+	update := bson.M{"$set": set}
+	if t.Sender != nil {
+		set["sender"] = newSenderConfigDocument(t.Sender)
+	} else {
+		update["$unset"] = bson.M{"sender": ""}
+	}
+
+	var dbTenant Tenant
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": t.Id}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&dbTenant)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, tenant.ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update tenant: %w", err)
+	}
+
+	updated := dbTenant.toDomain()
+	return &updated, nil
+}
+
+// ReserveUsage only matches the counter while there is room for count more messages. A full counter
+// makes the upsert insert a second document with the same _id, which fails with a duplicate key error.
+// Two concurrent first reservations of a period fail the same way, so a duplicate key is retried once.
+func (r tenantRepo) ReserveUsage(ctx context.Context, tenantID, period string, count, limit int, expireAt time.Time) error {
+	filter := bson.M{"_id": usageID(tenantID, period), "count": bson.M{"$lte": limit - count}}
+	update := bson.M{
+		"$inc": bson.M{"count": count},
+		"$setOnInsert": bson.M{
+			"tenantId": tenantID,
+			"period":   period,
+			"expireAt": expireAt,
+		},
+	}
+
+	_, err := r.usage.UpdateOne(ctx, filter, update, options.UpdateOne().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		_, err = r.usage.UpdateOne(ctx, filter, update, options.UpdateOne().SetUpsert(true))
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return tenant.ErrQuotaExceeded
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reserve tenant usage: %w", err)
+	}
+	return nil
+}
+
+func (r tenantRepo) ReleaseUsage(ctx context.Context, tenantID, period string, count int) error {
+	_, err := r.usage.UpdateOne(ctx, bson.M{"_id": usageID(tenantID, period)}, bson.M{"$inc": bson.M{"count": -count}})
+	if err != nil {
+		return fmt.Errorf("failed to release tenant usage: %w", err)
+	}
+	return nil
+}
+
+func (r tenantRepo) GetUsage(ctx context.Context, tenantID string, periods []string) (map[string]int, error) {
+	ids := make(bson.A, 0, len(periods))
+	for _, period := range periods {
+		ids = append(ids, usageID(tenantID, period))
+	}
+
+	cur, err := r.usage.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant usage: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	usage := make(map[string]int, len(periods))
+	for cur.Next(ctx) {
+		var dbUsage TenantUsage
+		if decodeErr := cur.Decode(&dbUsage); decodeErr != nil {
+			return nil, decodeErr
+		}
+		usage[dbUsage.Period] = dbUsage.Count
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
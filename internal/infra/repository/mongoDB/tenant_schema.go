@@ -0,0 +1,81 @@
+package mongoDB
+
+import (
+	"github.com/jiin-yang/messageBird/internal/tenant"
+	"time"
+)
+
+// Tenant uses the tenant id chosen by the admin as _id.
+type Tenant struct {
+	ID           string        `bson:"_id"`
+	Name         string        `bson:"name"`
+	Sender       *SenderConfig `bson:"sender,omitempty"`
+	DailyQuota   int           `bson:"dailyQuota"`
+	MonthlyQuota int           `bson:"monthlyQuota"`
+	CreatedAt    *time.Time    `bson:"createdAt"`
+	UpdatedAt    *time.Time    `bson:"updatedAt,omitempty"`
+}
+
+type SenderConfig struct {
+	Provider       string            `bson:"provider"`
+	URL            string            `bson:"url"`
+	TimeoutSeconds int               `bson:"timeoutSeconds,omitempty"`
+	Headers        map[string]string `bson:"headers,omitempty"`
+	ToField        string            `bson:"toField,omitempty"`
+	ContentField   string            `bson:"contentField,omitempty"`
+	MessageIDPath  string            `bson:"messageIdPath,omitempty"`
+	StatePath      string            `bson:"statePath,omitempty"`
+}
+
+// TenantUsage counts the messages of a tenant in one quota period, keyed by `<tenantId>|<period>`.
+type TenantUsage struct {
+	ID       string     `bson:"_id"`
+	TenantID string     `bson:"tenantId"`
+	Period   string     `bson:"period"`
+	Count    int        `bson:"count"`
+	ExpireAt *time.Time `bson:"expireAt"`
+}
+
+func (t Tenant) toDomain() tenant.Tenant {
+	domain := tenant.Tenant{
+		Id:           t.ID,
+		Name:         t.Name,
+		DailyQuota:   t.DailyQuota,
+		MonthlyQuota: t.MonthlyQuota,
+		CreatedAt:    t.CreatedAt,
+		UpdatedAt:    t.UpdatedAt,
+	}
+	if t.Sender != nil {
+		domain.Sender = &tenant.SenderConfig{
+			Provider:       t.Sender.Provider,
+			URL:            t.Sender.URL,
+			TimeoutSeconds: t.Sender.TimeoutSeconds,
+			Headers:        t.Sender.Headers,
+			ToField:        t.Sender.ToField,
+			ContentField:   t.Sender.ContentField,
+			MessageIDPath:  t.Sender.MessageIDPath,
+			StatePath:      t.Sender.StatePath,
+		}
+	}
+	return domain
+}
+
+func newSenderConfigDocument(sender *tenant.SenderConfig) *SenderConfig {
+	if sender == nil {
+		return nil
+	}
+	return &SenderConfig{
+		Provider:       sender.Provider,
+		URL:            sender.URL,
+		TimeoutSeconds: sender.TimeoutSeconds,
+		Headers:        sender.Headers,
+		ToField:        sender.ToField,
+		ContentField:   sender.ContentField,
+		MessageIDPath:  sender.MessageIDPath,
+		StatePath:      sender.StatePath,
+	}
+}
+
+func usageID(tenantID, period string) string {
+	return tenantID + "|" + period
+}
@@ -0,0 +1,31 @@
+package mongoDB
+
+import (
+	"context"
+	"fmt"
+	"github.com/jiin-yang/messageBird/internal/tenant"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// scopeToTenant restricts filter to the tenant of the caller. Background work (cron, retry consumer,
+// reaper) carries no tenant and sees the documents of every tenant.
+func scopeToTenant(ctx context.Context, filter bson.M) bson.M {
+	if tenantID := tenant.IDFromContext(ctx); tenantID != "" {
+		filter["tenantId"] = tenantID
+	}
+	return filter
+}
+
+// BackfillDefaultTenant assigns the documents created before tenants existed to the default tenant.
+func BackfillDefaultTenant(ctx context.Context, client *Client) error {
+	filter := bson.M{"tenantId": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"tenantId": tenant.DefaultTenantID}}
+
+	for _, collection := range []string{messagesCollection, apiKeysCollection} {
+		_, err := client.Database.Collection(collection).UpdateMany(ctx, filter, update)
+		if err != nil {
+			return fmt.Errorf("failed to backfill the tenant of %s: %w", collection, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package mongoDB
+
+import (
+	"context"
+	"github.com/jiin-yang/messageBird/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+)
+
+// commandTracer starts a client span for every command sent to MongoDB. The driver reports the start
+// and the end of a command separately, the open spans are kept by request id in between.
+type commandTracer struct {
+	spans sync.Map
+}
+
+func newCommandMonitor() *event.CommandMonitor {
+	t := &commandTracer{}
+	return &event.CommandMonitor{
+		Started:   t.started,
+		Succeeded: t.succeeded,
+		Failed:    t.failed,
+	}
+}
+
+func (t *commandTracer) started(ctx context.Context, evt *event.CommandStartedEvent) {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.namespace", evt.DatabaseName),
+		attribute.String("db.operation.name", evt.CommandName),
+	}
+	collection, err := evt.Command.LookupErr(evt.CommandName)
+	if err == nil {
+		if name, ok := collection.StringValueOK(); ok {
+			attrs = append(attrs, attribute.String("db.collection.name", name))
+		}
+	}
+
+	_, span := tracing.Tracer().Start(ctx, "mongodb."+evt.CommandName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	t.spans.Store(evt.RequestID, span)
+}
+
+func (t *commandTracer) succeeded(_ context.Context, evt *event.CommandSucceededEvent) {
+	t.end(evt.RequestID, nil)
+}
+
+func (t *commandTracer) failed(ctx context.Context, evt *event.CommandFailedEvent) {
+	log.Ctx(ctx).Debug().
+		Err(evt.Failure).
+		Str("command", evt.CommandName).
+		Str("database", evt.DatabaseName).
+		Msg("MongoDB command failed")
+	t.end(evt.RequestID, evt.Failure)
+}
+
+func (t *commandTracer) end(requestID int64, err error) {
+	value, ok := t.spans.LoadAndDelete(requestID)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	tracing.RecordError(span, err)
+	span.End()
+}
@@ -3,66 +3,112 @@ package message
 import (
 	"context"
 	"github.com/rs/zerolog/log"
+	"sync"
 	"time"
 )
 
 const (
-	cronJobFrequencySeconds = 10
+	defaultCronJobFrequency = 10 * time.Second
 )
 
 type Cron struct {
 	messageUseCase UseCase
-	StopChan       chan bool
-	IsRunning      bool
-	cancelFunc     context.CancelFunc
+	frequency      time.Duration
+
+	mu         sync.Mutex
+	running    bool
+	cancelFunc context.CancelFunc
+	// done is closed once the running loop has finished its last batch.
+	done chan struct{}
 }
 
-func NewCron(messageUseCase UseCase) *Cron {
+func NewCron(messageUseCase UseCase, frequency time.Duration) *Cron {
+	if frequency <= 0 {
+		frequency = defaultCronJobFrequency
+	}
+
 	return &Cron{
 		messageUseCase: messageUseCase,
-		StopChan:       make(chan bool),
-		IsRunning:      false,
+		frequency:      frequency,
 	}
 }
 
+// IsRunning reports whether the cron claims new messages, it is false as soon as StopCron returns.
+func (c *Cron) IsRunning() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
 func (c *Cron) StartCron() {
-	if c.IsRunning {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.running {
 		log.Warn().Msg("Cron job is already running - cron.StartCron")
 		return
 	}
-	c.IsRunning = true
+	c.running = true
 
 	ctx, cancel := context.WithCancel(context.Background())
 	c.cancelFunc = cancel
+	done := make(chan struct{})
+	c.done = done
 
 	log.Info().Msg("Cron job started - cron.StartCron")
 	go func() {
+		defer close(done)
 		for {
+			log.Info().Msgf("Executing cron job - cron.StartCron")
+			err := c.messageUseCase.SendMessages(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Error executing cron job - cron.StartCron")
+			}
+
 			select {
-			case <-c.StopChan:
+			case <-ctx.Done():
 				log.Info().Msg("Cron job stopped - cron.StartCron")
-				c.IsRunning = false
 				return
-			default:
-				log.Info().Msgf("Executing cron job - cron.StartCron")
-				err := c.messageUseCase.SendMessages(ctx)
-				if err != nil {
-					log.Error().Err(err).Msg("Error executing cron job - cron.StartCron")
-				}
-				time.Sleep(cronJobFrequencySeconds * time.Second)
+			case <-time.After(c.frequency):
 			}
 		}
 	}()
 }
 
+// StopCron stops claiming new messages. The batch being sent finishes in the background, use Shutdown
+// to wait for it.
 func (c *Cron) StopCron() {
-	if !c.IsRunning {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.running {
 		log.Warn().Msg("Cron job is not running - cron.StopCron")
 		return
 	}
-	c.StopChan <- true
+	c.running = false
 	if c.cancelFunc != nil {
 		c.cancelFunc()
 		log.Info().Msg("Context canceled - cron.StopCron")
 	}
 }
+
+// Shutdown stops the cron and waits until the current batch is sent or ctx expires.
+func (c *Cron) Shutdown(ctx context.Context) error {
+	if c.IsRunning() {
+		c.StopCron()
+	}
+
+	c.mu.Lock()
+	done := c.done
+	c.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,12 +1,50 @@
 package message
 
 import (
+	"github.com/jiin-yang/messageBird/internal/httperror"
 	"time"
 )
 
 type CreateMessageRequest struct {
-	PhoneNumber string `json:"phoneNumber" validate:"required,e164"`
-	Content     string `json:"content" validate:"required,min=1,max=40,startsnotwith= "`
+	PhoneNumber string     `json:"phoneNumber" validate:"required,e164"`
+	Content     string     `json:"content" validate:"required,min=1,max=40,startsnotwith= "`
+	SendAt      *time.Time `json:"sendAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+}
+
+type CreateMessagesBatchResponse struct {
+	Created int                            `json:"created"`
+	Failed  int                            `json:"failed"`
+	Results []CreateMessageBatchItemResult `json:"results"`
+}
+
+type CreateMessageBatchItemResult struct {
+	Index   int                      `json:"index"`
+	Message *CreateMessageResponse   `json:"message,omitempty"`
+	Error   *httperror.ErrorResponse `json:"error,omitempty"`
+}
+
+type RescheduleMessageRequest struct {
+	SendAt    *time.Time `json:"sendAt" validate:"required"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type ListMessagesRequest struct {
+	Status      string    `query:"status" validate:"omitempty,oneof=New Process Sent Fail Dead Scheduled Expired Cancelled"`
+	PhoneNumber string    `query:"phoneNumber" validate:"omitempty,e164"`
+	CreatedFrom time.Time `query:"createdFrom"`
+	CreatedTo   time.Time `query:"createdTo"`
+	UpdatedFrom time.Time `query:"updatedFrom"`
+	UpdatedTo   time.Time `query:"updatedTo"`
+	Search      string    `query:"q" validate:"omitempty,max=40"`
+	Cursor      string    `query:"cursor"`
+	Limit       int       `query:"limit" validate:"omitempty,min=1"`
+	Sort        string    `query:"sort" validate:"omitempty,oneof=asc desc"`
+}
+
+type ListMessagesResponse struct {
+	Data       []GetMessageResponse `json:"data"`
+	NextCursor string               `json:"nextCursor,omitempty"`
 }
 
 type CreateMessageResponse struct {
@@ -14,6 +52,8 @@ type CreateMessageResponse struct {
 	PhoneNumber string     `json:"phoneNumber"`
 	Content     string     `json:"content"`
 	Status      string     `json:"status"`
+	SendAt      *time.Time `json:"sendAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
 	CreatedAt   *time.Time `json:"createdAt"`
 }
 
@@ -22,6 +62,78 @@ type GetMessageResponse struct {
 	PhoneNumber string     `json:"phoneNumber"`
 	Content     string     `json:"content"`
 	Status      string     `json:"status"`
+	SendAt      *time.Time `json:"sendAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
 	CreatedAt   *time.Time `json:"createdAt"`
 	UpdatedAt   *time.Time `json:"updatedAt"`
 }
+
+type GetMessageDetailResponse struct {
+	Id          string                     `json:"id"`
+	PhoneNumber string                     `json:"phoneNumber"`
+	Content     string                     `json:"content"`
+	Status      string                     `json:"status"`
+	RetryCount  int                        `json:"retryCount"`
+	History     []StatusTransitionResponse `json:"history"`
+	Attempts    []DeliveryAttemptResponse  `json:"attempts"`
+	SendAt      *time.Time                 `json:"sendAt,omitempty"`
+	ExpiresAt   *time.Time                 `json:"expiresAt,omitempty"`
+	CreatedAt   *time.Time                 `json:"createdAt"`
+	UpdatedAt   *time.Time                 `json:"updatedAt"`
+	RequestID   string                     `json:"requestId,omitempty"`
+	TenantID    string                     `json:"tenantId"`
+}
+
+type StatusTransitionResponse struct {
+	From string     `json:"from,omitempty"`
+	To   string     `json:"to"`
+	At   *time.Time `json:"at"`
+}
+
+type DeliveryAttemptResponse struct {
+	Attempt     int        `json:"attempt"`
+	Provider    string     `json:"provider,omitempty"`
+	ResponseId  string     `json:"responseId,omitempty"`
+	HTTPStatus  int        `json:"httpStatus,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	AttemptedAt *time.Time `json:"attemptedAt"`
+}
+
+type ListDeadLettersRequest struct {
+	Limit int `query:"limit" validate:"omitempty,min=1"`
+}
+
+type ReplayDeadLettersRequest struct {
+	MessageIDs []string `json:"messageIds" validate:"required,min=1,dive,required"`
+}
+
+// PurgeDeadLettersRequest purges the given messages, or the whole dead-letter queue when no id is given.
+type PurgeDeadLettersRequest struct {
+	MessageIDs []string `json:"messageIds" validate:"omitempty,dive,required"`
+}
+
+type DeadLetterResponse struct {
+	MessageID   string                      `json:"messageId"`
+	PhoneNumber string                      `json:"phoneNumber"`
+	Content     string                      `json:"content"`
+	Attempt     int                         `json:"attempt"`
+	Reason      string                      `json:"reason"`
+	LastError   string                      `json:"lastError,omitempty"`
+	Failures    []DeadLetterFailureResponse `json:"failures"`
+	DeadAt      time.Time                   `json:"deadAt"`
+	RawBody     string                      `json:"rawBody,omitempty"`
+}
+
+type DeadLetterFailureResponse struct {
+	Attempt  int       `json:"attempt"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+type ReplayDeadLettersResponse struct {
+	Replayed []string `json:"replayed"`
+}
+
+type PurgeDeadLettersResponse struct {
+	Purged int `json:"purged"`
+}
@@ -0,0 +1,18 @@
+package message
+
+import "errors"
+
+var (
+	ErrMessageNotFound   = errors.New("message not found")
+	ErrInvalidMessageID  = errors.New("invalid message id")
+	ErrInvalidCursor     = errors.New("invalid cursor")
+	ErrInvalidSchedule   = errors.New("expiresAt must be after sendAt and in the future")
+	ErrStatusConflict    = errors.New("message status does not allow this operation")
+	ErrDuplicateDelivery = errors.New("queue message was already processed")
+
+	// errLeaseLost stops the send of a claimed message which another instance took over
+	errLeaseLost = errors.New("message lease lost")
+
+	ErrDuplicateIdempotencyKey = errors.New("idempotency key already exists")
+	ErrIdempotencyKeyReused    = errors.New("idempotency key was already used with a different request body")
+)
@@ -2,12 +2,28 @@ package message
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/jiin-yang/messageBird/internal/apikey"
+	"github.com/jiin-yang/messageBird/internal/httperror"
+	"github.com/jiin-yang/messageBird/internal/ratelimit"
+	"github.com/jiin-yang/messageBird/internal/tenant"
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog/log"
+	"math"
 	"net/http"
+	"strconv"
 )
 
-const RetryFailMessageSendFibonacciLimit = 3
+const (
+	RetryFailMessageSendFibonacciLimit = 3
+
+	idempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+
+	maxBatchCreateSize = 500
+)
 
 type Handler interface {
 	createMessage(ctx echo.Context) error
@@ -17,24 +33,41 @@ type handler struct {
 	echo    *echo.Echo
 	useCase UseCase
 	cron    *Cron
+	reaper  *Reaper
 }
 
-func NewHandler(e *echo.Echo, u UseCase, cron *Cron) Handler {
+func NewHandler(e *echo.Echo, u UseCase, cron *Cron, reaper *Reaper) Handler {
 	h := &handler{
 		echo:    e,
 		useCase: u,
 		cron:    cron,
+		reaper:  reaper,
 	}
 	h.registerRoutes()
 	return h
 }
 
 func (h *handler) registerRoutes() {
-	h.echo.POST("/messages", h.createMessage)
-	h.echo.POST("/messages/cron/start", h.startCron)
-	h.echo.POST("/messages/cron/stop", h.stopCron)
-	h.echo.GET("/messages", h.getSentMessages)
-	h.echo.POST("/messages/queue/stop", h.stopQueue)
+	read := apikey.RequireScope(apikey.ScopeMessagesRead)
+	write := apikey.RequireScope(apikey.ScopeMessagesWrite)
+	// The dispatcher, dead letter and reaper routes act on every tenant, only platform keys pass this scope
+	dispatcher := apikey.RequireScope(apikey.ScopeAdminDispatcher)
+
+	h.echo.POST("/messages", h.createMessage, write)
+	h.echo.POST("/messages/batch", h.createMessages, write)
+	h.echo.POST("/messages/cron/start", h.startCron, dispatcher)
+	h.echo.POST("/messages/cron/stop", h.stopCron, dispatcher)
+	h.echo.GET("/messages", h.listMessages, read)
+	h.echo.GET("/messages/:id", h.getMessage, read)
+	h.echo.PUT("/messages/:id/schedule", h.rescheduleMessage, write)
+	h.echo.POST("/messages/:id/cancel", h.cancelMessage, write)
+	h.echo.DELETE("/messages/:id", h.cancelMessage, write)
+	h.echo.POST("/messages/queue/stop", h.stopQueue, dispatcher)
+	h.echo.GET("/admin/reaper", h.getReaperStats, dispatcher)
+	h.echo.POST("/admin/reaper/run", h.runReaper, dispatcher)
+	h.echo.GET("/admin/dead-letters", h.listDeadLetters, dispatcher)
+	h.echo.POST("/admin/dead-letters/replay", h.replayDeadLetters, dispatcher)
+	h.echo.POST("/admin/dead-letters/purge", h.purgeDeadLetters, dispatcher)
 }
 
 func (h *handler) createMessage(ctx echo.Context) error {
@@ -51,9 +84,31 @@ func (h *handler) createMessage(ctx echo.Context) error {
 			SetInternal(err)
 	}
 
-	msgResponse, err := h.useCase.CreateMessage(ctx.Request().Context(), *requestDto)
+	idempotencyKey := ctx.Request().Header.Get(idempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("%s header cannot be longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+	}
+
+	msgResponse, err := h.useCase.CreateMessage(ctx.Request().Context(), *requestDto, idempotencyKey)
 	if err != nil {
-		log.Error().
+		if errors.Is(err, ErrInvalidSchedule) {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidSchedule.Error()).
+				SetInternal(err)
+		}
+		if errors.Is(err, ErrIdempotencyKeyReused) {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, ErrIdempotencyKeyReused.Error()).
+				SetInternal(err)
+		}
+		if errors.Is(err, tenant.ErrQuotaExceeded) {
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error()).
+				SetInternal(err)
+		}
+		if errors.Is(err, ratelimit.ErrRateLimited) {
+			return rateLimitedError(ctx, err)
+		}
+
+		log.Ctx(ctx.Request().Context()).Error().
 			Err(err).
 			Str("method", "CreateMessage").
 			Str("phoneNumber", requestDto.PhoneNumber).
@@ -66,18 +121,120 @@ func (h *handler) createMessage(ctx echo.Context) error {
 	return ctx.JSON(http.StatusCreated, msgResponse)
 }
 
+func (h *handler) createMessages(ctx echo.Context) error {
+	var requestDtos []CreateMessageRequest
+	err := ctx.Bind(&requestDtos)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body").
+			SetInternal(err)
+	}
+
+	if len(requestDtos) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "request body must contain at least one message")
+	}
+	if len(requestDtos) > maxBatchCreateSize {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("request body cannot contain more than %d messages", maxBatchCreateSize))
+	}
+
+	resp := CreateMessagesBatchResponse{
+		Results: make([]CreateMessageBatchItemResult, len(requestDtos)),
+	}
+
+	// Her mesaj ayri ayri dogrulanir, hatali olanlar batch'in geri kalanini engellemez
+	var (
+		validDtos    []CreateMessageRequest
+		validIndexes []int
+	)
+	for i, requestDto := range requestDtos {
+		resp.Results[i].Index = i
+
+		err = ctx.Validate(requestDto)
+		if err != nil {
+			var ve validator.ValidationErrors
+			if errors.As(err, &ve) {
+				resp.Results[i].Error = httperror.NewValidationErrorResponse(ve)
+			} else {
+				resp.Results[i].Error = &httperror.ErrorResponse{Message: err.Error()}
+			}
+			continue
+		}
+
+		validDtos = append(validDtos, requestDto)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if len(validDtos) > 0 {
+		results, err := h.useCase.CreateMessages(ctx.Request().Context(), validDtos)
+		if errors.Is(err, tenant.ErrQuotaExceeded) {
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error()).
+				SetInternal(err)
+		}
+		if errors.Is(err, ratelimit.ErrRateLimited) {
+			return rateLimitedError(ctx, err)
+		}
+		if err != nil {
+			log.Ctx(ctx.Request().Context()).Error().
+				Err(err).
+				Str("method", "CreateMessages").
+				Int("count", len(validDtos)).
+				Msg("failed to create messages - handler")
+
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error()).
+				SetInternal(err)
+		}
+
+		for i, result := range results {
+			index := validIndexes[i]
+			if result.Err != nil {
+				resp.Results[index].Error = &httperror.ErrorResponse{Message: result.Err.Error()}
+				continue
+			}
+			resp.Results[index].Message = result.Message
+		}
+	}
+
+	for _, result := range resp.Results {
+		if result.Error != nil {
+			resp.Failed++
+		} else {
+			resp.Created++
+		}
+	}
+
+	statusCode := http.StatusCreated
+	if resp.Failed > 0 {
+		statusCode = http.StatusMultiStatus
+	}
+
+	return ctx.JSON(statusCode, resp)
+}
+
+// rateLimitedError answers 429, with a Retry-After header when waiting makes the request pass.
+func rateLimitedError(ctx echo.Context, err error) error {
+	var limitErr *ratelimit.LimitError
+	if errors.As(err, &limitErr) && limitErr.Reason == "" && limitErr.RetryAfter > 0 {
+		retryAfter := int(math.Ceil(limitErr.RetryAfter.Seconds()))
+		ctx.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	}
+	return echo.NewHTTPError(http.StatusTooManyRequests, err.Error()).
+		SetInternal(err)
+}
+
 func (h *handler) startCron(ctx echo.Context) error {
-	if h.cron.IsRunning {
-		log.Warn().Msg("Cron job is already running - handler")
+	if h.cron.IsRunning() {
+		log.Ctx(ctx.Request().Context()).Warn().Msg("Cron job is already running - handler")
 		return ctx.JSON(http.StatusConflict, map[string]string{
 			"message": "Cron job is already running",
 		})
 	}
 
+	// echo reuses the context once the handler returns, the goroutine only keeps the logger
+	logger := log.Ctx(ctx.Request().Context())
 	go func() {
 		h.cron.StartCron()
 		h.useCase.StartConsumeFailures(context.Background(), RetryFailMessageSendFibonacciLimit)
-		log.Info().Msg("Cron job and RabbitMQ consumer started - handler")
+		logger.Info().Msg("Cron job and RabbitMQ consumer started - handler")
 	}()
 
 	return ctx.JSON(http.StatusOK, map[string]string{
@@ -86,8 +243,8 @@ func (h *handler) startCron(ctx echo.Context) error {
 }
 
 func (h *handler) stopCron(ctx echo.Context) error {
-	if !h.cron.IsRunning {
-		log.Warn().Msg("Cron job is not running - handler")
+	if !h.cron.IsRunning() {
+		log.Ctx(ctx.Request().Context()).Warn().Msg("Cron job is not running - handler")
 		return ctx.JSON(http.StatusConflict, map[string]string{
 			"message": "Cron job is not running",
 		})
@@ -96,7 +253,7 @@ func (h *handler) stopCron(ctx echo.Context) error {
 	h.cron.StopCron()
 	//h.useCase.StopConsumeFailures()
 
-	log.Info().Msg("Cron job stopped - handler")
+	log.Ctx(ctx.Request().Context()).Info().Msg("Cron job stopped - handler")
 	return ctx.JSON(http.StatusOK, map[string]string{
 		"message": "Cron job stopped successfully",
 	})
@@ -105,21 +262,212 @@ func (h *handler) stopCron(ctx echo.Context) error {
 func (h *handler) stopQueue(ctx echo.Context) error {
 	h.useCase.StopConsumeFailures()
 
-	log.Info().Msg("RabbitMQ consumer stopped - handler")
+	log.Ctx(ctx.Request().Context()).Info().Msg("RabbitMQ consumer stopped - handler")
 	return ctx.JSON(http.StatusOK, map[string]string{
 		"message": "RabbitMQ consumer stopped successfully",
 	})
 }
 
-func (h *handler) getSentMessages(ctx echo.Context) error {
-	messages, err := h.useCase.GetSentStatusMessages(ctx.Request().Context())
+func (h *handler) listMessages(ctx echo.Context) error {
+	var requestDto ListMessagesRequest
+	err := ctx.Bind(&requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid query parameters").
+			SetInternal(err)
+	}
+
+	err = ctx.Validate(requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "validation error").
+			SetInternal(err)
+	}
+
+	messages, err := h.useCase.ListMessages(ctx.Request().Context(), requestDto)
 	if err != nil {
-		log.Error().
+		if errors.Is(err, ErrInvalidCursor) {
+			return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidCursor.Error()).
+				SetInternal(err)
+		}
+
+		log.Ctx(ctx.Request().Context()).Error().
 			Err(err).
-			Msg("failed to get sent messages - handler")
+			Msg("failed to list messages - handler")
 
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error()).
 			SetInternal(err)
 	}
 	return ctx.JSON(http.StatusOK, messages)
 }
+
+func (h *handler) getMessage(ctx echo.Context) error {
+	messageID := ctx.Param("id")
+
+	msg, err := h.useCase.GetMessage(ctx.Request().Context(), messageID)
+	if err != nil {
+		return messageHTTPError(ctx, err, messageID, "failed to get message - handler")
+	}
+
+	return ctx.JSON(http.StatusOK, msg)
+}
+
+func (h *handler) rescheduleMessage(ctx echo.Context) error {
+	messageID := ctx.Param("id")
+
+	var requestDto RescheduleMessageRequest
+	err := ctx.Bind(&requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body").
+			SetInternal(err)
+	}
+
+	err = ctx.Validate(requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "validation error").
+			SetInternal(err)
+	}
+
+	msg, err := h.useCase.RescheduleMessage(ctx.Request().Context(), messageID, requestDto)
+	if err != nil {
+		return messageHTTPError(ctx, err, messageID, "failed to reschedule message - handler")
+	}
+
+	return ctx.JSON(http.StatusOK, msg)
+}
+
+func (h *handler) cancelMessage(ctx echo.Context) error {
+	messageID := ctx.Param("id")
+
+	msg, err := h.useCase.CancelMessage(ctx.Request().Context(), messageID)
+	if err != nil {
+		return messageHTTPError(ctx, err, messageID, "failed to cancel message - handler")
+	}
+
+	return ctx.JSON(http.StatusOK, msg)
+}
+
+// messageHTTPError maps the errors of the single message operations to their HTTP status codes.
+func messageHTTPError(ctx echo.Context, err error, messageID string, logMsg string) error {
+	switch {
+	case errors.Is(err, ErrInvalidMessageID):
+		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidMessageID.Error()).SetInternal(err)
+	case errors.Is(err, ErrInvalidSchedule):
+		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidSchedule.Error()).SetInternal(err)
+	case errors.Is(err, ErrMessageNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, ErrMessageNotFound.Error()).SetInternal(err)
+	case errors.Is(err, ErrStatusConflict):
+		return echo.NewHTTPError(http.StatusConflict, ErrStatusConflict.Error()).SetInternal(err)
+	}
+
+	log.Ctx(ctx.Request().Context()).Error().
+		Err(err).
+		Str("messageId", messageID).
+		Msg(logMsg)
+
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error()).
+		SetInternal(err)
+}
+
+func (h *handler) getReaperStats(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, h.reaper.Stats())
+}
+
+func (h *handler) runReaper(ctx echo.Context) error {
+	messages := h.reaper.RunOnce(ctx.Request().Context())
+
+	recovered := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		recovered = append(recovered, msg.Id)
+	}
+
+	log.Ctx(ctx.Request().Context()).Info().Int("recovered", len(recovered)).Msg("Reaper executed manually - handler")
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"recovered": recovered,
+		"stats":     h.reaper.Stats(),
+	})
+}
+
+func (h *handler) listDeadLetters(ctx echo.Context) error {
+	var requestDto ListDeadLettersRequest
+	err := ctx.Bind(&requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid query parameters").
+			SetInternal(err)
+	}
+
+	err = ctx.Validate(requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "validation error").
+			SetInternal(err)
+	}
+
+	deadLetters, err := h.useCase.ListDeadLetters(ctx.Request().Context(), requestDto)
+	if err != nil {
+		log.Ctx(ctx.Request().Context()).Error().
+			Err(err).
+			Msg("failed to list dead letters - handler")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error()).
+			SetInternal(err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"data": deadLetters,
+	})
+}
+
+func (h *handler) replayDeadLetters(ctx echo.Context) error {
+	var requestDto ReplayDeadLettersRequest
+	err := ctx.Bind(&requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body").
+			SetInternal(err)
+	}
+
+	err = ctx.Validate(requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "validation error").
+			SetInternal(err)
+	}
+
+	resp, err := h.useCase.ReplayDeadLetters(ctx.Request().Context(), requestDto)
+	if err != nil {
+		log.Ctx(ctx.Request().Context()).Error().
+			Err(err).
+			Int("requested", len(requestDto.MessageIDs)).
+			Msg("failed to replay dead letters - handler")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error()).
+			SetInternal(err)
+	}
+
+	log.Ctx(ctx.Request().Context()).Info().Int("replayed", len(resp.Replayed)).Msg("Dead letters replayed - handler")
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+func (h *handler) purgeDeadLetters(ctx echo.Context) error {
+	var requestDto PurgeDeadLettersRequest
+	err := ctx.Bind(&requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body").
+			SetInternal(err)
+	}
+
+	err = ctx.Validate(requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "validation error").
+			SetInternal(err)
+	}
+
+	resp, err := h.useCase.PurgeDeadLetters(ctx.Request().Context(), requestDto)
+	if err != nil {
+		log.Ctx(ctx.Request().Context()).Error().
+			Err(err).
+			Msg("failed to purge dead letters - handler")
+
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error()).
+			SetInternal(err)
+	}
+
+	log.Ctx(ctx.Request().Context()).Info().Int("purged", resp.Purged).Msg("Dead letters purged - handler")
+	return ctx.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,305 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/jiin-yang/messageBird/internal/apikey"
+	"github.com/jiin-yang/messageBird/internal/infra/rabbitmq"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testValidator struct {
+	validate *validator.Validate
+}
+
+func (v testValidator) Validate(i interface{}) error {
+	return v.validate.Struct(i)
+}
+
+// newTestServer registers the message routes on an echo instance whose requests are authenticated with a
+// platform key holding every scope.
+func newTestServer(useCase UseCase) *echo.Echo {
+	e := echo.New()
+	e.Validator = testValidator{validate: validator.New()}
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := &apikey.APIKey{Id: "platform-key", Scopes: apikey.Scopes}
+			c.SetRequest(c.Request().WithContext(apikey.NewContext(c.Request().Context(), key)))
+			return next(c)
+		}
+	})
+	NewHandler(e, useCase, nil, nil)
+	return e
+}
+
+func serve(e *echo.Echo, method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+type listUseCase struct {
+	UseCase
+
+	err     error
+	request ListMessagesRequest
+}
+
+func (u *listUseCase) ListMessages(_ context.Context, request ListMessagesRequest) (*ListMessagesResponse, error) {
+	u.request = request
+	if u.err != nil {
+		return nil, u.err
+	}
+	return &ListMessagesResponse{Data: []GetMessageResponse{}}, nil
+}
+
+func TestListMessagesStatusCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		err        error
+		wantStatus int
+	}{
+		{name: "valid query", target: "/messages?status=Sent&phoneNumber=%2B905551111111&sort=desc&limit=5", wantStatus: http.StatusOK},
+		{
+			name:       "malformed cursor",
+			target:     "/messages?cursor=not-an-id",
+			err:        fmt.Errorf("%w: the provided hex string is not a valid ObjectID", ErrInvalidCursor),
+			wantStatus: http.StatusBadRequest,
+		},
+		{name: "unknown status", target: "/messages?status=Lost", wantStatus: http.StatusBadRequest},
+		{name: "phone number not in E.164", target: "/messages?phoneNumber=05551111111", wantStatus: http.StatusBadRequest},
+		{name: "unknown sort", target: "/messages?sort=random", wantStatus: http.StatusBadRequest},
+		{name: "malformed date", target: "/messages?createdFrom=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "repository failure", target: "/messages", err: errors.New("mongo down"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useCase := &listUseCase{err: tt.err}
+			rec := serve(newTestServer(useCase), http.MethodGet, tt.target, "", nil)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("GET %s = %d, want %d: %s", tt.target, rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestListMessagesPassesTheQuery(t *testing.T) {
+	useCase := &listUseCase{}
+	target := "/messages?status=Sent&phoneNumber=%2B905551111111&createdFrom=2026-01-02T15:04:05Z&q=code&cursor=abc&limit=5&sort=desc"
+	rec := serve(newTestServer(useCase), http.MethodGet, target, "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d: %s", target, rec.Code, rec.Body.String())
+	}
+
+	got := useCase.request
+	if got.Status != "Sent" || got.PhoneNumber != "+905551111111" || got.Search != "code" ||
+		got.Cursor != "abc" || got.Limit != 5 || got.Sort != "desc" || got.CreatedFrom.IsZero() {
+		t.Errorf("request = %+v", got)
+	}
+}
+
+func TestCancelMessageStatusCodes(t *testing.T) {
+	repo := newMemoryRepo()
+	ids := map[Status]string{}
+	for _, status := range []Status{New, Scheduled, Fail, Process, Sent, Dead} {
+		ids[status] = repo.add(Message{PhoneNumber: "+905551111111", Content: "hello", Status: status})
+	}
+	e := newTestServer(newTestUseCase(repo))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{name: "new", method: http.MethodDelete, target: "/messages/" + ids[New], wantStatus: http.StatusOK},
+		{name: "scheduled", method: http.MethodPost, target: "/messages/" + ids[Scheduled] + "/cancel", wantStatus: http.StatusOK},
+		{name: "waiting for a retry", method: http.MethodDelete, target: "/messages/" + ids[Fail], wantStatus: http.StatusOK},
+		{name: "in flight", method: http.MethodDelete, target: "/messages/" + ids[Process], wantStatus: http.StatusConflict},
+		{name: "sent", method: http.MethodPost, target: "/messages/" + ids[Sent] + "/cancel", wantStatus: http.StatusConflict},
+		{name: "dead", method: http.MethodDelete, target: "/messages/" + ids[Dead], wantStatus: http.StatusConflict},
+		{name: "already cancelled", method: http.MethodDelete, target: "/messages/" + ids[New], wantStatus: http.StatusConflict},
+		{name: "unknown", method: http.MethodDelete, target: "/messages/000000000000000000000000", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := serve(e, tt.method, tt.target, "", nil)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: %s %s = %d, want %d: %s", tt.name, tt.method, tt.target, rec.Code, tt.wantStatus, rec.Body.String())
+		}
+	}
+
+	for status, wantStatus := range map[Status]Status{New: Cancelled, Sent: Sent, Dead: Dead} {
+		if got := repo.status(t, ids[status]); got != wantStatus {
+			t.Errorf("status of the %v message = %v, want %v", status, got, wantStatus)
+		}
+	}
+}
+
+func TestCreateMessageIdempotencyKey(t *testing.T) {
+	repo := newMemoryRepo()
+	e := newTestServer(newTestUseCase(repo))
+	body := `{"phoneNumber":"+905551111111","content":"hello"}`
+	key := map[string]string{idempotencyKeyHeader: "order-42"}
+
+	first := serve(e, http.MethodPost, "/messages", body, key)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first POST /messages = %d: %s", first.Code, first.Body.String())
+	}
+	var created CreateMessageResponse
+	if err := json.Unmarshal(first.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode the response: %v", err)
+	}
+
+	replay := serve(e, http.MethodPost, "/messages", body, key)
+	if replay.Code != http.StatusCreated {
+		t.Fatalf("replayed POST /messages = %d: %s", replay.Code, replay.Body.String())
+	}
+	var replayed CreateMessageResponse
+	if err := json.Unmarshal(replay.Body.Bytes(), &replayed); err != nil {
+		t.Fatalf("failed to decode the response: %v", err)
+	}
+	if replayed.Id != created.Id {
+		t.Errorf("replay returned message %s, want the original %s", replayed.Id, created.Id)
+	}
+
+	reused := serve(e, http.MethodPost, "/messages", `{"phoneNumber":"+905551111111","content":"another body"}`, key)
+	if reused.Code != http.StatusUnprocessableEntity {
+		t.Errorf("POST /messages reusing the key with another body = %d, want 422: %s", reused.Code, reused.Body.String())
+	}
+
+	withoutKey := serve(e, http.MethodPost, "/messages", body, nil)
+	if withoutKey.Code != http.StatusCreated {
+		t.Errorf("POST /messages without a key = %d: %s", withoutKey.Code, withoutKey.Body.String())
+	}
+
+	tooLong := map[string]string{idempotencyKeyHeader: strings.Repeat("k", maxIdempotencyKeyLength+1)}
+	if rec := serve(e, http.MethodPost, "/messages", body, tooLong); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /messages with a key over %d characters = %d, want 400", maxIdempotencyKeyLength, rec.Code)
+	}
+
+	if len(repo.messages) != 2 {
+		t.Errorf("stored %d messages, want 2", len(repo.messages))
+	}
+}
+
+func TestCreateMessagesReportsErrorsPerItem(t *testing.T) {
+	repo := newMemoryRepo()
+	e := newTestServer(newTestUseCase(repo))
+
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	body := `[
+		{"phoneNumber":"+905551111111","content":"first"},
+		{"phoneNumber":"0555","content":"invalid phone number"},
+		{"phoneNumber":"+905552222222","content":"` + strings.Repeat("x", 41) + `"},
+		{"phoneNumber":"+905553333333","content":"expired","expiresAt":"` + expired + `"},
+		{"phoneNumber":"+905554444444","content":"last"}
+	]`
+
+	rec := serve(e, http.MethodPost, "/messages/batch", body, nil)
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("POST /messages/batch = %d, want 207: %s", rec.Code, rec.Body.String())
+	}
+	var resp CreateMessagesBatchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode the response: %v", err)
+	}
+	if resp.Created != 2 || resp.Failed != 3 || len(resp.Results) != 5 {
+		t.Fatalf("created %d, failed %d, %d results; want 2, 3 and 5", resp.Created, resp.Failed, len(resp.Results))
+	}
+
+	for i, result := range resp.Results {
+		if result.Index != i {
+			t.Errorf("result %d has index %d", i, result.Index)
+		}
+		wantCreated := i == 0 || i == 4
+		if wantCreated != (result.Message != nil) || wantCreated == (result.Error != nil) {
+			t.Errorf("result %d = message %v, error %v", i, result.Message, result.Error)
+		}
+	}
+	if errs := resp.Results[1].Error.Errors; len(errs) != 1 || errs[0].Field != "PhoneNumber" {
+		t.Errorf("errors of the invalid phone number = %v, want one on PhoneNumber", errs)
+	}
+	if errs := resp.Results[2].Error.Errors; len(errs) != 1 || errs[0].Field != "Content" {
+		t.Errorf("errors of the long content = %v, want one on Content", errs)
+	}
+	if msg := resp.Results[3].Error.Message; msg != ErrInvalidSchedule.Error() {
+		t.Errorf("error of the expired message = %q, want %q", msg, ErrInvalidSchedule.Error())
+	}
+	if len(repo.messages) != 2 {
+		t.Errorf("stored %d messages, want 2", len(repo.messages))
+	}
+}
+
+func TestCreateMessagesStatusCodes(t *testing.T) {
+	valid := `{"phoneNumber":"+905551111111","content":"hello"}`
+	invalid := `{"phoneNumber":"0555","content":"hello"}`
+	tooMany := "[" + strings.TrimSuffix(strings.Repeat(valid+",", maxBatchCreateSize+1), ",") + "]"
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "every item valid", body: "[" + valid + "," + valid + "]", wantStatus: http.StatusCreated},
+		{name: "every item invalid", body: "[" + invalid + "]", wantStatus: http.StatusMultiStatus},
+		{name: "empty batch", body: "[]", wantStatus: http.StatusBadRequest},
+		{name: "not an array", body: valid, wantStatus: http.StatusBadRequest},
+		{name: "over the batch size", body: tooMany, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(newTestServer(newTestUseCase(newMemoryRepo())), http.MethodPost, "/messages/batch", tt.body, nil)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("POST /messages/batch = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestDeadLetterRoutes(t *testing.T) {
+	repo := newMemoryRepo()
+	dead := repo.add(Message{Status: Dead})
+	u := newTestUseCase(repo)
+	u.rabbitMQ = &deadLetterRabbitMQ{
+		queue: []rabbitmq.DeadLetter{deadLetterOf(dead), deadLetterOf("m2"), deadLetterOf("m3")},
+	}
+	e := newTestServer(u)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "list", method: http.MethodGet, target: "/admin/dead-letters?limit=1", wantStatus: http.StatusOK, wantBody: `"messageId":"` + dead + `"`},
+		{name: "list with an invalid limit", method: http.MethodGet, target: "/admin/dead-letters?limit=0x", wantStatus: http.StatusBadRequest},
+		{name: "replay", method: http.MethodPost, target: "/admin/dead-letters/replay", body: `{"messageIds":["` + dead + `"]}`, wantStatus: http.StatusOK, wantBody: `"replayed":["` + dead + `"]`},
+		{name: "replay without ids", method: http.MethodPost, target: "/admin/dead-letters/replay", body: `{"messageIds":[]}`, wantStatus: http.StatusBadRequest},
+		{name: "purge one", method: http.MethodPost, target: "/admin/dead-letters/purge", body: `{"messageIds":["m2"]}`, wantStatus: http.StatusOK, wantBody: `"purged":1`},
+		{name: "purge the rest", method: http.MethodPost, target: "/admin/dead-letters/purge", body: `{}`, wantStatus: http.StatusOK, wantBody: `"purged":1`},
+	}
+	for _, tt := range tests {
+		rec := serve(e, tt.method, tt.target, tt.body, nil)
+		if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantBody) {
+			t.Errorf("%s: %s %s = %d %s, want %d with %s", tt.name, tt.method, tt.target, rec.Code, rec.Body.String(), tt.wantStatus, tt.wantBody)
+		}
+	}
+}
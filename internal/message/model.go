@@ -10,8 +10,14 @@ const (
 	Sent
 	Fail
 	Dead
+	Scheduled
+	Expired
+	Cancelled
 )
 
+// lastStatus must be updated whenever a new status is appended above.
+const lastStatus = Cancelled
+
 func (s Status) String() string {
 	switch s {
 	case New:
@@ -24,24 +30,73 @@ func (s Status) String() string {
 		return "Fail"
 	case Dead:
 		return "Dead"
+	case Scheduled:
+		return "Scheduled"
+	case Expired:
+		return "Expired"
+	case Cancelled:
+		return "Cancelled"
 	default:
 		return "Unknown"
 	}
 }
 
+func ParseStatus(s string) (Status, bool) {
+	for status := New; status <= lastStatus; status++ {
+		if status.String() == s {
+			return status, true
+		}
+	}
+	return 0, false
+}
+
 type Message struct {
 	Id          string
+	TenantID    string
 	PhoneNumber string
 	Content     string
 	Status
-	CreatedAt *time.Time
-	UpdatedAt *time.Time
+	RetryCount int
+	History    []StatusTransition
+	Attempts   []DeliveryAttempt
+	SendAt     *time.Time
+	ExpiresAt  *time.Time
+	CreatedAt  *time.Time
+	UpdatedAt  *time.Time
+
+	RequestFingerprint string
+	// TraceParent is the W3C trace context of the request that created the message.
+	TraceParent string
+	// RequestID is the X-Request-ID of the request that created the message.
+	RequestID string
+}
+
+type StatusTransition struct {
+	From Status
+	To   Status
+	At   *time.Time
+}
+
+type DeliveryAttempt struct {
+	Attempt     int
+	Provider    string
+	ResponseId  string
+	HTTPStatus  int
+	Error       string
+	AttemptedAt *time.Time
 }
 
 type CreateMessage struct {
+	TenantID    string
 	PhoneNumber string
 	Content     string
 	Status
+	SendAt             *time.Time
+	ExpiresAt          *time.Time
+	IdempotencyKey     string
+	RequestFingerprint string
+	TraceParent        string
+	RequestID          string
 }
 
 type CreatedMessageDbResponse struct {
@@ -50,4 +105,41 @@ type CreatedMessageDbResponse struct {
 	PhoneNumber string
 	Content     string
 	Status
+	SendAt    *time.Time
+	ExpiresAt *time.Time
+}
+
+// CreateMessagesResult is the outcome of one item of a bulk insert, in the same order as the input.
+type CreateMessagesResult struct {
+	Message *CreatedMessageDbResponse
+	Err     error
+}
+
+type ListMessagesFilter struct {
+	Status      Status
+	PhoneNumber string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	UpdatedFrom time.Time
+	UpdatedTo   time.Time
+	Search      string
+	AfterID     string
+	Limit       int
+	Descending  bool
+}
+
+// OutboxMessage is a queue message stored in the database in the same transaction as the status change
+// it belongs to. The outbox relay publishes it to RabbitMQ and marks it delivered.
+type OutboxMessage struct {
+	Id              string
+	MessageID       string
+	Payload         []byte
+	PublishAttempts int
+	LastError       string
+	NextAttemptAt   *time.Time
+	// LeaseOwner and LeaseExpiresAt keep the relay away from an entry the saving instance publishes itself.
+	LeaseOwner     string
+	LeaseExpiresAt *time.Time
+	DeliveredAt    *time.Time
+	CreatedAt      *time.Time
 }
@@ -0,0 +1,127 @@
+package message
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOutboxRelayInterval  = 5 * time.Second
+	defaultOutboxRelayBatchSize = 50
+)
+
+type OutboxRelayOptions struct {
+	Interval  time.Duration
+	BatchSize int
+}
+
+// OutboxRelay periodically re-publishes queue messages whose first publish to RabbitMQ failed and
+// that were parked in the outbox instead.
+type OutboxRelay struct {
+	messageUseCase UseCase
+	opts           OutboxRelayOptions
+
+	mu         sync.Mutex
+	isRunning  bool
+	cancelFunc context.CancelFunc
+	// done is closed once the running loop has finished its last batch.
+	done chan struct{}
+}
+
+func NewOutboxRelay(messageUseCase UseCase, opts OutboxRelayOptions) *OutboxRelay {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultOutboxRelayInterval
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultOutboxRelayBatchSize
+	}
+
+	return &OutboxRelay{
+		messageUseCase: messageUseCase,
+		opts:           opts,
+	}
+}
+
+func (r *OutboxRelay) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.isRunning {
+		log.Warn().Msg("Outbox relay is already running - outboxRelay.Start")
+		return
+	}
+	r.isRunning = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancelFunc = cancel
+	done := make(chan struct{})
+	r.done = done
+
+	log.Info().
+		Dur("interval", r.opts.Interval).
+		Int("batchSize", r.opts.BatchSize).
+		Msg("Outbox relay started - outboxRelay.Start")
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(r.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info().Msg("Outbox relay stopped - outboxRelay.Start")
+				return
+			case <-ticker.C:
+				r.RunOnce(ctx)
+			}
+		}
+	}()
+}
+
+func (r *OutboxRelay) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.isRunning {
+		log.Warn().Msg("Outbox relay is not running - outboxRelay.Stop")
+		return
+	}
+	r.cancelFunc()
+	r.isRunning = false
+}
+
+// Shutdown stops the relay and waits until the batch in progress has finished or ctx expires.
+func (r *OutboxRelay) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	if r.isRunning {
+		r.cancelFunc()
+		r.isRunning = false
+	}
+	done := r.done
+	r.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RunOnce relays a single batch of due outbox entries.
+func (r *OutboxRelay) RunOnce(ctx context.Context) int {
+	published, err := r.messageUseCase.RelayOutbox(ctx, r.opts.BatchSize)
+	if err != nil {
+		log.Error().Err(err).Int("published", published).Msg("Failed to relay outbox messages - outboxRelay.RunOnce")
+	}
+	if published > 0 {
+		log.Info().Int("published", published).Msg("Relayed outbox messages to RabbitMQ - outboxRelay.RunOnce")
+	}
+	return published
+}
@@ -0,0 +1,199 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jiin-yang/messageBird/internal/infra/rabbitmq"
+	"testing"
+	"time"
+)
+
+type outboxRepo struct {
+	Repository
+
+	claimed     []OutboxMessage
+	claimErr    error
+	delivered   []string
+	rescheduled map[string]time.Time
+	lastErrors  map[string]string
+}
+
+func (r *outboxRepo) ClaimOutboxMessages(_ context.Context, _ string, limit int, _ time.Duration) ([]OutboxMessage, error) {
+	if len(r.claimed) > limit {
+		return r.claimed[:limit], r.claimErr
+	}
+	return r.claimed, r.claimErr
+}
+
+func (r *outboxRepo) MarkOutboxMessageDelivered(_ context.Context, outboxID string) error {
+	r.delivered = append(r.delivered, outboxID)
+	return nil
+}
+
+func (r *outboxRepo) RescheduleOutboxMessage(_ context.Context, outboxID string, lastError string, nextAttemptAt time.Time) error {
+	if r.rescheduled == nil {
+		r.rescheduled = make(map[string]time.Time)
+		r.lastErrors = make(map[string]string)
+	}
+	r.rescheduled[outboxID] = nextAttemptAt
+	r.lastErrors[outboxID] = lastError
+	return nil
+}
+
+type outboxRabbitMQ struct {
+	rabbitmq.Client
+
+	failFor   map[string]error
+	published []rabbitmq.FailedMessage
+}
+
+func (c *outboxRabbitMQ) PublishFailMessage(_ context.Context, msg rabbitmq.FailedMessage) error {
+	if err := c.failFor[msg.MessageID]; err != nil {
+		return err
+	}
+	c.published = append(c.published, msg)
+	return nil
+}
+
+func outboxEntry(t *testing.T, id string, publishAttempts int) OutboxMessage {
+	t.Helper()
+
+	payload, err := json.Marshal(rabbitmq.FailedMessage{MessageID: "msg-" + id, Attempt: 2, RetryAfterSeconds: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return OutboxMessage{Id: id, MessageID: "msg-" + id, Payload: payload, PublishAttempts: publishAttempts}
+}
+
+func TestRelayOutboxPublishesAndMarksDelivered(t *testing.T) {
+	repo := &outboxRepo{claimed: []OutboxMessage{outboxEntry(t, "1", 0), outboxEntry(t, "2", 0)}}
+	broker := &outboxRabbitMQ{}
+	u := NewUseCase(&NewUseCaseOptions{Repo: repo, RabbitMQ: broker, InstanceID: "test"})
+
+	published, err := u.RelayOutbox(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("RelayOutbox() error = %v", err)
+	}
+	if published != 2 {
+		t.Errorf("published = %d, want 2", published)
+	}
+	if len(repo.delivered) != 2 || repo.delivered[0] != "1" || repo.delivered[1] != "2" {
+		t.Errorf("delivered = %v, want [1 2]", repo.delivered)
+	}
+	if len(repo.rescheduled) != 0 {
+		t.Errorf("rescheduled = %v, want none", repo.rescheduled)
+	}
+
+	// The payload goes out as it was stored, the throttle delay included
+	if got := broker.published[0]; got.MessageID != "msg-1" || got.Attempt != 2 || got.RetryAfterSeconds != 30 {
+		t.Errorf("published message = %+v", got)
+	}
+}
+
+func TestRelayOutboxReschedulesFailedPublishes(t *testing.T) {
+	malformed := OutboxMessage{Id: "3", MessageID: "msg-3", Payload: []byte("{")}
+	repo := &outboxRepo{claimed: []OutboxMessage{outboxEntry(t, "1", 0), outboxEntry(t, "2", 3), malformed}}
+	broker := &outboxRabbitMQ{failFor: map[string]error{"msg-2": rabbitmq.ErrPublishNotConfirmed}}
+	u := NewUseCase(&NewUseCaseOptions{Repo: repo, RabbitMQ: broker, InstanceID: "test"})
+
+	before := time.Now()
+	published, err := u.RelayOutbox(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("RelayOutbox() error = %v", err)
+	}
+	if published != 1 {
+		t.Errorf("published = %d, want 1", published)
+	}
+	if len(repo.delivered) != 1 || repo.delivered[0] != "1" {
+		t.Errorf("delivered = %v, want [1]", repo.delivered)
+	}
+
+	// The fourth publish attempt of entry 2 backs off for 5s * 2^3
+	next, ok := repo.rescheduled["2"]
+	if !ok {
+		t.Fatal("entry 2 was not rescheduled")
+	}
+	if wait := next.Sub(before); wait < 40*time.Second || wait > 41*time.Second {
+		t.Errorf("entry 2 rescheduled in %v, want 40s", wait)
+	}
+	if repo.lastErrors["2"] != rabbitmq.ErrPublishNotConfirmed.Error() {
+		t.Errorf("last error of entry 2 = %q", repo.lastErrors["2"])
+	}
+	if _, ok := repo.rescheduled["3"]; !ok {
+		t.Error("malformed entry 3 was not rescheduled")
+	}
+}
+
+func TestRelayOutboxPublishesWhatWasClaimedBeforeAnError(t *testing.T) {
+	claimErr := errors.New("connection reset")
+	repo := &outboxRepo{claimed: []OutboxMessage{outboxEntry(t, "1", 0)}, claimErr: claimErr}
+	u := NewUseCase(&NewUseCaseOptions{Repo: repo, RabbitMQ: &outboxRabbitMQ{}, InstanceID: "test"})
+
+	published, err := u.RelayOutbox(context.Background(), 10)
+	if !errors.Is(err, claimErr) {
+		t.Errorf("RelayOutbox() error = %v, want %v", err, claimErr)
+	}
+	if published != 1 || len(repo.delivered) != 1 {
+		t.Errorf("published = %d, delivered = %v; want the claimed entry published", published, repo.delivered)
+	}
+
+	repo = &outboxRepo{claimErr: claimErr}
+	u = NewUseCase(&NewUseCaseOptions{Repo: repo, RabbitMQ: &outboxRabbitMQ{}, InstanceID: "test"})
+	if _, err := u.RelayOutbox(context.Background(), 10); !errors.Is(err, claimErr) {
+		t.Errorf("RelayOutbox() with nothing claimed error = %v, want %v", err, claimErr)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		publishAttempts int
+		want            time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{7, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.publishAttempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", tt.publishAttempts, got, tt.want)
+		}
+	}
+}
+
+type blockingRelayUseCase struct {
+	UseCase
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (u *blockingRelayUseCase) RelayOutbox(context.Context, int) (int, error) {
+	select {
+	case u.started <- struct{}{}:
+	default:
+	}
+	<-u.release
+	return 0, nil
+}
+
+func TestOutboxRelayShutdownWaitsForTheBatchInProgress(t *testing.T) {
+	useCase := &blockingRelayUseCase{started: make(chan struct{}, 1), release: make(chan struct{})}
+	relay := NewOutboxRelay(useCase, OutboxRelayOptions{Interval: time.Millisecond})
+	relay.Start()
+	<-useCase.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := relay.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() during a batch = %v, want DeadlineExceeded", err)
+	}
+
+	close(useCase.release)
+	if err := relay.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() after the batch = %v", err)
+	}
+}
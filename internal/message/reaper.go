@@ -0,0 +1,201 @@
+package message
+
+import (
+	"context"
+	"github.com/jiin-yang/messageBird/internal/metrics"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+const (
+	reaperRecentLimit     = 50
+	defaultReaperInterval = 30 * time.Second
+	defaultStuckThreshold = 5 * time.Minute
+)
+
+type RecoveryMode string
+
+const (
+	// RecoveryModeRequeue puts stuck messages back to `New` so the cron picks them up again.
+	RecoveryModeRequeue RecoveryMode = "requeue"
+	// RecoveryModeRetry marks stuck messages as `Fail` and publishes them to the fail queue.
+	RecoveryModeRetry RecoveryMode = "retry"
+)
+
+type ReaperOptions struct {
+	Interval       time.Duration
+	StuckThreshold time.Duration
+	Mode           RecoveryMode
+}
+
+type RecoveredMessage struct {
+	Id          string     `json:"id"`
+	PhoneNumber string     `json:"phoneNumber"`
+	RecoveredAt *time.Time `json:"recoveredAt"`
+}
+
+type ReaperStats struct {
+	IsRunning      bool               `json:"isRunning"`
+	Mode           RecoveryMode       `json:"mode"`
+	Runs           int                `json:"runs"`
+	FailedRuns     int                `json:"failedRuns"`
+	TotalRecovered int                `json:"totalRecovered"`
+	LastRunAt      *time.Time         `json:"lastRunAt,omitempty"`
+	LastError      string             `json:"lastError,omitempty"`
+	Recent         []RecoveredMessage `json:"recent"`
+}
+
+// Reaper periodically finds messages stuck in `Process` (e.g. the instance crashed mid-send)
+// and hands them back to the dispatcher according to the configured RecoveryMode.
+type Reaper struct {
+	messageUseCase UseCase
+	opts           ReaperOptions
+
+	mu         sync.Mutex
+	stats      ReaperStats
+	cancelFunc context.CancelFunc
+	// done is closed once the running loop has finished its last pass.
+	done chan struct{}
+}
+
+func NewReaper(messageUseCase UseCase, opts ReaperOptions) *Reaper {
+	if opts.Mode != RecoveryModeRetry {
+		opts.Mode = RecoveryModeRequeue
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultReaperInterval
+	}
+	if opts.StuckThreshold <= 0 {
+		opts.StuckThreshold = defaultStuckThreshold
+	}
+
+	return &Reaper{
+		messageUseCase: messageUseCase,
+		opts:           opts,
+		stats: ReaperStats{
+			Mode:   opts.Mode,
+			Recent: []RecoveredMessage{},
+		},
+	}
+}
+
+func (r *Reaper) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stats.IsRunning {
+		log.Warn().Msg("Reaper is already running - reaper.Start")
+		return
+	}
+	r.stats.IsRunning = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancelFunc = cancel
+	done := make(chan struct{})
+	r.done = done
+
+	log.Info().
+		Dur("interval", r.opts.Interval).
+		Dur("stuckThreshold", r.opts.StuckThreshold).
+		Str("mode", string(r.opts.Mode)).
+		Msg("Reaper started - reaper.Start")
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(r.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info().Msg("Reaper stopped - reaper.Start")
+				return
+			case <-ticker.C:
+				r.RunOnce(ctx)
+			}
+		}
+	}()
+}
+
+func (r *Reaper) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.stats.IsRunning {
+		log.Warn().Msg("Reaper is not running - reaper.Stop")
+		return
+	}
+	r.cancelFunc()
+	r.stats.IsRunning = false
+}
+
+// Shutdown stops the reaper and waits until the pass in progress has finished or ctx expires.
+func (r *Reaper) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	if r.stats.IsRunning {
+		r.cancelFunc()
+		r.stats.IsRunning = false
+	}
+	done := r.done
+	r.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RunOnce executes a single recovery pass and records its outcome in the reaper stats.
+func (r *Reaper) RunOnce(ctx context.Context) []Message {
+	messages, err := r.messageUseCase.RecoverStuckMessages(ctx, r.opts.StuckThreshold, r.opts.Mode)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	timeNow := time.Now()
+	r.stats.Runs++
+	r.stats.LastRunAt = &timeNow
+	r.stats.LastError = ""
+	if err != nil {
+		r.stats.FailedRuns++
+		r.stats.LastError = err.Error()
+		metrics.ReaperFailedRuns.Inc()
+	}
+
+	r.stats.TotalRecovered += len(messages)
+	metrics.ReaperRecovered.WithLabelValues(string(r.opts.Mode)).Add(float64(len(messages)))
+	for _, msg := range messages {
+		r.stats.Recent = append(r.stats.Recent, RecoveredMessage{
+			Id:          msg.Id,
+			PhoneNumber: msg.PhoneNumber,
+			RecoveredAt: &timeNow,
+		})
+	}
+	if len(r.stats.Recent) > reaperRecentLimit {
+		r.stats.Recent = r.stats.Recent[len(r.stats.Recent)-reaperRecentLimit:]
+	}
+
+	if len(messages) > 0 {
+		log.Warn().
+			Int("recovered", len(messages)).
+			Str("mode", string(r.opts.Mode)).
+			Msg("Recovered messages stuck in 'Process' - reaper.RunOnce")
+	}
+
+	return messages
+}
+
+func (r *Reaper) Stats() ReaperStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.Recent = append([]RecoveredMessage{}, r.stats.Recent...)
+	return stats
+}
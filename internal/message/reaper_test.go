@@ -0,0 +1,103 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"github.com/jiin-yang/messageBird/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+	"time"
+)
+
+type reaperUseCase struct {
+	UseCase
+
+	recovered []Message
+	err       error
+	mode      RecoveryMode
+
+	// started and release, when set, hold a pass until the test lets it finish
+	started chan struct{}
+	release chan struct{}
+}
+
+func (u *reaperUseCase) RecoverStuckMessages(_ context.Context, _ time.Duration, mode RecoveryMode) ([]Message, error) {
+	if u.started != nil {
+		select {
+		case u.started <- struct{}{}:
+		default:
+		}
+		<-u.release
+	}
+	u.mode = mode
+	return u.recovered, u.err
+}
+
+func TestReaperRunOnceRecordsRecoveredMessages(t *testing.T) {
+	useCase := &reaperUseCase{recovered: []Message{{Id: "1"}, {Id: "2"}}}
+	reaper := NewReaper(useCase, ReaperOptions{Mode: RecoveryModeRetry})
+
+	recoveredBefore := testutil.ToFloat64(metrics.ReaperRecovered.WithLabelValues(string(RecoveryModeRetry)))
+	failedBefore := testutil.ToFloat64(metrics.ReaperFailedRuns)
+
+	if got := reaper.RunOnce(context.Background()); len(got) != 2 {
+		t.Fatalf("RunOnce() recovered %d messages, want 2", len(got))
+	}
+	if useCase.mode != RecoveryModeRetry {
+		t.Errorf("recovery mode = %s, want retry", useCase.mode)
+	}
+
+	stats := reaper.Stats()
+	if stats.Runs != 1 || stats.FailedRuns != 0 || stats.TotalRecovered != 2 || len(stats.Recent) != 2 {
+		t.Errorf("stats = %+v, want 1 run with 2 recovered messages", stats)
+	}
+	if got := testutil.ToFloat64(metrics.ReaperRecovered.WithLabelValues(string(RecoveryModeRetry))) - recoveredBefore; got != 2 {
+		t.Errorf("reaper_recovered_total{mode=retry} grew by %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(metrics.ReaperFailedRuns) - failedBefore; got != 0 {
+		t.Errorf("reaper_failed_runs_total grew by %v, want 0", got)
+	}
+}
+
+func TestReaperRunOnceCountsFailedRuns(t *testing.T) {
+	useCase := &reaperUseCase{recovered: []Message{{Id: "1"}}, err: errors.New("transaction aborted")}
+	reaper := NewReaper(useCase, ReaperOptions{})
+
+	recoveredBefore := testutil.ToFloat64(metrics.ReaperRecovered.WithLabelValues(string(RecoveryModeRequeue)))
+	failedBefore := testutil.ToFloat64(metrics.ReaperFailedRuns)
+
+	reaper.RunOnce(context.Background())
+
+	stats := reaper.Stats()
+	if stats.FailedRuns != 1 || stats.LastError != "transaction aborted" {
+		t.Errorf("stats = %+v, want a failed run", stats)
+	}
+	// Messages recovered before the error are still counted
+	if got := testutil.ToFloat64(metrics.ReaperRecovered.WithLabelValues(string(RecoveryModeRequeue))) - recoveredBefore; got != 1 {
+		t.Errorf("reaper_recovered_total{mode=requeue} grew by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.ReaperFailedRuns) - failedBefore; got != 1 {
+		t.Errorf("reaper_failed_runs_total grew by %v, want 1", got)
+	}
+}
+
+func TestReaperShutdownWaitsForThePassInProgress(t *testing.T) {
+	useCase := &reaperUseCase{started: make(chan struct{}, 1), release: make(chan struct{})}
+	reaper := NewReaper(useCase, ReaperOptions{Interval: time.Millisecond})
+	reaper.Start()
+	<-useCase.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := reaper.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() during a pass = %v, want DeadlineExceeded", err)
+	}
+
+	close(useCase.release)
+	if err := reaper.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() after the pass = %v", err)
+	}
+	if reaper.Stats().IsRunning {
+		t.Error("reaper still reports running after Shutdown")
+	}
+}
@@ -2,11 +2,40 @@ package message
 
 import (
 	"context"
+	"time"
 )
 
 type Repository interface {
 	CreateMessage(ctx context.Context, message CreateMessage) (*CreatedMessageDbResponse, error)
-	GetOldestStatusNewMessages(ctx context.Context) ([]Message, error)
-	UpdateMessageStatus(ctx context.Context, messageID string, newStatus Status) error
-	GetSentStatusMessages(ctx context.Context) ([]Message, error)
+	CreateMessages(ctx context.Context, messages []CreateMessage) ([]CreateMessagesResult, error)
+	ClaimMessages(ctx context.Context, owner string, limit int, leaseDuration time.Duration) ([]Message, error)
+	ExpireMessages(ctx context.Context, timeNow time.Time) (int64, error)
+	TransitionMessageStatus(ctx context.Context, messageID string, from []Status, to Status) (*Message, error)
+	RescheduleMessage(ctx context.Context, messageID string, sendAt, expiresAt *time.Time) (*Message, error)
+	RecoverStuckMessages(ctx context.Context, stuckBefore time.Time, newStatus Status) ([]Message, error)
+	// RecoverStuckMessagesWithOutbox moves stuck messages to `Fail` together with the outbox entry newOutbox
+	// builds for each and clears their processed deliveries, one transaction per message.
+	RecoverStuckMessagesWithOutbox(ctx context.Context, stuckBefore time.Time, newOutbox func(Message) (OutboxMessage, error)) ([]Message, []OutboxMessage, error)
+	// RenewLease extends the lease of a claimed message. It returns ErrStatusConflict when the message is
+	// no longer in `Process` or is leased to another owner.
+	RenewLease(ctx context.Context, messageID, owner string, leaseDuration time.Duration) error
+	// TransitionClaimedMessage moves a claimed message out of `Process` while it is still leased to owner,
+	// ErrStatusConflict otherwise.
+	TransitionClaimedMessage(ctx context.Context, messageID, owner string, to Status) error
+	RecordDeliveryAttempt(ctx context.Context, messageID string, attempt DeliveryAttempt) error
+	GetMessageByID(ctx context.Context, messageID string) (*Message, error)
+	GetMessageByIdempotencyKey(ctx context.Context, idempotencyKey string) (*Message, error)
+	ListMessages(ctx context.Context, filter ListMessagesFilter) ([]Message, error)
+	CountMessagesByStatus(ctx context.Context) (map[Status]int64, error)
+	// FailMessageWithOutbox moves a `Process` message to `Fail` together with its retry outbox entry. When
+	// owner is not empty the message must still be leased to it.
+	FailMessageWithOutbox(ctx context.Context, messageID, owner string, outboxMsg OutboxMessage) (*OutboxMessage, error)
+	SaveOutboxMessage(ctx context.Context, outboxMsg OutboxMessage) (*OutboxMessage, error)
+	ClaimOutboxMessages(ctx context.Context, owner string, limit int, leaseDuration time.Duration) ([]OutboxMessage, error)
+	MarkOutboxMessageDelivered(ctx context.Context, outboxID string) error
+	RescheduleOutboxMessage(ctx context.Context, outboxID string, lastError string, nextAttemptAt time.Time) error
+	// ClaimRetryDelivery moves a `Fail` message back to `Process` for the given retry attempt, leased to owner
+	// like the messages claimed by the cron.
+	ClaimRetryDelivery(ctx context.Context, messageID, owner string, attempt int, leaseDuration time.Duration) (*Message, error)
+	ClearProcessedDeliveries(ctx context.Context, messageID string) error
 }
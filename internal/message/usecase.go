@@ -2,151 +2,882 @@ package message
 
 import (
 	"context"
-	"github.com/jiin-yang/messageBird/internal/client/webhook"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jiin-yang/messageBird/internal/client/provider"
 	"github.com/jiin-yang/messageBird/internal/infra/rabbitmq"
+	"github.com/jiin-yang/messageBird/internal/metrics"
+	"github.com/jiin-yang/messageBird/internal/ratelimit"
+	"github.com/jiin-yang/messageBird/internal/requestid"
+	"github.com/jiin-yang/messageBird/internal/tenant"
+	"github.com/jiin-yang/messageBird/internal/tracing"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 2
+	defaultWorkers       = 1
+	defaultLeaseDuration = 1 * time.Minute
+	defaultListPageSize  = 20
+	maxListPageSize      = 100
+
+	outboxLeaseDuration   = 1 * time.Minute
+	outboxMinRetryBackoff = 5 * time.Second
+	outboxMaxRetryBackoff = 5 * time.Minute
 )
 
 type UseCase interface {
-	CreateMessage(ctx context.Context, requestMsg CreateMessageRequest) (*CreateMessageResponse, error)
-	GetOldestStatusNewMessages(ctx context.Context) ([]Message, error)
+	CreateMessage(ctx context.Context, requestMsg CreateMessageRequest, idempotencyKey string) (*CreateMessageResponse, error)
+	CreateMessages(ctx context.Context, requestMsgs []CreateMessageRequest) ([]CreateMessageBatchResult, error)
 	SendMessages(ctx context.Context) error
-	GetSentStatusMessages(ctx context.Context) ([]GetMessageResponse, error)
+	ListMessages(ctx context.Context, request ListMessagesRequest) (*ListMessagesResponse, error)
+	GetMessage(ctx context.Context, messageID string) (*GetMessageDetailResponse, error)
+	RescheduleMessage(ctx context.Context, messageID string, request RescheduleMessageRequest) (*GetMessageResponse, error)
+	CancelMessage(ctx context.Context, messageID string) (*GetMessageResponse, error)
+	RecoverStuckMessages(ctx context.Context, threshold time.Duration, mode RecoveryMode) ([]Message, error)
+	RelayOutbox(ctx context.Context, limit int) (int, error)
 	StartConsumeFailures(ctx context.Context, maxRetries int)
 	StopConsumeFailures()
+	DrainConsumeFailures(ctx context.Context) error
+	IsConsumerRunning() bool
+	ListDeadLetters(ctx context.Context, request ListDeadLettersRequest) ([]DeadLetterResponse, error)
+	ReplayDeadLetters(ctx context.Context, request ReplayDeadLettersRequest) (*ReplayDeadLettersResponse, error)
+	PurgeDeadLetters(ctx context.Context, request PurgeDeadLettersRequest) (*PurgeDeadLettersResponse, error)
 }
 
 type useCase struct {
-	repo     Repository
-	webhook  webhook.Client
-	rabbitMQ rabbitmq.Client
+	repo       Repository
+	tenants    tenant.UseCase
+	limits     ratelimit.Limiter
+	rabbitMQ   rabbitmq.Client
+	instanceID string
+
+	batchSize     int
+	workers       int
+	leaseDuration time.Duration
+	inFlight      chan struct{}
 
 	mu                sync.Mutex
 	isConsumerRunning bool
 	consumerCancel    context.CancelFunc
+	consumerDone      chan struct{}
 }
 
 type NewUseCaseOptions struct {
-	Repo     Repository
-	Webhook  webhook.Client
-	RabbitMQ rabbitmq.Client
+	Repo Repository
+	// Tenants resolves the provider of each message and enforces the send quotas
+	Tenants tenant.UseCase
+	// Limits rejects creations over the rate limits and throttles the dispatcher
+	Limits     ratelimit.Limiter
+	RabbitMQ   rabbitmq.Client
+	InstanceID string
+
+	BatchSize     int
+	Workers       int
+	MaxInFlight   int
+	LeaseDuration time.Duration
 }
 
 func NewUseCase(opts *NewUseCaseOptions) UseCase {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	maxInFlight := opts.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = workers
+	}
+	leaseDuration := opts.LeaseDuration
+	if leaseDuration <= 0 {
+		leaseDuration = defaultLeaseDuration
+	}
+
 	return &useCase{
-		repo:     opts.Repo,
-		webhook:  opts.Webhook,
-		rabbitMQ: opts.RabbitMQ,
+		repo:          opts.Repo,
+		tenants:       opts.Tenants,
+		limits:        opts.Limits,
+		rabbitMQ:      opts.RabbitMQ,
+		instanceID:    opts.InstanceID,
+		batchSize:     batchSize,
+		workers:       workers,
+		leaseDuration: leaseDuration,
+		inFlight:      make(chan struct{}, maxInFlight),
 	}
 }
 
-func (u *useCase) CreateMessage(ctx context.Context, requestMsg CreateMessageRequest) (*CreateMessageResponse, error) {
+// CreateMessage stores the message for the tenant of the caller once it fits in the rate limits and the
+// tenant's send quota.
+func (u *useCase) CreateMessage(ctx context.Context, requestMsg CreateMessageRequest, idempotencyKey string) (*CreateMessageResponse, error) {
+	status, err := scheduleStatus(requestMsg.SendAt, requestMsg.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Callers not bound to a tenant create messages for the default tenant, the idempotency key lookup
+	// has to be scoped to it as well
+	tenantID := tenant.IDOrDefault(ctx)
+	ctx = tenant.NewContext(ctx, tenantID)
+
 	msg := CreateMessage{
+		TenantID:    tenantID,
 		PhoneNumber: requestMsg.PhoneNumber,
 		Content:     requestMsg.Content,
-		Status:      New,
+		Status:      status,
+		SendAt:      requestMsg.SendAt,
+		ExpiresAt:   requestMsg.ExpiresAt,
+		TraceParent: tracing.TraceParent(ctx),
+		RequestID:   requestid.FromContext(ctx),
+	}
+	if idempotencyKey != "" {
+		msg.IdempotencyKey = idempotencyKey
+		msg.RequestFingerprint, err = requestFingerprint(requestMsg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	releaseLimits, err := u.reserveLimits(ctx, tenantID, msg.PhoneNumber)
+	if err != nil {
+		// A retried request may only be over the limits because of the message it created the first time
+		if msg.IdempotencyKey != "" && (errors.Is(err, ratelimit.ErrRateLimited) || errors.Is(err, tenant.ErrQuotaExceeded)) {
+			resp, replayErr := u.replayCreateMessage(ctx, msg)
+			if !errors.Is(replayErr, ErrMessageNotFound) {
+				return resp, replayErr
+			}
+		}
+		return nil, err
 	}
 
 	dbRes, err := u.repo.CreateMessage(ctx, msg)
+	if errors.Is(err, ErrDuplicateIdempotencyKey) {
+		releaseLimits(ctx)
+		return u.replayCreateMessage(ctx, msg)
+	}
 	if err != nil {
+		releaseLimits(ctx)
 		return nil, err
 	}
 
+	metrics.MessagesCreated.WithLabelValues("single").Inc()
+
 	createdMsgRes := CreateMessageResponse{
 		Id:          dbRes.Id,
 		PhoneNumber: dbRes.PhoneNumber,
 		Content:     dbRes.Content,
 		Status:      dbRes.Status.String(),
+		SendAt:      dbRes.SendAt,
+		ExpiresAt:   dbRes.ExpiresAt,
 		CreatedAt:   dbRes.CreatedAt,
 	}
 
 	return &createdMsgRes, err
 }
 
-func (u *useCase) GetOldestStatusNewMessages(ctx context.Context) ([]Message, error) {
-	messages, err := u.repo.GetOldestStatusNewMessages(ctx)
+// reserveLimits reserves a single message in the rate limits and the send quota of the tenant.
+func (u *useCase) reserveLimits(ctx context.Context, tenantID, phoneNumber string) (func(ctx context.Context), error) {
+	if err := u.limits.AllowCreate(ctx, tenantID, 1); err != nil {
+		return nil, err
+	}
+
+	releaseRecipient, err := u.limits.ReserveRecipient(ctx, tenantID, phoneNumber, 1)
 	if err != nil {
 		return nil, err
 	}
-	return messages, err
-}
 
-func (u *useCase) SendMessages(ctx context.Context) error {
-	messages, err := u.GetOldestStatusNewMessages(ctx)
+	releaseQuota, err := u.tenants.ReserveQuota(ctx, tenantID, 1)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to fetch oldest messages with status 'new'")
-		return err
+		releaseRecipient(ctx, 1)
+		return nil, err
 	}
 
-	sendMsg := webhook.SendMessageRequest{}
-	for _, message := range messages {
-		sendMsg.To = message.PhoneNumber
-		sendMsg.Content = message.Content
+	return func(ctx context.Context) {
+		releaseRecipient(ctx, 1)
+		releaseQuota(ctx, 1)
+	}, nil
+}
 
-		err = u.repo.UpdateMessageStatus(ctx, message.Id, Process)
+// CreateMessageBatchResult is the outcome of one item of CreateMessages, in the same order as the request.
+type CreateMessageBatchResult struct {
+	Message *CreateMessageResponse
+	Err     error
+}
+
+// CreateMessages persists already validated requests with a single bulk insert. The whole batch is
+// rejected when it does not fit in the per second limits or the send quota, messages to a recipient
+// over its hourly limit fail on their own.
+func (u *useCase) CreateMessages(ctx context.Context, requestMsgs []CreateMessageRequest) ([]CreateMessageBatchResult, error) {
+	results := make([]CreateMessageBatchResult, len(requestMsgs))
+	tenantID := tenant.IDOrDefault(ctx)
+
+	var (
+		msgs        []CreateMessage
+		indexes     []int
+		traceParent = tracing.TraceParent(ctx)
+		requestID   = requestid.FromContext(ctx)
+	)
+	for i, requestMsg := range requestMsgs {
+		status, err := scheduleStatus(requestMsg.SendAt, requestMsg.ExpiresAt)
 		if err != nil {
-			log.Error().Err(err).Str("messageId", message.Id).Msg("Failed to update message status to 'Process'")
+			results[i].Err = err
 			continue
 		}
 
-		respWebhook, err := u.webhook.SendMessage(sendMsg)
-		if err != nil {
-			log.Error().Err(err).Str("messageId", message.Id).Msg("Failed to send message to webhook")
+		msgs = append(msgs, CreateMessage{
+			TenantID:    tenantID,
+			PhoneNumber: requestMsg.PhoneNumber,
+			Content:     requestMsg.Content,
+			Status:      status,
+			SendAt:      requestMsg.SendAt,
+			ExpiresAt:   requestMsg.ExpiresAt,
+			TraceParent: traceParent,
+			RequestID:   requestID,
+		})
+		indexes = append(indexes, i)
+	}
 
-			err = u.repo.UpdateMessageStatus(ctx, message.Id, Fail)
-			if err != nil {
-				log.Error().Err(err).Str("messageId", message.Id).Msg("Failed to update message status to 'Fail'")
-			}
+	if len(msgs) == 0 {
+		return results, nil
+	}
 
-			failedMsg := rabbitmq.FailedMessage{
-				MessageID:   message.Id,
-				PhoneNumber: message.PhoneNumber,
-				Content:     message.Content,
-				Status:      uint8(Fail),
-			}
-			pubErr := u.rabbitMQ.PublishFailMessage(ctx, failedMsg)
-			if pubErr != nil {
-				log.Error().Err(pubErr).
-					Str("messageId", message.Id).
-					Msg("Failed to publish fail message to RabbitMQ")
-			}
+	if err := u.limits.AllowCreate(ctx, tenantID, len(msgs)); err != nil {
+		return nil, err
+	}
+
+	msgs, indexes, releaseRecipients, err := u.reserveRecipients(ctx, tenantID, msgs, indexes, results)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return results, nil
+	}
+
+	releaseQuota, err := u.tenants.ReserveQuota(ctx, tenantID, len(msgs))
+	if err != nil {
+		releaseRecipients(ctx, msgs)
+		return nil, err
+	}
+
+	dbResults, err := u.repo.CreateMessages(ctx, msgs)
+	if err != nil {
+		releaseRecipients(ctx, msgs)
+		releaseQuota(ctx, len(msgs))
+		return nil, err
+	}
 
+	var failed []CreateMessage
+	for i, dbRes := range dbResults {
+		if dbRes.Err != nil {
+			results[indexes[i]].Err = dbRes.Err
+			failed = append(failed, msgs[i])
 			continue
 		}
+		metrics.MessagesCreated.WithLabelValues("batch").Inc()
 
-		log.Info().Msgf("Webhook response: %v %v", respWebhook.ResponseId, respWebhook.State)
+		results[indexes[i]].Message = &CreateMessageResponse{
+			Id:          dbRes.Message.Id,
+			PhoneNumber: dbRes.Message.PhoneNumber,
+			Content:     dbRes.Message.Content,
+			Status:      dbRes.Message.Status.String(),
+			SendAt:      dbRes.Message.SendAt,
+			ExpiresAt:   dbRes.Message.ExpiresAt,
+			CreatedAt:   dbRes.Message.CreatedAt,
+		}
+	}
+	releaseRecipients(ctx, failed)
+	releaseQuota(ctx, len(failed))
+
+	return results, nil
+}
+
+// reserveRecipients reserves the hourly limit of every recipient of the batch. Messages to a recipient
+// over its limit get the limit error as their result and are left out of the returned messages. The
+// returned func gives back the reservations of messages which were not created after all.
+func (u *useCase) reserveRecipients(ctx context.Context, tenantID string, msgs []CreateMessage, indexes []int,
+	results []CreateMessageBatchResult) ([]CreateMessage, []int, func(ctx context.Context, msgs []CreateMessage), error) {
+	counts := make(map[string]int)
+	for _, msg := range msgs {
+		counts[msg.PhoneNumber]++
+	}
+
+	releases := make(map[string]func(ctx context.Context, count int), len(counts))
+	release := func(ctx context.Context, msgs []CreateMessage) {
+		released := make(map[string]int)
+		for _, msg := range msgs {
+			released[msg.PhoneNumber]++
+		}
+		for phoneNumber, count := range released {
+			if releaseRecipient, ok := releases[phoneNumber]; ok {
+				releaseRecipient(ctx, count)
+			}
+		}
+	}
 
-		err = u.repo.UpdateMessageStatus(ctx, message.Id, Sent)
+	rejected := make(map[string]error)
+	for phoneNumber, count := range counts {
+		releaseRecipient, err := u.limits.ReserveRecipient(ctx, tenantID, phoneNumber, count)
+		if errors.Is(err, ratelimit.ErrRateLimited) {
+			rejected[phoneNumber] = err
+			continue
+		}
 		if err != nil {
-			// Message gonderildi fakat statu process->sent islemi yapilamadi. Bu durum simdilik Allah'a emanet
-			// Message'i webhook.siteye gonderdigim icin kuyruga da atamiyorum tekrardan
-			log.Error().Err(err).Str("messageId", message.Id).Msg("Failed to update message status to 'Sent'")
+			release(ctx, msgs)
+			return nil, nil, nil, err
+		}
+		releases[phoneNumber] = releaseRecipient
+	}
+
+	if len(rejected) == 0 {
+		return msgs, indexes, release, nil
+	}
+
+	var (
+		keptMsgs    []CreateMessage
+		keptIndexes []int
+	)
+	for i, msg := range msgs {
+		if err, ok := rejected[msg.PhoneNumber]; ok {
+			results[indexes[i]].Err = err
 			continue
 		}
+		keptMsgs = append(keptMsgs, msg)
+		keptIndexes = append(keptIndexes, indexes[i])
+	}
+	return keptMsgs, keptIndexes, release, nil
+}
+
+// replayCreateMessage returns the message which was created earlier with the same idempotency key,
+// as long as it was created from the same request body.
+func (u *useCase) replayCreateMessage(ctx context.Context, msg CreateMessage) (*CreateMessageResponse, error) {
+	existing, err := u.repo.GetMessageByIdempotencyKey(ctx, msg.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing.RequestFingerprint != msg.RequestFingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+
+	log.Ctx(ctx).Info().
+		Str("messageId", existing.Id).
+		Str("idempotencyKey", msg.IdempotencyKey).
+		Msg("Replaying message creation for idempotency key")
+
+	return &CreateMessageResponse{
+		Id:          existing.Id,
+		PhoneNumber: existing.PhoneNumber,
+		Content:     existing.Content,
+		Status:      existing.Status.String(),
+		SendAt:      existing.SendAt,
+		ExpiresAt:   existing.ExpiresAt,
+		CreatedAt:   existing.CreatedAt,
+	}, nil
+}
+
+func requestFingerprint(requestMsg CreateMessageRequest) (string, error) {
+	body, err := json.Marshal(requestMsg)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// scheduleStatus returns `Scheduled` for a message whose sendAt is in the future, `New` otherwise.
+func scheduleStatus(sendAt, expiresAt *time.Time) (Status, error) {
+	timeNow := time.Now()
+
+	if expiresAt != nil {
+		if !expiresAt.After(timeNow) || (sendAt != nil && !expiresAt.After(*sendAt)) {
+			return 0, ErrInvalidSchedule
+		}
+	}
+
+	if sendAt != nil && sendAt.After(timeNow) {
+		return Scheduled, nil
+	}
+	return New, nil
+}
+
+func (u *useCase) RescheduleMessage(ctx context.Context, messageID string, request RescheduleMessageRequest) (*GetMessageResponse, error) {
+	if _, err := scheduleStatus(request.SendAt, request.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	msg, err := u.repo.RescheduleMessage(ctx, messageID, request.SendAt, request.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := toGetMessageResponse(*msg)
+	return &resp, nil
+}
+
+// CancelMessage stops a message which has not been delivered yet. Messages in `Process` or already
+// delivered return ErrStatusConflict.
+func (u *useCase) CancelMessage(ctx context.Context, messageID string) (*GetMessageResponse, error) {
+	msg, err := u.repo.TransitionMessageStatus(ctx, messageID, []Status{New, Scheduled, Fail}, Cancelled)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := toGetMessageResponse(*msg)
+	return &resp, nil
+}
+
+func (u *useCase) SendMessages(ctx context.Context) error {
+	start := time.Now()
+	defer func() { metrics.CronBatchDuration.Observe(metrics.Since(start)) }()
+
+	ctx, span := tracing.Tracer().Start(ctx, "message.SendMessages")
+	defer span.End()
+
+	expired, err := u.repo.ExpireMessages(ctx, time.Now())
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Failed to expire messages")
+	} else if expired > 0 {
+		log.Ctx(ctx).Info().Int64("expired", expired).Msg("Expired messages that passed their expiresAt")
+	}
+
+	// Mesajlar tek adimda 'Process' statusune alinir, boylece birden fazla instance ayni mesaji gonderemez
+	messages, err := u.repo.ClaimMessages(ctx, u.instanceID, u.batchSize, u.leaseDuration)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Int("claimed", len(messages)).Msg("Failed to claim messages with status 'new'")
+		if len(messages) == 0 {
+			tracing.RecordError(span, err)
+			return err
+		}
+	}
+	metrics.CronBatchSize.Observe(float64(len(messages)))
+	span.SetAttributes(attribute.Int("messagebird.batch.size", len(messages)))
+
+	workers := u.workers
+	if len(messages) < workers {
+		workers = len(messages)
+	}
+
+	// Messages the provider rate limit cannot fit in well before their lease ends are deferred: they go
+	// back to 'New' for the next run instead of failing.
+	dispatchDeadline := time.Now().Add(u.leaseDuration / 2)
+
+	// Cancelling ctx stops the batch: sends already started are finished, claimed messages that were
+	// not sent yet are released back to 'New' for the next run or another instance.
+	sendCtx := context.WithoutCancel(ctx)
+
+	jobs := make(chan Message)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for message := range jobs {
+				if ctx.Err() != nil {
+					u.releaseClaim(sendCtx, message, "shutdown")
+					continue
+				}
+				if !u.limits.WaitDispatch(ctx, dispatchDeadline) {
+					reason := "provider rate limit"
+					if ctx.Err() != nil {
+						reason = "shutdown"
+					}
+					u.releaseClaim(sendCtx, message, reason)
+					continue
+				}
+				u.sendMessage(sendCtx, message)
+			}
+		}()
+	}
+
+	for _, message := range messages {
+		jobs <- message
 	}
+	close(jobs)
+	wg.Wait()
+
 	return nil
 }
 
-func (u *useCase) GetSentStatusMessages(ctx context.Context) ([]GetMessageResponse, error) {
-	messages, err := u.repo.GetSentStatusMessages(ctx)
+// releaseClaim puts a claimed message which was not sent back to 'New' for the next run.
+func (u *useCase) releaseClaim(ctx context.Context, message Message, reason string) {
+	err := u.repo.TransitionClaimedMessage(ctx, message.Id, u.instanceID, New)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("messageId", message.Id).Msg("Failed to release claimed message")
+		return
+	}
+	log.Ctx(ctx).Info().Str("messageId", message.Id).Str("reason", reason).Msg("Released claimed message for the next run")
+}
+
+// sendMessage runs in the cron batch trace, the span is linked to the request that created the message.
+func (u *useCase) sendMessage(ctx context.Context, message Message) {
+	spanOpts := append(tracing.LinkTo(message.TraceParent),
+		trace.WithAttributes(attribute.String("messagebird.message.id", message.Id)))
+	ctx, span := tracing.Tracer().Start(ctx, "message.dispatch", spanOpts...)
+	defer span.End()
+
+	sendMsg := provider.SendRequest{
+		To:      message.PhoneNumber,
+		Content: message.Content,
+	}
+
+	// The claim may have waited for the provider limit and an in-flight slot, the lease is renewed right
+	// before the request so it covers the whole provider timeout. A lost lease means another instance
+	// owns the message now and it must not be sent from here.
+	renewLease := func(ctx context.Context) error {
+		return u.repo.RenewLease(ctx, message.Id, u.instanceID, u.leaseDuration)
+	}
+
+	result, providerName, err := u.sendToProvider(ctx, message.TenantID, sendMsg, renewLease)
+	if errors.Is(err, errLeaseLost) {
+		log.Ctx(ctx).Warn().Err(err).Str("messageId", message.Id).Msg("Lost the lease of the message, not sending it")
+		return
+	}
+	u.recordAttempt(ctx, message.Id, 0, providerName, result, err)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("messageId", message.Id).Msg("Failed to send message to provider")
+		tracing.RecordError(span, err)
+
+		// Kalici hatalar (orn. gecersiz numara icin 4xx) tekrar denense de basarili olmayacagi icin kuyruga atilmaz
+		if provider.IsPermanent(err) {
+			metrics.MessagesDead.WithLabelValues(rabbitmq.DeadReasonPermanentFailure).Inc()
+			err = u.repo.TransitionClaimedMessage(ctx, message.Id, u.instanceID, Dead)
+			if errors.Is(err, ErrStatusConflict) {
+				log.Ctx(ctx).Warn().Str("messageId", message.Id).Msg("Lost the lease of the message while sending, status not updated")
+			} else if err != nil {
+				log.Ctx(ctx).Error().Err(err).Str("messageId", message.Id).Msg("Failed to update message status to 'Dead'")
+			}
+			return
+		}
+
+		retryAfter := provider.RetryAfter(err)
+
+		failedMsg := rabbitmq.FailedMessage{
+			MessageID:         message.Id,
+			PhoneNumber:       message.PhoneNumber,
+			Content:           message.Content,
+			Status:            uint8(Fail),
+			RetryAfterSeconds: int(retryAfter.Round(time.Second) / time.Second),
+			TraceParent:       message.TraceParent,
+		}
+		failedMsg.RecordFailure(0, err)
+
+		// 'Fail' statusu ve retry'i planlayan outbox kaydi ayni transaction'da yazilir, relay kaydi RabbitMQ'ya tasir
+		outboxMsg, err := u.newOutboxMessage(failedMsg)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("messageId", message.Id).Msg("Failed to build outbox message")
+			return
+		}
+		saved, err := u.repo.FailMessageWithOutbox(ctx, message.Id, u.instanceID, outboxMsg)
+		if errors.Is(err, ErrStatusConflict) {
+			log.Ctx(ctx).Info().Str("messageId", message.Id).Msg("Message left 'Process' or was leased to another instance in the meantime, retry not scheduled")
+			return
+		}
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("messageId", message.Id).Msg("Failed to update message status to 'Fail'")
+			return
+		}
+
+		metrics.MessagesFailed.WithLabelValues("dispatch").Inc()
+		u.relayOutboxMessage(ctx, *saved)
+
+		return
+	}
+
+	log.Ctx(ctx).Info().Msgf("Provider response: %v %v %v", result.Provider, result.ProviderMessageID, result.State)
+	metrics.MessagesSent.WithLabelValues("dispatch").Inc()
+
+	err = u.repo.TransitionClaimedMessage(ctx, message.Id, u.instanceID, Sent)
+	if errors.Is(err, ErrStatusConflict) {
+		log.Ctx(ctx).Warn().Str("messageId", message.Id).Msg("Lost the lease of the message while sending, status not updated")
+	} else if err != nil {
+		// Message gonderildi fakat statu process->sent islemi yapilamadi. Bu durum simdilik Allah'a emanet
+		// Message'i webhook.siteye gonderdigim icin kuyruga da atamiyorum tekrardan
+		log.Ctx(ctx).Error().Err(err).Str("messageId", message.Id).Msg("Failed to update message status to 'Sent'")
+	}
+}
+
+// sendToProvider sends through the provider of the message's tenant. It waits for a free in-flight slot
+// before calling the provider, so the cron workers and the retry consumer together never exceed the
+// configured max in-flight requests. renewLease, when given, runs once the slot is taken; its error is
+// returned as errLeaseLost without calling the provider.
+func (u *useCase) sendToProvider(ctx context.Context, tenantID string, sendMsg provider.SendRequest,
+	renewLease func(ctx context.Context) error) (*provider.SendResult, string, error) {
+	p, err := u.tenants.Provider(ctx, tenantID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	select {
+	case u.inFlight <- struct{}{}:
+	case <-ctx.Done():
+		return nil, p.Name(), ctx.Err()
+	}
+	defer func() { <-u.inFlight }()
+
+	if renewLease != nil {
+		if err := renewLease(ctx); err != nil {
+			return nil, p.Name(), fmt.Errorf("%w: %v", errLeaseLost, err)
+		}
+	}
+
+	start := time.Now()
+	result, err := p.Send(ctx, sendMsg)
+	metrics.ProviderRequestDuration.WithLabelValues(p.Name(), metrics.Result(err)).Observe(metrics.Since(start))
+	return result, p.Name(), err
+}
+
+// recordAttempt stores the outcome of a provider call on the message. Attempt 0 is the first send by the
+// cron, every retry coming from the fail queue increases it by one.
+func (u *useCase) recordAttempt(ctx context.Context, messageID string, attempt int, providerName string, result *provider.SendResult, sendErr error) {
+	timeNow := time.Now()
+	deliveryAttempt := DeliveryAttempt{
+		Attempt:     attempt,
+		Provider:    providerName,
+		AttemptedAt: &timeNow,
+	}
+
+	if result != nil {
+		deliveryAttempt.ResponseId = result.ProviderMessageID
+		deliveryAttempt.HTTPStatus = result.StatusCode
+	}
+	if sendErr != nil {
+		deliveryAttempt.Error = sendErr.Error()
+		deliveryAttempt.HTTPStatus = provider.StatusCode(sendErr)
+	}
+
+	if err := u.repo.RecordDeliveryAttempt(ctx, messageID, deliveryAttempt); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("messageId", messageID).Int("attempt", attempt).Msg("Failed to record delivery attempt")
+	}
+}
+
+func (u *useCase) GetMessage(ctx context.Context, messageID string) (*GetMessageDetailResponse, error) {
+	msg, err := u.repo.GetMessageByID(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]StatusTransitionResponse, 0, len(msg.History))
+	for _, t := range msg.History {
+		transition := StatusTransitionResponse{
+			To: t.To.String(),
+			At: t.At,
+		}
+		if t.From != 0 {
+			transition.From = t.From.String()
+		}
+		history = append(history, transition)
+	}
+
+	attempts := make([]DeliveryAttemptResponse, 0, len(msg.Attempts))
+	for _, a := range msg.Attempts {
+		attempts = append(attempts, DeliveryAttemptResponse{
+			Attempt:     a.Attempt,
+			Provider:    a.Provider,
+			ResponseId:  a.ResponseId,
+			HTTPStatus:  a.HTTPStatus,
+			Error:       a.Error,
+			AttemptedAt: a.AttemptedAt,
+		})
+	}
+
+	return &GetMessageDetailResponse{
+		Id:          msg.Id,
+		PhoneNumber: msg.PhoneNumber,
+		Content:     msg.Content,
+		Status:      msg.Status.String(),
+		RetryCount:  msg.RetryCount,
+		History:     history,
+		Attempts:    attempts,
+		SendAt:      msg.SendAt,
+		ExpiresAt:   msg.ExpiresAt,
+		CreatedAt:   msg.CreatedAt,
+		UpdatedAt:   msg.UpdatedAt,
+		RequestID:   msg.RequestID,
+		TenantID:    msg.TenantID,
+	}, nil
+}
+
+func (u *useCase) ListMessages(ctx context.Context, request ListMessagesRequest) (*ListMessagesResponse, error) {
+	limit := request.Limit
+	if limit <= 0 {
+		limit = defaultListPageSize
+	}
+	if limit > maxListPageSize {
+		limit = maxListPageSize
+	}
+
+	filter := ListMessagesFilter{
+		PhoneNumber: request.PhoneNumber,
+		CreatedFrom: request.CreatedFrom,
+		CreatedTo:   request.CreatedTo,
+		UpdatedFrom: request.UpdatedFrom,
+		UpdatedTo:   request.UpdatedTo,
+		Search:      request.Search,
+		AfterID:     request.Cursor,
+		// Bir sonraki sayfanin olup olmadigini anlamak icin bir fazla kayit istenir
+		Limit:      limit + 1,
+		Descending: request.Sort == "desc",
+	}
+	if request.Status != "" {
+		status, ok := ParseStatus(request.Status)
+		if !ok {
+			return nil, fmt.Errorf("unknown status: %s", request.Status)
+		}
+		filter.Status = status
+	}
+
+	messages, err := u.repo.ListMessages(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
-	respMessages := []GetMessageResponse{}
-	var respMsg GetMessageResponse
+
+	resp := ListMessagesResponse{Data: []GetMessageResponse{}}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		resp.NextCursor = messages[limit-1].Id
+	}
+
 	for _, msg := range messages {
-		respMsg.Id = msg.Id
-		respMsg.Content = msg.Content
-		respMsg.Status = msg.Status.String()
-		respMsg.PhoneNumber = msg.PhoneNumber
-		respMsg.CreatedAt = msg.CreatedAt
-		respMsg.UpdatedAt = msg.UpdatedAt
+		resp.Data = append(resp.Data, toGetMessageResponse(msg))
+	}
+
+	return &resp, nil
+}
+
+func toGetMessageResponse(msg Message) GetMessageResponse {
+	return GetMessageResponse{
+		Id:          msg.Id,
+		PhoneNumber: msg.PhoneNumber,
+		Content:     msg.Content,
+		Status:      msg.Status.String(),
+		SendAt:      msg.SendAt,
+		ExpiresAt:   msg.ExpiresAt,
+		CreatedAt:   msg.CreatedAt,
+		UpdatedAt:   msg.UpdatedAt,
+	}
+}
+
+func (u *useCase) RecoverStuckMessages(ctx context.Context, threshold time.Duration, mode RecoveryMode) ([]Message, error) {
+	stuckBefore := time.Now().Add(-threshold)
+
+	if mode != RecoveryModeRetry {
+		messages, err := u.repo.RecoverStuckMessages(ctx, stuckBefore, New)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Int("recovered", len(messages)).Msg("Failed to recover stuck messages")
+			if len(messages) == 0 {
+				return nil, err
+			}
+		}
+		return messages, nil
+	}
+
+	// Status degisikligi ve outbox kaydi ayni transaction'da yazilir; retry ilk denemeden baslar
+	newOutbox := func(message Message) (OutboxMessage, error) {
+		return u.newOutboxMessage(rabbitmq.FailedMessage{
+			MessageID:   message.Id,
+			PhoneNumber: message.PhoneNumber,
+			Content:     message.Content,
+			Status:      uint8(Fail),
+			TraceParent: message.TraceParent,
+		})
+	}
+
+	messages, outboxMessages, err := u.repo.RecoverStuckMessagesWithOutbox(ctx, stuckBefore, newOutbox)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Int("recovered", len(messages)).Msg("Failed to recover stuck messages")
+		if len(messages) == 0 {
+			return nil, err
+		}
+	}
+
+	for _, outboxMsg := range outboxMessages {
+		u.relayOutboxMessage(ctx, outboxMsg)
+	}
+
+	return messages, nil
+}
+
+// newOutboxMessage wraps a queue message for the outbox, leased to this instance so the relay leaves it
+// alone while we publish it right away.
+func (u *useCase) newOutboxMessage(failedMsg rabbitmq.FailedMessage) (OutboxMessage, error) {
+	payload, err := json.Marshal(failedMsg)
+	if err != nil {
+		return OutboxMessage{}, err
+	}
+
+	leaseExpiresAt := time.Now().Add(outboxLeaseDuration)
+	return OutboxMessage{
+		MessageID:      failedMsg.MessageID,
+		Payload:        payload,
+		LeaseOwner:     u.instanceID,
+		LeaseExpiresAt: &leaseExpiresAt,
+	}, nil
+}
+
+// relayOutboxMessage publishes a single outbox entry and marks it delivered, or reschedules it with an
+// exponential backoff when RabbitMQ does not confirm the publish.
+func (u *useCase) relayOutboxMessage(ctx context.Context, outboxMsg OutboxMessage) bool {
+	var failedMsg rabbitmq.FailedMessage
+	pubErr := json.Unmarshal(outboxMsg.Payload, &failedMsg)
+	if pubErr == nil {
+		pubErr = u.rabbitMQ.PublishFailMessage(ctx, failedMsg)
+	}
+	if pubErr != nil {
+		log.Ctx(ctx).Error().Err(pubErr).
+			Str("outboxId", outboxMsg.Id).
+			Str("messageId", outboxMsg.MessageID).
+			Msg("Failed to publish outbox message to RabbitMQ")
 
-		respMessages = append(respMessages, respMsg)
+		nextAttemptAt := time.Now().Add(outboxBackoff(outboxMsg.PublishAttempts + 1))
+		if err := u.repo.RescheduleOutboxMessage(ctx, outboxMsg.Id, pubErr.Error(), nextAttemptAt); err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("outboxId", outboxMsg.Id).Msg("Failed to reschedule outbox message")
+		}
+		return false
+	}
+
+	// Isaretleme basarisiz olursa mesaj bir kez daha yayinlanir, consumer messageId+attempt ile bunu eler
+	if err := u.repo.MarkOutboxMessageDelivered(ctx, outboxMsg.Id); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("outboxId", outboxMsg.Id).Msg("Failed to mark outbox message delivered")
+	}
+	return true
+}
+
+// RelayOutbox publishes up to limit due outbox entries and returns how many were published.
+func (u *useCase) RelayOutbox(ctx context.Context, limit int) (int, error) {
+	outboxMsgs, err := u.repo.ClaimOutboxMessages(ctx, u.instanceID, limit, outboxLeaseDuration)
+	if err != nil && len(outboxMsgs) == 0 {
+		return 0, err
 	}
 
-	return respMessages, nil
+	published := 0
+	for _, outboxMsg := range outboxMsgs {
+		if u.relayOutboxMessage(ctx, outboxMsg) {
+			published++
+		}
+	}
+
+	return published, err
+}
+
+func outboxBackoff(publishAttempts int) time.Duration {
+	backoff := outboxMinRetryBackoff
+	for i := 1; i < publishAttempts && backoff < outboxMaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxMaxRetryBackoff {
+		backoff = outboxMaxRetryBackoff
+	}
+	return backoff
 }
 
 func (u *useCase) StartConsumeFailures(ctx context.Context, maxRetries int) {
@@ -163,39 +894,97 @@ func (u *useCase) StartConsumeFailures(ctx context.Context, maxRetries int) {
 
 	consumerCtx, cancel := context.WithCancel(ctx)
 	u.consumerCancel = cancel
+	done := make(chan struct{})
+	u.consumerDone = done
 
 	go func() {
 		defer func() {
 			u.mu.Lock()
 			u.isConsumerRunning = false
 			u.mu.Unlock()
+			close(done)
 			log.Info().Msg("RabbitMQ consumer has stopped")
 		}()
 
-		retryTask := func(msg rabbitmq.FailedMessage) error {
-			log.Info().
+		retryTask := func(taskCtx context.Context, msg rabbitmq.FailedMessage) error {
+			log.Ctx(taskCtx).Info().
 				Str("messageId", msg.MessageID).
 				Int("attempt", msg.Attempt).
 				Msg("Retrying failed message")
 
-			req := webhook.SendMessageRequest{
+			// Provider rate limit'i retry'i beklemeye alir, basarisiz deneme sayilmaz. Delivery bu sirada ack'lenmez.
+			// taskCtx consumer durdurulunca iptal olmaz; bekleme consumerCtx ile kesilir ve delivery kuyruga geri doner
+			if !u.limits.WaitDispatch(consumerCtx, time.Time{}) {
+				return rabbitmq.ErrRequeueMessage
+			}
+
+			// Mesaj iptal edildiyse veya suresi dolduysa 'Fail' statusunde olmaz, bu durumda tekrar gonderilmez.
+			// Ayni messageId+attempt ikinci kez geldiyse (redelivery, outbox relay tekrari) yine gonderilmez.
+			claimed, err := u.repo.ClaimRetryDelivery(taskCtx, msg.MessageID, u.instanceID, msg.Attempt, u.leaseDuration)
+			if err != nil {
+				if errors.Is(err, ErrStatusConflict) || errors.Is(err, ErrMessageNotFound) {
+					log.Ctx(taskCtx).Info().Str("messageId", msg.MessageID).Msg("Message is no longer retryable, skipping")
+					return rabbitmq.ErrSkipMessage
+				}
+				if errors.Is(err, ErrDuplicateDelivery) {
+					log.Ctx(taskCtx).Info().Str("messageId", msg.MessageID).Int("attempt", msg.Attempt).Msg("Duplicate delivery, skipping")
+					return rabbitmq.ErrSkipMessage
+				}
+				return err
+			}
+
+			req := provider.SendRequest{
 				To:      msg.PhoneNumber,
 				Content: msg.Content,
 			}
-			resp, err := u.webhook.SendMessage(req)
+			// Cron'daki gibi lease istek oncesi yenilenir; reaper'in devraldigi mesaj buradan gonderilmez
+			renewLease := func(ctx context.Context) error {
+				return u.repo.RenewLease(ctx, msg.MessageID, u.instanceID, u.leaseDuration)
+			}
+			result, providerName, err := u.sendToProvider(taskCtx, claimed.TenantID, req, renewLease)
+			if errors.Is(err, errLeaseLost) {
+				log.Ctx(taskCtx).Warn().Err(err).Str("messageId", msg.MessageID).Msg("Lost the lease of the message, not retrying it")
+				return rabbitmq.ErrSkipMessage
+			}
+			u.recordAttempt(taskCtx, msg.MessageID, msg.Attempt+1, providerName, result, err)
 			if err != nil {
-				return err
+				return &rabbitmq.RetryError{
+					Permanent:  provider.IsPermanent(err),
+					RetryAfter: provider.RetryAfter(err),
+					Err:        err,
+				}
 			}
 
-			log.Info().Msgf("Retry webhook response: %v %v", resp.ResponseId, resp.State)
+			log.Ctx(taskCtx).Info().Msgf("Retry provider response: %v %v %v", result.Provider, result.ProviderMessageID, result.State)
 			return nil
 		}
 
+		// Status sadece mesaj hala retry akisindaysa guncellenir, iptal edilen bir mesaj 'Dead' veya 'Sent' olmaz
 		updateStatus := func(messageID string, status uint8) error {
-			return u.repo.UpdateMessageStatus(ctx, messageID, Status(status))
+			_, err := u.repo.TransitionMessageStatus(ctx, messageID, []Status{Fail, Process}, Status(status))
+			if errors.Is(err, ErrStatusConflict) {
+				log.Info().Str("messageId", messageID).Msg("Message left the retry flow, status not updated")
+				return nil
+			}
+			return err
+		}
+
+		// RabbitMQ delay queue'ya yazilamayan deneme outbox'a kaydedilir, relay delay dolunca yayinlar
+		scheduleRetry := func(taskCtx context.Context, msg rabbitmq.FailedMessage, delay time.Duration) error {
+			outboxMsg, err := u.newOutboxMessage(msg)
+			if err != nil {
+				return err
+			}
+			nextAttemptAt := time.Now().Add(delay)
+			outboxMsg.NextAttemptAt = &nextAttemptAt
+			outboxMsg.LeaseOwner = ""
+			outboxMsg.LeaseExpiresAt = nil
+
+			_, err = u.repo.SaveOutboxMessage(taskCtx, outboxMsg)
+			return err
 		}
 
-		err := u.rabbitMQ.ConsumeFailures(consumerCtx, retryTask, updateStatus, maxRetries)
+		err := u.rabbitMQ.ConsumeFailures(consumerCtx, retryTask, updateStatus, scheduleRetry, maxRetries)
 		if err != nil {
 			log.Error().Err(err).Msg("RabbitMQ consumer encountered an error")
 		}
@@ -215,3 +1004,122 @@ func (u *useCase) StopConsumeFailures() {
 	u.consumerCancel()
 	u.isConsumerRunning = false
 }
+
+func (u *useCase) ListDeadLetters(ctx context.Context, request ListDeadLettersRequest) ([]DeadLetterResponse, error) {
+	limit := request.Limit
+	if limit <= 0 {
+		limit = defaultListPageSize
+	}
+	if limit > maxListPageSize {
+		limit = maxListPageSize
+	}
+
+	deadLetters, err := u.rabbitMQ.ListDeadLetters(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]DeadLetterResponse, 0, len(deadLetters))
+	for _, dl := range deadLetters {
+		resp = append(resp, toDeadLetterResponse(dl))
+	}
+	return resp, nil
+}
+
+func (u *useCase) ReplayDeadLetters(ctx context.Context, request ReplayDeadLettersRequest) (*ReplayDeadLettersResponse, error) {
+	// Mesaj 'Fail' statusune alinmazsa consumer onu tekrar gonderemez
+	prepare := func(dl rabbitmq.DeadLetter) error {
+		// Attempt sayaci sifirlandigi icin onceki denemelerin dedupe kayitlari silinir
+		err := u.repo.ClearProcessedDeliveries(ctx, dl.MessageID)
+		if err != nil {
+			return err
+		}
+
+		_, err = u.repo.TransitionMessageStatus(ctx, dl.MessageID, []Status{Dead}, Fail)
+		if errors.Is(err, ErrStatusConflict) || errors.Is(err, ErrMessageNotFound) || errors.Is(err, ErrInvalidMessageID) {
+			return rabbitmq.ErrSkipMessage
+		}
+		return err
+	}
+
+	// Yayin onaylanmazsa mesaj tekrar 'Dead' olur, dead letter kuyrukta kalir. Silinen dedupe kayitlari
+	// sadece 'Fail' mesajlar icin anlamli oldugundan geri yazilmaz
+	restore := func(dl rabbitmq.DeadLetter) error {
+		_, err := u.repo.TransitionMessageStatus(ctx, dl.MessageID, []Status{Fail}, Dead)
+		return err
+	}
+
+	replayed, err := u.rabbitMQ.ReplayDeadLetters(ctx, request.MessageIDs, prepare, restore)
+	if err != nil && len(replayed) == 0 {
+		return nil, err
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Int("replayed", len(replayed)).Msg("Dead letter replay stopped early")
+	}
+
+	if replayed == nil {
+		replayed = []string{}
+	}
+	return &ReplayDeadLettersResponse{Replayed: replayed}, nil
+}
+
+func (u *useCase) PurgeDeadLetters(ctx context.Context, request PurgeDeadLettersRequest) (*PurgeDeadLettersResponse, error) {
+	purged, err := u.rabbitMQ.PurgeDeadLetters(ctx, request.MessageIDs)
+	if err != nil {
+		return nil, err
+	}
+	return &PurgeDeadLettersResponse{Purged: purged}, nil
+}
+
+func toDeadLetterResponse(dl rabbitmq.DeadLetter) DeadLetterResponse {
+	resp := DeadLetterResponse{
+		MessageID:   dl.MessageID,
+		PhoneNumber: dl.PhoneNumber,
+		Content:     dl.Content,
+		Attempt:     dl.Attempt,
+		Reason:      dl.Reason,
+		LastError:   dl.LastError,
+		Failures:    make([]DeadLetterFailureResponse, 0, len(dl.Failures)),
+		DeadAt:      dl.DeadAt,
+		RawBody:     dl.RawBody,
+	}
+	for _, f := range dl.Failures {
+		resp.Failures = append(resp.Failures, DeadLetterFailureResponse{
+			Attempt:  f.Attempt,
+			Error:    f.Error,
+			FailedAt: f.FailedAt,
+		})
+	}
+	return resp
+}
+
+// DrainConsumeFailures stops the consumer and waits until the deliveries being retried are finished and the
+// prefetched ones are requeued, or until ctx expires.
+func (u *useCase) DrainConsumeFailures(ctx context.Context) error {
+	u.mu.Lock()
+	done := u.consumerDone
+	if u.isConsumerRunning {
+		log.Ctx(ctx).Info().Msg("Draining RabbitMQ consumer")
+		u.consumerCancel()
+		u.isConsumerRunning = false
+	}
+	u.mu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (u *useCase) IsConsumerRunning() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.isConsumerRunning
+}
@@ -0,0 +1,806 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jiin-yang/messageBird/internal/client/provider"
+	"github.com/jiin-yang/messageBird/internal/infra/rabbitmq"
+	"github.com/jiin-yang/messageBird/internal/tenant"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryRepo keeps messages in memory and applies the status rules of the MongoDB repository.
+type memoryRepo struct {
+	Repository
+
+	mu          sync.Mutex
+	nextID      int
+	messages    map[string]*Message
+	order       []string
+	idempotency map[string]string
+	processed   map[string]bool
+	leases      map[string]string
+}
+
+func newMemoryRepo() *memoryRepo {
+	return &memoryRepo{
+		messages:    map[string]*Message{},
+		idempotency: map[string]string{},
+		processed:   map[string]bool{},
+		leases:      map[string]string{},
+	}
+}
+
+// add stores a message in the given status, bypassing the use case.
+func (r *memoryRepo) add(msg Message) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	msg.Id = fmt.Sprintf("%024x", r.nextID)
+	if msg.CreatedAt == nil {
+		timeNow := time.Now()
+		msg.CreatedAt = &timeNow
+	}
+	r.messages[msg.Id] = &msg
+	r.order = append(r.order, msg.Id)
+	return msg.Id
+}
+
+func (r *memoryRepo) status(t *testing.T, messageID string) Status {
+	t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msg, ok := r.messages[messageID]
+	if !ok {
+		t.Fatalf("message %s does not exist", messageID)
+	}
+	return msg.Status
+}
+
+func (r *memoryRepo) transition(msg *Message, to Status) {
+	timeNow := time.Now()
+	msg.History = append(msg.History, StatusTransition{From: msg.Status, To: to, At: &timeNow})
+	msg.Status = to
+	msg.UpdatedAt = &timeNow
+}
+
+func (r *memoryRepo) CreateMessage(_ context.Context, data CreateMessage) (*CreatedMessageDbResponse, error) {
+	idempotencyKey := data.TenantID + "|" + data.IdempotencyKey
+	r.mu.Lock()
+	_, duplicate := r.idempotency[idempotencyKey]
+	r.mu.Unlock()
+	if data.IdempotencyKey != "" && duplicate {
+		return nil, ErrDuplicateIdempotencyKey
+	}
+
+	id := r.add(Message{
+		TenantID:           data.TenantID,
+		PhoneNumber:        data.PhoneNumber,
+		Content:            data.Content,
+		Status:             data.Status,
+		SendAt:             data.SendAt,
+		ExpiresAt:          data.ExpiresAt,
+		RequestFingerprint: data.RequestFingerprint,
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if data.IdempotencyKey != "" {
+		r.idempotency[idempotencyKey] = id
+	}
+	msg := r.messages[id]
+	return &CreatedMessageDbResponse{
+		Id:          msg.Id,
+		PhoneNumber: msg.PhoneNumber,
+		Content:     msg.Content,
+		Status:      msg.Status,
+		SendAt:      msg.SendAt,
+		ExpiresAt:   msg.ExpiresAt,
+		CreatedAt:   msg.CreatedAt,
+	}, nil
+}
+
+func (r *memoryRepo) CreateMessages(ctx context.Context, data []CreateMessage) ([]CreateMessagesResult, error) {
+	results := make([]CreateMessagesResult, 0, len(data))
+	for _, msg := range data {
+		created, err := r.CreateMessage(ctx, msg)
+		results = append(results, CreateMessagesResult{Message: created, Err: err})
+	}
+	return results, nil
+}
+
+func (r *memoryRepo) GetMessageByID(_ context.Context, messageID string) (*Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msg, ok := r.messages[messageID]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	found := *msg
+	return &found, nil
+}
+
+func (r *memoryRepo) GetMessageByIdempotencyKey(ctx context.Context, idempotencyKey string) (*Message, error) {
+	r.mu.Lock()
+	id, ok := r.idempotency[tenant.IDFromContext(ctx)+"|"+idempotencyKey]
+	r.mu.Unlock()
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return r.GetMessageByID(ctx, id)
+}
+
+// due reports whether the cron would claim msg, like dueMessagesFilter.
+func due(msg *Message, timeNow time.Time) bool {
+	if msg.Status != New && msg.Status != Scheduled {
+		return false
+	}
+	if msg.SendAt != nil && msg.SendAt.After(timeNow) {
+		return false
+	}
+	return msg.ExpiresAt == nil || msg.ExpiresAt.After(timeNow)
+}
+
+func (r *memoryRepo) ClaimMessages(_ context.Context, owner string, limit int, _ time.Duration) ([]Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var claimed []Message
+	timeNow := time.Now()
+	for _, id := range r.order {
+		msg := r.messages[id]
+		if len(claimed) == limit || !due(msg, timeNow) {
+			continue
+		}
+		r.transition(msg, Process)
+		r.leases[id] = owner
+		claimed = append(claimed, *msg)
+	}
+	return claimed, nil
+}
+
+func (r *memoryRepo) RenewLease(_ context.Context, messageID, owner string, _ time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if msg, ok := r.messages[messageID]; !ok || msg.Status != Process || r.leases[messageID] != owner {
+		return ErrStatusConflict
+	}
+	return nil
+}
+
+func (r *memoryRepo) TransitionClaimedMessage(_ context.Context, messageID, owner string, to Status) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msg, ok := r.messages[messageID]
+	if !ok || msg.Status != Process || r.leases[messageID] != owner {
+		return ErrStatusConflict
+	}
+	r.transition(msg, to)
+	delete(r.leases, messageID)
+	return nil
+}
+
+func (r *memoryRepo) RecordDeliveryAttempt(_ context.Context, messageID string, attempt DeliveryAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msg, ok := r.messages[messageID]
+	if !ok {
+		return ErrMessageNotFound
+	}
+	msg.Attempts = append(msg.Attempts, attempt)
+	return nil
+}
+
+func (r *memoryRepo) ExpireMessages(_ context.Context, timeNow time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired int64
+	for _, msg := range r.messages {
+		waiting := msg.Status == New || msg.Status == Scheduled || msg.Status == Fail
+		if waiting && msg.ExpiresAt != nil && !msg.ExpiresAt.After(timeNow) {
+			r.transition(msg, Expired)
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (r *memoryRepo) TransitionMessageStatus(_ context.Context, messageID string, from []Status, to Status) (*Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msg, ok := r.messages[messageID]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if !slices.Contains(from, msg.Status) {
+		return nil, ErrStatusConflict
+	}
+	r.transition(msg, to)
+	updated := *msg
+	return &updated, nil
+}
+
+func (r *memoryRepo) RescheduleMessage(_ context.Context, messageID string, sendAt, expiresAt *time.Time) (*Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msg, ok := r.messages[messageID]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if msg.Status != New && msg.Status != Scheduled {
+		return nil, ErrStatusConflict
+	}
+
+	newStatus := New
+	if sendAt != nil && sendAt.After(time.Now()) {
+		newStatus = Scheduled
+	}
+	msg.SendAt = sendAt
+	msg.ExpiresAt = expiresAt
+	r.transition(msg, newStatus)
+	updated := *msg
+	return &updated, nil
+}
+
+func (r *memoryRepo) ClaimRetryDelivery(_ context.Context, messageID, owner string, attempt int, _ time.Duration) (*Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := fmt.Sprintf("%s|%d", messageID, attempt)
+	if r.processed[key] {
+		return nil, ErrDuplicateDelivery
+	}
+	r.processed[key] = true
+
+	msg, ok := r.messages[messageID]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if msg.Status != Fail {
+		return nil, ErrStatusConflict
+	}
+	r.transition(msg, Process)
+	r.leases[messageID] = owner
+	claimed := *msg
+	return &claimed, nil
+}
+
+func (r *memoryRepo) ClearProcessedDeliveries(_ context.Context, messageID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.processed {
+		if strings.HasPrefix(key, messageID+"|") {
+			delete(r.processed, key)
+		}
+	}
+	return nil
+}
+
+// unlimited is a ratelimit.Limiter which lets everything through.
+type unlimited struct{}
+
+func (unlimited) AllowCreate(context.Context, string, int) error {
+	return nil
+}
+
+func (unlimited) ReserveRecipient(context.Context, string, string, int) (func(ctx context.Context, count int), error) {
+	return func(context.Context, int) {}, nil
+}
+
+func (unlimited) WaitDispatch(context.Context, time.Time) bool {
+	return true
+}
+
+type quotaTenants struct {
+	tenant.UseCase
+
+	provider provider.Provider
+}
+
+func (t quotaTenants) Provider(context.Context, string) (provider.Provider, error) {
+	return t.provider, nil
+}
+
+func (quotaTenants) ReserveQuota(context.Context, string, int) (func(ctx context.Context, count int), error) {
+	return func(context.Context, int) {}, nil
+}
+
+func newTestUseCase(repo Repository) *useCase {
+	return NewUseCase(&NewUseCaseOptions{
+		Repo:       repo,
+		Tenants:    quotaTenants{},
+		Limits:     unlimited{},
+		InstanceID: "test-instance",
+	}).(*useCase)
+}
+
+func timeRef(t time.Time) *time.Time {
+	return &t
+}
+
+func TestCreateMessageSchedule(t *testing.T) {
+	timeNow := time.Now()
+
+	tests := []struct {
+		name       string
+		sendAt     *time.Time
+		expiresAt  *time.Time
+		wantStatus string
+		wantErr    error
+	}{
+		{name: "no sendAt", wantStatus: "New"},
+		{name: "sendAt in the past", sendAt: timeRef(timeNow.Add(-time.Hour)), wantStatus: "New"},
+		{name: "sendAt in the future", sendAt: timeRef(timeNow.Add(time.Hour)), wantStatus: "Scheduled"},
+		{
+			name:       "expiresAt after sendAt",
+			sendAt:     timeRef(timeNow.Add(time.Hour)),
+			expiresAt:  timeRef(timeNow.Add(2 * time.Hour)),
+			wantStatus: "Scheduled",
+		},
+		{name: "expiresAt in the past", expiresAt: timeRef(timeNow.Add(-time.Minute)), wantErr: ErrInvalidSchedule},
+		{
+			name:      "expiresAt before sendAt",
+			sendAt:    timeRef(timeNow.Add(2 * time.Hour)),
+			expiresAt: timeRef(timeNow.Add(time.Hour)),
+			wantErr:   ErrInvalidSchedule,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryRepo()
+			u := newTestUseCase(repo)
+
+			request := CreateMessageRequest{PhoneNumber: "+905551111111", Content: "hello", SendAt: tt.sendAt, ExpiresAt: tt.expiresAt}
+			resp, err := u.CreateMessage(context.Background(), request, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if len(repo.messages) != 0 {
+					t.Errorf("an invalid schedule stored %d messages", len(repo.messages))
+				}
+				return
+			}
+
+			if resp.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", resp.Status, tt.wantStatus)
+			}
+			stored, err := repo.GetMessageByID(context.Background(), resp.Id)
+			if err != nil {
+				t.Fatalf("GetMessageByID() = %v", err)
+			}
+			if stored.SendAt != tt.sendAt || stored.ExpiresAt != tt.expiresAt {
+				t.Errorf("stored sendAt/expiresAt = %v/%v, want %v/%v", stored.SendAt, stored.ExpiresAt, tt.sendAt, tt.expiresAt)
+			}
+		})
+	}
+}
+
+func TestRescheduleMessage(t *testing.T) {
+	timeNow := time.Now()
+	future := RescheduleMessageRequest{SendAt: timeRef(timeNow.Add(time.Hour))}
+	past := RescheduleMessageRequest{SendAt: timeRef(timeNow.Add(-time.Hour))}
+
+	tests := []struct {
+		name       string
+		status     Status
+		request    RescheduleMessageRequest
+		wantStatus string
+		wantErr    error
+	}{
+		{name: "new to the future", status: New, request: future, wantStatus: "Scheduled"},
+		{name: "scheduled to the past", status: Scheduled, request: past, wantStatus: "New"},
+		{name: "scheduled to the future", status: Scheduled, request: future, wantStatus: "Scheduled"},
+		{
+			name:    "expiresAt before sendAt",
+			status:  Scheduled,
+			request: RescheduleMessageRequest{SendAt: timeRef(timeNow.Add(time.Hour)), ExpiresAt: timeRef(timeNow.Add(time.Minute))},
+			wantErr: ErrInvalidSchedule,
+		},
+		{name: "in flight", status: Process, request: future, wantErr: ErrStatusConflict},
+		{name: "waiting for a retry", status: Fail, request: future, wantErr: ErrStatusConflict},
+		{name: "sent", status: Sent, request: future, wantErr: ErrStatusConflict},
+		{name: "cancelled", status: Cancelled, request: future, wantErr: ErrStatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryRepo()
+			id := repo.add(Message{PhoneNumber: "+905551111111", Content: "hello", Status: tt.status})
+
+			resp, err := newTestUseCase(repo).RescheduleMessage(context.Background(), id, tt.request)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RescheduleMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if got := repo.status(t, id); got != tt.status {
+					t.Errorf("status = %v, want it unchanged as %v", got, tt.status)
+				}
+				return
+			}
+			if resp.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", resp.Status, tt.wantStatus)
+			}
+		})
+	}
+
+	_, err := newTestUseCase(newMemoryRepo()).RescheduleMessage(context.Background(), "unknown", future)
+	if !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("RescheduleMessage() of an unknown message = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestSendMessagesExpiresMessagesBeforeClaiming(t *testing.T) {
+	repo := newMemoryRepo()
+	timeNow := time.Now()
+	expiredAt := timeRef(timeNow.Add(-time.Minute))
+
+	expiredNew := repo.add(Message{Status: New, ExpiresAt: expiredAt})
+	expiredScheduled := repo.add(Message{Status: Scheduled, SendAt: timeRef(timeNow.Add(-time.Hour)), ExpiresAt: expiredAt})
+	expiredRetry := repo.add(Message{Status: Fail, ExpiresAt: expiredAt})
+	notDue := repo.add(Message{Status: Scheduled, SendAt: timeRef(timeNow.Add(time.Hour)), ExpiresAt: timeRef(timeNow.Add(2 * time.Hour))})
+	sent := repo.add(Message{Status: Sent, ExpiresAt: expiredAt})
+
+	if err := newTestUseCase(repo).SendMessages(context.Background()); err != nil {
+		t.Fatalf("SendMessages() = %v", err)
+	}
+
+	want := map[string]Status{
+		expiredNew:       Expired,
+		expiredScheduled: Expired,
+		expiredRetry:     Expired,
+		notDue:           Scheduled,
+		sent:             Sent,
+	}
+	for id, wantStatus := range want {
+		if got := repo.status(t, id); got != wantStatus {
+			t.Errorf("status of %s = %v, want %v", id, got, wantStatus)
+		}
+	}
+}
+
+func TestCancelMessageTransitions(t *testing.T) {
+	tests := []struct {
+		status  Status
+		wantErr error
+	}{
+		{status: New},
+		{status: Scheduled},
+		{status: Fail},
+		{status: Process, wantErr: ErrStatusConflict},
+		{status: Sent, wantErr: ErrStatusConflict},
+		{status: Dead, wantErr: ErrStatusConflict},
+		{status: Expired, wantErr: ErrStatusConflict},
+		{status: Cancelled, wantErr: ErrStatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status.String(), func(t *testing.T) {
+			repo := newMemoryRepo()
+			id := repo.add(Message{PhoneNumber: "+905551111111", Content: "hello", Status: tt.status})
+
+			resp, err := newTestUseCase(repo).CancelMessage(context.Background(), id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CancelMessage() error = %v, want %v", err, tt.wantErr)
+			}
+
+			wantStatus := Cancelled
+			if tt.wantErr != nil {
+				wantStatus = tt.status
+			} else if resp.Status != "Cancelled" {
+				t.Errorf("response status = %s, want Cancelled", resp.Status)
+			}
+			if got := repo.status(t, id); got != wantStatus {
+				t.Errorf("status = %v, want %v", got, wantStatus)
+			}
+		})
+	}
+}
+
+// consumeRabbitMQ hands the queued messages to the retry task once and reports the final status of each
+// through updateStatus, like a consumer giving up on them.
+type consumeRabbitMQ struct {
+	rabbitmq.Client
+
+	queued      []rabbitmq.FailedMessage
+	finalStatus Status
+
+	taskErrs   []error
+	updateErrs []error
+}
+
+func (c *consumeRabbitMQ) ConsumeFailures(ctx context.Context,
+	retryTask func(context.Context, rabbitmq.FailedMessage) error,
+	updateStatus func(messageID string, status uint8) error,
+	_ func(ctx context.Context, msg rabbitmq.FailedMessage, delay time.Duration) error, _ int) error {
+	for _, msg := range c.queued {
+		c.taskErrs = append(c.taskErrs, retryTask(ctx, msg))
+		c.updateErrs = append(c.updateErrs, updateStatus(msg.MessageID, uint8(c.finalStatus)))
+	}
+	return nil
+}
+
+// runConsumer starts the retry consumer of u and waits until it has worked through the queued messages.
+func runConsumer(t *testing.T, u *useCase) {
+	t.Helper()
+
+	u.StartConsumeFailures(context.Background(), RetryFailMessageSendFibonacciLimit)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := u.DrainConsumeFailures(ctx); err != nil {
+		t.Fatalf("DrainConsumeFailures() = %v", err)
+	}
+}
+
+func TestRetryConsumerSkipsCancelledMessages(t *testing.T) {
+	repo := newMemoryRepo()
+	cancelled := repo.add(Message{PhoneNumber: "+905551111111", Content: "hello", Status: Cancelled})
+
+	rabbitMQ := &consumeRabbitMQ{
+		queued:      []rabbitmq.FailedMessage{{MessageID: cancelled, PhoneNumber: "+905551111111", Content: "hello", Attempt: 1}},
+		finalStatus: Dead,
+	}
+	u := newTestUseCase(repo)
+	u.rabbitMQ = rabbitMQ
+	runConsumer(t, u)
+
+	if len(rabbitMQ.taskErrs) != 1 || !errors.Is(rabbitMQ.taskErrs[0], rabbitmq.ErrSkipMessage) {
+		t.Errorf("retry task = %v, want ErrSkipMessage", rabbitMQ.taskErrs)
+	}
+	// Giving up on the delivery must not turn the cancelled message into a dead one
+	if len(rabbitMQ.updateErrs) != 1 || rabbitMQ.updateErrs[0] != nil {
+		t.Errorf("status update = %v, want it ignored without an error", rabbitMQ.updateErrs)
+	}
+	if got := repo.status(t, cancelled); got != Cancelled {
+		t.Errorf("status = %v, want Cancelled", got)
+	}
+}
+
+func TestCreateMessageIdempotencyKeysArePerTenant(t *testing.T) {
+	repo := newMemoryRepo()
+	u := newTestUseCase(repo)
+	request := CreateMessageRequest{PhoneNumber: "+905551111111", Content: "hello"}
+
+	acme, err := u.CreateMessage(tenant.NewContext(context.Background(), "acme"), request, "order-42")
+	if err != nil {
+		t.Fatalf("CreateMessage() for acme = %v", err)
+	}
+	globex, err := u.CreateMessage(tenant.NewContext(context.Background(), "globex"), request, "order-42")
+	if err != nil {
+		t.Fatalf("CreateMessage() for globex = %v", err)
+	}
+	if acme.Id == globex.Id {
+		t.Errorf("both tenants got message %s for the same key", acme.Id)
+	}
+
+	replayed, err := u.CreateMessage(tenant.NewContext(context.Background(), "acme"), request, "order-42")
+	if err != nil {
+		t.Fatalf("replayed CreateMessage() for acme = %v", err)
+	}
+	if replayed.Id != acme.Id {
+		t.Errorf("replay for acme returned %s, want %s", replayed.Id, acme.Id)
+	}
+}
+
+func TestSendMessagesRecordsAnUnreadableSuccessAsSent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, "<html>queued</html>")
+	}))
+	defer server.Close()
+
+	p, err := provider.New(provider.Config{Name: provider.HTTPJSON, URL: server.URL, MessageIDPath: "data.id"})
+	if err != nil {
+		t.Fatalf("provider.New() = %v", err)
+	}
+
+	repo := newMemoryRepo()
+	id := repo.add(Message{PhoneNumber: "+905551111111", Content: "hello", Status: New})
+	u := newTestUseCase(repo)
+	u.tenants = quotaTenants{provider: p}
+
+	if err := u.SendMessages(context.Background()); err != nil {
+		t.Fatalf("SendMessages() = %v", err)
+	}
+
+	msg, err := repo.GetMessageByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetMessageByID() = %v", err)
+	}
+	if msg.Status != Sent {
+		t.Errorf("status = %v, want Sent", msg.Status)
+	}
+	if len(msg.Attempts) != 1 || msg.Attempts[0].Error != "" || msg.Attempts[0].ResponseId != "" || msg.Attempts[0].HTTPStatus != http.StatusOK {
+		t.Errorf("attempts = %+v, want one successful attempt without a response id", msg.Attempts)
+	}
+}
+
+// deadLetterRabbitMQ keeps a dead-letter queue in memory and follows the replay contract of the RabbitMQ
+// client: skipped dead letters are dropped, failed ones stay, an unconfirmed publish is restored.
+type deadLetterRabbitMQ struct {
+	rabbitmq.Client
+
+	queue []rabbitmq.DeadLetter
+	// unconfirmed fails the publish of these messages
+	unconfirmed map[string]bool
+	published   []rabbitmq.FailedMessage
+}
+
+func (c *deadLetterRabbitMQ) ids() []string {
+	var ids []string
+	for _, dl := range c.queue {
+		ids = append(ids, dl.MessageID)
+	}
+	return ids
+}
+
+func (c *deadLetterRabbitMQ) ListDeadLetters(_ context.Context, limit int) ([]rabbitmq.DeadLetter, error) {
+	return c.queue[:min(limit, len(c.queue))], nil
+}
+
+func (c *deadLetterRabbitMQ) ReplayDeadLetters(_ context.Context, messageIDs []string, prepare, restore func(rabbitmq.DeadLetter) error) ([]string, error) {
+	var (
+		replayed []string
+		kept     []rabbitmq.DeadLetter
+		err      error
+	)
+	for _, dl := range c.queue {
+		if err != nil || !slices.Contains(messageIDs, dl.MessageID) {
+			kept = append(kept, dl)
+			continue
+		}
+
+		prepareErr := prepare(dl)
+		if errors.Is(prepareErr, rabbitmq.ErrSkipMessage) {
+			continue
+		}
+		if prepareErr != nil {
+			kept = append(kept, dl)
+			continue
+		}
+		if c.unconfirmed[dl.MessageID] {
+			if restoreErr := restore(dl); restoreErr != nil {
+				return nil, restoreErr
+			}
+			kept = append(kept, dl)
+			err = errors.New("publish was not confirmed")
+			continue
+		}
+
+		msg := dl.FailedMessage
+		msg.Attempt = 0
+		c.published = append(c.published, msg)
+		replayed = append(replayed, dl.MessageID)
+	}
+	c.queue = kept
+	return replayed, err
+}
+
+func (c *deadLetterRabbitMQ) PurgeDeadLetters(_ context.Context, messageIDs []string) (int, error) {
+	var kept []rabbitmq.DeadLetter
+	for _, dl := range c.queue {
+		if len(messageIDs) > 0 && !slices.Contains(messageIDs, dl.MessageID) {
+			kept = append(kept, dl)
+		}
+	}
+	purged := len(c.queue) - len(kept)
+	c.queue = kept
+	return purged, nil
+}
+
+func deadLetterOf(messageID string) rabbitmq.DeadLetter {
+	return rabbitmq.DeadLetter{
+		FailedMessage: rabbitmq.FailedMessage{MessageID: messageID, PhoneNumber: "+905551111111", Content: "hello", Attempt: 3},
+		Reason:        rabbitmq.DeadReasonMaxRetries,
+	}
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	repo := newMemoryRepo()
+	dead := repo.add(Message{Status: Dead})
+	otherDead := repo.add(Message{Status: Dead})
+	sentSince := repo.add(Message{Status: Sent})
+	repo.processed[dead+"|1"] = true
+
+	rabbitMQ := &deadLetterRabbitMQ{
+		queue: []rabbitmq.DeadLetter{deadLetterOf(dead), deadLetterOf(otherDead), deadLetterOf(sentSince), deadLetterOf("unknown")},
+	}
+	u := newTestUseCase(repo)
+	u.rabbitMQ = rabbitMQ
+
+	resp, err := u.ReplayDeadLetters(context.Background(), ReplayDeadLettersRequest{MessageIDs: []string{dead, sentSince, "unknown"}})
+	if err != nil {
+		t.Fatalf("ReplayDeadLetters() = %v", err)
+	}
+	if fmt.Sprint(resp.Replayed) != fmt.Sprint([]string{dead}) {
+		t.Errorf("replayed = %v, want [%s]", resp.Replayed, dead)
+	}
+	if len(rabbitMQ.published) != 1 || rabbitMQ.published[0].Attempt != 0 {
+		t.Errorf("published = %+v, want the replayed message starting over from attempt 0", rabbitMQ.published)
+	}
+	if got := repo.status(t, dead); got != Fail {
+		t.Errorf("status of the replayed message = %v, want Fail", got)
+	}
+	if repo.processed[dead+"|1"] {
+		t.Error("processed deliveries of the replayed message were kept")
+	}
+	if got := repo.status(t, sentSince); got != Sent {
+		t.Errorf("status of the message sent in the meantime = %v, want Sent", got)
+	}
+	// The dead letters which can no longer be replayed are dropped, the one not asked for stays
+	if got := rabbitMQ.ids(); fmt.Sprint(got) != fmt.Sprint([]string{otherDead}) {
+		t.Errorf("dead-letter queue = %v, want [%s]", got, otherDead)
+	}
+}
+
+func TestReplayDeadLettersRestoresOnFailedPublish(t *testing.T) {
+	repo := newMemoryRepo()
+	dead := repo.add(Message{Status: Dead})
+
+	rabbitMQ := &deadLetterRabbitMQ{
+		queue:       []rabbitmq.DeadLetter{deadLetterOf(dead)},
+		unconfirmed: map[string]bool{dead: true},
+	}
+	u := newTestUseCase(repo)
+	u.rabbitMQ = rabbitMQ
+
+	if _, err := u.ReplayDeadLetters(context.Background(), ReplayDeadLettersRequest{MessageIDs: []string{dead}}); err == nil {
+		t.Fatal("ReplayDeadLetters() error = nil, want the failed publish")
+	}
+	if got := repo.status(t, dead); got != Dead {
+		t.Errorf("status = %v, want Dead again", got)
+	}
+	if got := rabbitMQ.ids(); fmt.Sprint(got) != fmt.Sprint([]string{dead}) {
+		t.Errorf("dead-letter queue = %v, want the dead letter kept", got)
+	}
+}
+
+func TestListAndPurgeDeadLetters(t *testing.T) {
+	rabbitMQ := &deadLetterRabbitMQ{
+		queue: []rabbitmq.DeadLetter{deadLetterOf("m1"), deadLetterOf("m2"), deadLetterOf("m3")},
+	}
+	rabbitMQ.queue[0].Failures = []rabbitmq.AttemptFailure{{Attempt: 1, Error: "gateway down"}}
+	u := newTestUseCase(newMemoryRepo())
+	u.rabbitMQ = rabbitMQ
+	ctx := context.Background()
+
+	listed, err := u.ListDeadLetters(ctx, ListDeadLettersRequest{Limit: 2})
+	if err != nil {
+		t.Fatalf("ListDeadLetters() = %v", err)
+	}
+	if len(listed) != 2 || listed[0].MessageID != "m1" || listed[0].Reason != rabbitmq.DeadReasonMaxRetries || len(listed[0].Failures) != 1 {
+		t.Errorf("listed = %+v, want m1 with its failure and m2", listed)
+	}
+	if len(rabbitMQ.queue) != 3 {
+		t.Errorf("listing removed dead letters, %d left", len(rabbitMQ.queue))
+	}
+
+	purged, err := u.PurgeDeadLetters(ctx, PurgeDeadLettersRequest{MessageIDs: []string{"m2", "unknown"}})
+	if err != nil || purged.Purged != 1 {
+		t.Fatalf("PurgeDeadLetters() of m2 = %+v, %v; want 1 purged", purged, err)
+	}
+	if got := rabbitMQ.ids(); fmt.Sprint(got) != fmt.Sprint([]string{"m1", "m3"}) {
+		t.Errorf("dead-letter queue = %v, want [m1 m3]", got)
+	}
+
+	purged, err = u.PurgeDeadLetters(ctx, PurgeDeadLettersRequest{})
+	if err != nil || purged.Purged != 2 || len(rabbitMQ.queue) != 0 {
+		t.Errorf("PurgeDeadLetters() of everything = %+v, %v; want 2 purged and an empty queue", purged, err)
+	}
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"time"
+)
+
+const namespace = "messagebird"
+
+// Registry holds every collector of the service. A dedicated registry keeps the metrics of imported
+// libraries from leaking into /metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	MessagesCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_created_total",
+		Help:      "Messages created through the API.",
+	}, []string{"source"})
+
+	MessagesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_sent_total",
+		Help:      "Messages accepted by the SMS provider.",
+	}, []string{"stage"})
+
+	MessagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_failed_total",
+		Help:      "Delivery attempts that failed and were scheduled for a retry.",
+	}, []string{"stage"})
+
+	MessagesDead = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_dead_total",
+		Help:      "Messages that were given up on.",
+	}, []string{"reason"})
+
+	ProviderRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_request_duration_seconds",
+		Help:      "Duration of the requests to the SMS provider.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider", "outcome"})
+
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route template.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of the HTTP requests, by route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	CronBatchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cron_batch_duration_seconds",
+		Help:      "Duration of a dispatcher batch.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+
+	CronBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cron_batch_size",
+		Help:      "Messages claimed by a dispatcher batch.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	RabbitMQPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rabbitmq_published_total",
+		Help:      "Messages published to RabbitMQ, by destination queue or exchange and result.",
+	}, []string{"destination", "result"})
+
+	RabbitMQConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rabbitmq_consumed_total",
+		Help:      "Deliveries handled by the retry consumer, by outcome.",
+	}, []string{"outcome"})
+
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Messages rejected at creation or deferred by the dispatcher, by the limit that was hit.",
+	}, []string{"limit"})
+
+	ReaperRecovered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reaper_recovered_total",
+		Help:      "Messages stuck in Process which the reaper handed back, by recovery mode.",
+	}, []string{"mode"})
+
+	ReaperFailedRuns = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reaper_failed_runs_total",
+		Help:      "Reaper passes which ended with an error.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		MessagesCreated,
+		MessagesSent,
+		MessagesFailed,
+		MessagesDead,
+		ProviderRequestDuration,
+		HTTPRequests,
+		HTTPRequestDuration,
+		CronBatchDuration,
+		CronBatchSize,
+		RabbitMQPublished,
+		RabbitMQConsumed,
+		RateLimited,
+		ReaperRecovered,
+		ReaperFailedRuns,
+	)
+}
+
+// Result turns an error into the result label used by the counters.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// Since returns the seconds passed since start, for observing histograms.
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const statusCountTimeout = 5 * time.Second
+
+// StatusCounter returns the number of messages per status name.
+type StatusCounter func(ctx context.Context) (map[string]int64, error)
+
+// statusCollector queries the message counts per status on every scrape instead of keeping a gauge in sync
+// with every status change across instances.
+type statusCollector struct {
+	count StatusCounter
+	desc  *prometheus.Desc
+}
+
+func NewStatusCollector(count StatusCounter) prometheus.Collector {
+	return &statusCollector{
+		count: count,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "messages"),
+			"Messages currently stored, by status.",
+			[]string{"status"}, nil,
+		),
+	}
+}
+
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), statusCountTimeout)
+	defer cancel()
+
+	counts, err := c.count(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count messages by status for metrics")
+		return
+	}
+
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), status)
+	}
+}
@@ -1,6 +1,30 @@
 package middleware
 
-import "github.com/labstack/echo/v4"
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jiin-yang/messageBird/internal/apikey"
+	"github.com/jiin-yang/messageBird/internal/metrics"
+	"github.com/jiin-yang/messageBird/internal/requestid"
+	"github.com/jiin-yang/messageBird/internal/tenant"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxRequestIDLength = 128
+
+	apiKeyHeader = "X-API-Key"
+)
+
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, rawKey string) (*apikey.APIKey, error)
+}
 
 func CommonHeaderSetterMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -9,3 +33,111 @@ func CommonHeaderSetterMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		return next(c)
 	}
 }
+
+// RequestIDMiddleware takes the request id from the X-Request-ID header or generates one, echoes it on the
+// response and puts it on the request context together with a logger that tags every log line with it.
+func RequestIDMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		requestID := c.Request().Header.Get(requestid.Header)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		c.Response().Header().Set(requestid.Header, requestID)
+
+		logger := log.With().Str("requestId", requestID).Logger()
+		ctx := requestid.NewContext(c.Request().Context(), requestID)
+		ctx = logger.WithContext(ctx)
+		c.SetRequest(c.Request().WithContext(ctx))
+
+		return next(c)
+	}
+}
+
+// validRequestID only accepts printable ASCII ids of a sane length, so a client cannot inject anything
+// odd into the logs or the response headers.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < '!' || requestID[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// MetricsMiddleware records the HTTP request metrics. Routes are labelled by their template (e.g. /messages/:id)
+// so message ids do not blow up the label cardinality.
+func MetricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			// The error handler has not written the response yet
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			} else {
+				status = http.StatusInternalServerError
+			}
+		}
+
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		method := c.Request().Method
+		metrics.HTTPRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(method, route).Observe(metrics.Since(start))
+		return err
+	}
+}
+
+// APIKeyAuthMiddleware authenticates every request not skipped with the key from the X-API-Key header or
+// an `Authorization: Bearer` header. Scopes are checked per route with apikey.RequireScope.
+func APIKeyAuthMiddleware(auth APIKeyAuthenticator, skipper func(c echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper != nil && skipper(c) {
+				return next(c)
+			}
+
+			ctx := c.Request().Context()
+			key, err := auth.Authenticate(ctx, apiKeyFromRequest(c.Request()))
+			if errors.Is(err, apikey.ErrMissingAPIKey) || errors.Is(err, apikey.ErrInvalidAPIKey) {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+			}
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("Failed to authenticate API key")
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to authenticate api key").SetInternal(err)
+			}
+
+			logger := log.Ctx(ctx).With().Str("apiKeyId", key.Id).Str("tenantId", key.TenantID).Logger()
+			ctx = apikey.NewContext(logger.WithContext(ctx), key)
+			// Repositories scope their queries to this tenant, the bootstrap key is not bound to one
+			if key.TenantID != "" {
+				ctx = tenant.NewContext(ctx, key.TenantID)
+			}
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			return next(c)
+		}
+	}
+}
+
+func apiKeyFromRequest(req *http.Request) string {
+	if key := req.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+
+	scheme, token, found := strings.Cut(req.Header.Get(echo.HeaderAuthorization), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jiin-yang/messageBird/internal/apikey"
+	"github.com/jiin-yang/messageBird/internal/requestid"
+	"github.com/jiin-yang/messageBird/internal/tenant"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type keyAuthenticator map[string]*apikey.APIKey
+
+func (a keyAuthenticator) Authenticate(_ context.Context, rawKey string) (*apikey.APIKey, error) {
+	if rawKey == "" {
+		return nil, apikey.ErrMissingAPIKey
+	}
+	if rawKey == "broken" {
+		return nil, errors.New("mongo down")
+	}
+	key, ok := a[rawKey]
+	if !ok {
+		return nil, apikey.ErrInvalidAPIKey
+	}
+	return key, nil
+}
+
+func TestAPIKeyAuthMiddleware(t *testing.T) {
+	auth := keyAuthenticator{
+		"mb_tenant":   {Id: "tenant-key", TenantID: "acme"},
+		"mb_platform": {Id: "platform-key"},
+	}
+	skipper := func(c echo.Context) bool { return c.Request().URL.Path == "/health" }
+
+	tests := []struct {
+		name       string
+		path       string
+		headers    map[string]string
+		wantStatus int
+		wantKey    string
+		wantTenant string
+	}{
+		{name: "skipped route", path: "/health", wantStatus: http.StatusOK},
+		{name: "no key", path: "/messages", wantStatus: http.StatusUnauthorized},
+		{name: "unknown key", path: "/messages", headers: map[string]string{"X-API-Key": "mb_unknown"}, wantStatus: http.StatusUnauthorized},
+		{name: "lookup failure", path: "/messages", headers: map[string]string{"X-API-Key": "broken"}, wantStatus: http.StatusInternalServerError},
+		{
+			name:       "tenant key in the header",
+			path:       "/messages",
+			headers:    map[string]string{"X-API-Key": "mb_tenant"},
+			wantStatus: http.StatusOK,
+			wantKey:    "tenant-key",
+			wantTenant: "acme",
+		},
+		{
+			name:       "platform key as a bearer token",
+			path:       "/messages",
+			headers:    map[string]string{"Authorization": "bearer mb_platform"},
+			wantStatus: http.StatusOK,
+			wantKey:    "platform-key",
+		},
+		{
+			name:       "other authorization scheme",
+			path:       "/messages",
+			headers:    map[string]string{"Authorization": "Basic mb_platform"},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			var gotKey, gotTenant string
+			err := APIKeyAuthMiddleware(auth, skipper)(func(c echo.Context) error {
+				if key := apikey.FromContext(c.Request().Context()); key != nil {
+					gotKey = key.Id
+				}
+				gotTenant = tenant.IDFromContext(c.Request().Context())
+				return c.NoContent(http.StatusOK)
+			})(c)
+
+			status := http.StatusOK
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			} else if err != nil {
+				t.Fatalf("middleware error = %v", err)
+			}
+
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if status == http.StatusUnauthorized && rec.Header().Get(echo.HeaderWWWAuthenticate) != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want Bearer", rec.Header().Get(echo.HeaderWWWAuthenticate))
+			}
+			if gotKey != tt.wantKey || gotTenant != tt.wantTenant {
+				t.Errorf("context key = %q, tenant = %q; want %q, %q", gotKey, gotTenant, tt.wantKey, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		wantKept  bool
+	}{
+		{name: "client id", requestID: "req-42_abc.DEF", wantKept: true},
+		{name: "longest allowed", requestID: strings.Repeat("a", maxRequestIDLength), wantKept: true},
+		{name: "missing"},
+		{name: "too long", requestID: strings.Repeat("a", maxRequestIDLength+1)},
+		{name: "space", requestID: "req 42"},
+		{name: "tab", requestID: "req\t42"},
+		{name: "control character", requestID: "req\x1b[31m"},
+		{name: "non-ascii", requestID: "req-ığü"},
+	}
+	for _, tt := range tests {
+		e := echo.New()
+		var inContext string
+		handler := RequestIDMiddleware(func(c echo.Context) error {
+			inContext = requestid.FromContext(c.Request().Context())
+			return c.NoContent(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/messages", nil)
+		if tt.requestID != "" {
+			req.Header.Set(requestid.Header, tt.requestID)
+		}
+		rec := httptest.NewRecorder()
+		if err := handler(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("%s: unexpected error %v", tt.name, err)
+		}
+
+		got := rec.Header().Get(requestid.Header)
+		if got != inContext {
+			t.Errorf("%s: response id %q, context id %q; want the same id", tt.name, got, inContext)
+		}
+		if tt.wantKept {
+			if got != tt.requestID {
+				t.Errorf("%s: request id = %q, want %q", tt.name, got, tt.requestID)
+			}
+			continue
+		}
+		if _, err := uuid.Parse(got); err != nil || got == tt.requestID {
+			t.Errorf("%s: request id = %q, want a generated uuid", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+const (
+	LimitRecipient = "recipient"
+	LimitTenant    = "tenant"
+	LimitAPIKey    = "api_key"
+	LimitProvider  = "provider"
+)
+
+// LimitError tells which limit rejected the request and when it is worth trying again. It matches
+// ErrRateLimited with errors.Is.
+type LimitError struct {
+	Limit      string
+	RetryAfter time.Duration
+	// Reason is set when waiting does not help, e.g. a batch larger than the burst of the limit
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("%s: %s limit, %s", ErrRateLimited, e.Limit, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s limit, retry after %s", ErrRateLimited, e.Limit, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+	"math"
+	"sync"
+	"time"
+)
+
+const minIdleEviction = time.Minute
+
+// keyedLimiter keeps an in-memory token bucket per key. A bucket which was not used for as long as it
+// takes to refill completely is indistinguishable from a new one, so such buckets are dropped.
+type keyedLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	idleAfter time.Duration
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// newKeyedLimiter returns nil when perSecond is not positive, a nil limiter allows everything.
+func newKeyedLimiter(perSecond float64, burst int) *keyedLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if minBurst := int(math.Ceil(perSecond)); burst < minBurst {
+		burst = minBurst
+	}
+
+	idleAfter := time.Duration(float64(burst) / perSecond * float64(time.Second))
+	if idleAfter < minIdleEviction {
+		idleAfter = minIdleEviction
+	}
+
+	return &keyedLimiter{
+		limit:     rate.Limit(perSecond),
+		burst:     burst,
+		buckets:   make(map[string]*bucket),
+		idleAfter: idleAfter,
+	}
+}
+
+// allow takes n tokens from the bucket of key and returns a func giving them back. When they are not
+// available nothing is taken and the returned duration tells when they will be, it is 0 if n is larger
+// than the burst.
+func (l *keyedLimiter) allow(key string, n int) (func(), time.Duration, bool) {
+	if l == nil {
+		return func() {}, 0, true
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastUsed = now
+	l.mu.Unlock()
+
+	r := b.limiter.ReserveN(now, n)
+	if !r.OK() {
+		return nil, 0, false
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return nil, delay, false
+	}
+	// Cancel would be too late to give back a reservation which was due right away
+	return func() { r.CancelAt(now) }, 0, true
+}
+
+// sweep drops the idle buckets at most once per idle period, l.mu must be held.
+func (l *keyedLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idleAfter {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastUsed) >= l.idleAfter {
+			delete(l.buckets, key)
+		}
+	}
+}
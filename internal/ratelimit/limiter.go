@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/jiin-yang/messageBird/internal/apikey"
+	"github.com/jiin-yang/messageBird/internal/metrics"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+	"math"
+	"time"
+)
+
+// recipientRetention keeps the counter of an hour around a bit longer than the hour itself.
+const recipientRetention = 1 * time.Hour
+
+// Limiter throttles the outbound side. The per second limits of the tenants, API keys and the provider
+// are kept in memory and apply per instance, the hourly recipient counters are shared through the
+// repository.
+type Limiter interface {
+	// AllowCreate takes count messages from the per second limits of the caller's API key and tenant. A
+	// request refused by one of them takes nothing from the other.
+	AllowCreate(ctx context.Context, tenantID string, count int) error
+	// ReserveRecipient counts count messages to phoneNumber in the current hour of the tenant. The
+	// returned func gives back messages which were not created after all. The hours are fixed UTC
+	// windows, so up to twice the hourly limit can reach a recipient around the turn of an hour.
+	ReserveRecipient(ctx context.Context, tenantID, phoneNumber string, count int) (func(ctx context.Context, count int), error)
+	// WaitDispatch waits until the provider-wide limit allows one more request. It returns false without
+	// waiting when that is after deadline, a zero deadline waits as long as ctx allows.
+	WaitDispatch(ctx context.Context, deadline time.Time) bool
+}
+
+type limiter struct {
+	repo Repository
+
+	recipientPerHour int
+	tenants          *keyedLimiter
+	apiKeys          *keyedLimiter
+	provider         *rate.Limiter
+}
+
+// NewLimiterOptions disables every limit left at zero.
+type NewLimiterOptions struct {
+	Repo Repository
+
+	RecipientPerHour int
+	TenantPerSecond  float64
+	TenantBurst      int
+	APIKeyPerSecond  float64
+	APIKeyBurst      int
+	ProviderTPS      float64
+}
+
+func NewLimiter(opts *NewLimiterOptions) Limiter {
+	l := &limiter{
+		repo:             opts.Repo,
+		recipientPerHour: opts.RecipientPerHour,
+		tenants:          newKeyedLimiter(opts.TenantPerSecond, opts.TenantBurst),
+		apiKeys:          newKeyedLimiter(opts.APIKeyPerSecond, opts.APIKeyBurst),
+	}
+	if opts.ProviderTPS > 0 {
+		l.provider = rate.NewLimiter(rate.Limit(opts.ProviderTPS), int(math.Ceil(opts.ProviderTPS)))
+	}
+	return l
+}
+
+func (l *limiter) AllowCreate(ctx context.Context, tenantID string, count int) error {
+	cancelKey := func() {}
+	if key := apikey.FromContext(ctx); key != nil {
+		cancel, err := l.allow(l.apiKeys, LimitAPIKey, key.Id, count)
+		if err != nil {
+			return err
+		}
+		cancelKey = cancel
+	}
+
+	if _, err := l.allow(l.tenants, LimitTenant, tenantID, count); err != nil {
+		cancelKey()
+		return err
+	}
+	return nil
+}
+
+func (l *limiter) allow(keyed *keyedLimiter, limit, key string, count int) (func(), error) {
+	cancel, retryAfter, ok := keyed.allow(key, count)
+	if ok {
+		return cancel, nil
+	}
+
+	metrics.RateLimited.WithLabelValues(limit).Add(float64(count))
+	if retryAfter == 0 {
+		return nil, &LimitError{Limit: limit, Reason: "burst is smaller than the request"}
+	}
+	return nil, &LimitError{Limit: limit, RetryAfter: retryAfter}
+}
+
+func (l *limiter) ReserveRecipient(ctx context.Context, tenantID, phoneNumber string, count int) (func(ctx context.Context, count int), error) {
+	if l.recipientPerHour <= 0 {
+		return func(context.Context, int) {}, nil
+	}
+
+	now := time.Now().UTC()
+	hour := now.Truncate(time.Hour)
+	end := hour.Add(time.Hour)
+	windowID := recipientWindowID(tenantID, phoneNumber, hour)
+
+	limitErr := &LimitError{Limit: LimitRecipient, RetryAfter: end.Sub(now)}
+	if count > l.recipientPerHour {
+		metrics.RateLimited.WithLabelValues(LimitRecipient).Add(float64(count))
+		limitErr.Reason = "more messages than the hourly limit"
+		return nil, limitErr
+	}
+
+	err := l.repo.ReserveWindow(ctx, windowID, count, l.recipientPerHour, end.Add(recipientRetention))
+	if errors.Is(err, ErrRateLimited) {
+		metrics.RateLimited.WithLabelValues(LimitRecipient).Add(float64(count))
+		return nil, limitErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, count int) {
+		if count <= 0 {
+			return
+		}
+		if err := l.repo.ReleaseWindow(ctx, windowID, count); err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("window", windowID).Msg("Failed to release recipient rate limit")
+		}
+	}, nil
+}
+
+func (l *limiter) WaitDispatch(ctx context.Context, deadline time.Time) bool {
+	if l.provider == nil {
+		return true
+	}
+
+	now := time.Now()
+	r := l.provider.ReserveN(now, 1)
+	delay := r.DelayFrom(now)
+	if !deadline.IsZero() && now.Add(delay).After(deadline) {
+		r.CancelAt(now)
+		metrics.RateLimited.WithLabelValues(LimitProvider).Inc()
+		return false
+	}
+	if delay == 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		r.Cancel()
+		return false
+	}
+}
+
+// recipientWindowID names the counter of an hour, e.g. recipient|default|+905551112233|2025-01-31T13.
+func recipientWindowID(tenantID, phoneNumber string, hour time.Time) string {
+	return LimitRecipient + "|" + tenantID + "|" + phoneNumber + "|" + hour.Format("2006-01-02T15")
+}
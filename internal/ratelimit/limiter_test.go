@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/jiin-yang/messageBird/internal/apikey"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryRepo struct {
+	mu      sync.Mutex
+	windows map[string]int
+}
+
+func newMemoryRepo() *memoryRepo {
+	return &memoryRepo{windows: make(map[string]int)}
+}
+
+func (r *memoryRepo) ReserveWindow(_ context.Context, windowID string, count, limit int, _ time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.windows[windowID]+count > limit {
+		return ErrRateLimited
+	}
+	r.windows[windowID] += count
+	return nil
+}
+
+func (r *memoryRepo) ReleaseWindow(_ context.Context, windowID string, count int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.windows[windowID] -= count
+	return nil
+}
+
+func keyContext(keyID string) context.Context {
+	return apikey.NewContext(context.Background(), &apikey.APIKey{Id: keyID})
+}
+
+func limitOf(t *testing.T, err error) *LimitError {
+	t.Helper()
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("error = %v, want a LimitError", err)
+	}
+	return limitErr
+}
+
+func TestAllowCreateRefusedByTheTenantKeepsTheAPIKeyTokens(t *testing.T) {
+	l := NewLimiter(&NewLimiterOptions{
+		TenantPerSecond: 1,
+		TenantBurst:     5,
+		APIKeyPerSecond: 1,
+		APIKeyBurst:     10,
+	})
+	ctx := keyContext("key")
+
+	if err := l.AllowCreate(ctx, "tenant-a", 5); err != nil {
+		t.Fatalf("first AllowCreate() = %v", err)
+	}
+	err := l.AllowCreate(ctx, "tenant-a", 5)
+	if limitErr := limitOf(t, err); limitErr.Limit != LimitTenant || limitErr.RetryAfter <= 0 {
+		t.Errorf("second AllowCreate() = %+v, want the tenant limit with a retry after", limitErr)
+	}
+
+	// The key spent 5 of its 10 tokens, the refused request took none
+	if err := l.AllowCreate(ctx, "tenant-b", 5); err != nil {
+		t.Errorf("AllowCreate() on another tenant = %v, want the remaining key tokens", err)
+	}
+	err = l.AllowCreate(ctx, "tenant-c", 5)
+	if limitErr := limitOf(t, err); limitErr.Limit != LimitAPIKey {
+		t.Errorf("AllowCreate() over the key burst = %+v, want the api key limit", limitErr)
+	}
+}
+
+func TestAllowCreateOverTheBurst(t *testing.T) {
+	l := NewLimiter(&NewLimiterOptions{TenantPerSecond: 1, TenantBurst: 5})
+
+	limitErr := limitOf(t, l.AllowCreate(context.Background(), "tenant", 6))
+	if limitErr.Limit != LimitTenant || limitErr.Reason == "" || limitErr.RetryAfter != 0 {
+		t.Errorf("AllowCreate() = %+v, want a reason and no retry after", limitErr)
+	}
+	if err := l.AllowCreate(context.Background(), "tenant", 5); err != nil {
+		t.Errorf("AllowCreate() within the burst = %v", err)
+	}
+}
+
+func TestDisabledLimitsAllowEverything(t *testing.T) {
+	l := NewLimiter(&NewLimiterOptions{})
+	ctx := keyContext("key")
+
+	for i := 0; i < 3; i++ {
+		if err := l.AllowCreate(ctx, "tenant", 1000); err != nil {
+			t.Fatalf("AllowCreate() = %v", err)
+		}
+		release, err := l.ReserveRecipient(ctx, "tenant", "+905551112233", 1000)
+		if err != nil {
+			t.Fatalf("ReserveRecipient() = %v", err)
+		}
+		release(ctx, 1000)
+		if !l.WaitDispatch(ctx, time.Now()) {
+			t.Fatal("WaitDispatch() = false")
+		}
+	}
+}
+
+func TestReserveRecipient(t *testing.T) {
+	repo := newMemoryRepo()
+	l := NewLimiter(&NewLimiterOptions{Repo: repo, RecipientPerHour: 3})
+	ctx := context.Background()
+	const phone = "+905551112233"
+
+	release, err := l.ReserveRecipient(ctx, "tenant-a", phone, 2)
+	if err != nil {
+		t.Fatalf("ReserveRecipient() = %v", err)
+	}
+
+	limitErr := limitOf(t, func() error { _, err := l.ReserveRecipient(ctx, "tenant-a", phone, 2); return err }())
+	if limitErr.Limit != LimitRecipient || limitErr.RetryAfter <= 0 || limitErr.RetryAfter > time.Hour {
+		t.Errorf("ReserveRecipient() over the limit = %+v, want a retry after within the hour", limitErr)
+	}
+
+	// Another tenant has a counter of its own
+	if _, err := l.ReserveRecipient(ctx, "tenant-b", phone, 3); err != nil {
+		t.Errorf("ReserveRecipient() of another tenant = %v", err)
+	}
+
+	release(ctx, 1)
+	if _, err := l.ReserveRecipient(ctx, "tenant-a", phone, 2); err != nil {
+		t.Errorf("ReserveRecipient() after a release = %v", err)
+	}
+
+	limitErr = limitOf(t, func() error { _, err := l.ReserveRecipient(ctx, "tenant-c", phone, 4); return err }())
+	if limitErr.Reason == "" {
+		t.Errorf("ReserveRecipient() over the hourly limit = %+v, want a reason", limitErr)
+	}
+}
+
+func TestWaitDispatch(t *testing.T) {
+	l := NewLimiter(&NewLimiterOptions{ProviderTPS: 2})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if !l.WaitDispatch(ctx, time.Now().Add(10*time.Millisecond)) {
+			t.Fatalf("WaitDispatch() %d within the burst = false", i)
+		}
+	}
+
+	// The next token is 500ms away
+	if l.WaitDispatch(ctx, time.Now().Add(100*time.Millisecond)) {
+		t.Error("WaitDispatch() past the deadline = true")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	start := time.Now()
+	if l.WaitDispatch(cancelled, time.Time{}) {
+		t.Error("WaitDispatch() with a cancelled context = true")
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("WaitDispatch() with a cancelled context waited %v", waited)
+	}
+
+	start = time.Now()
+	if !l.WaitDispatch(ctx, time.Time{}) {
+		t.Fatal("WaitDispatch() without a deadline = false")
+	}
+	if waited := time.Since(start); waited < 300*time.Millisecond {
+		t.Errorf("WaitDispatch() returned after %v, want it to wait for the next token", waited)
+	}
+}
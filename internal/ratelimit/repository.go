@@ -0,0 +1,13 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+type Repository interface {
+	// ReserveWindow adds count to the counter of the window unless it would go over limit, in which case
+	// it returns ErrRateLimited and changes nothing. The counter is removed after expireAt.
+	ReserveWindow(ctx context.Context, windowID string, count, limit int, expireAt time.Time) error
+	ReleaseWindow(ctx context.Context, windowID string, count int) error
+}
@@ -0,0 +1,18 @@
+package requestid
+
+import "context"
+
+// Header is the HTTP header carrying the request id, both on the request and on the response.
+const Header = "X-Request-ID"
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+// FromContext returns the id of the request ctx belongs to, or an empty string outside of a request.
+func FromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(contextKey{}).(string)
+	return requestID
+}
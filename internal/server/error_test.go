@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/jiin-yang/messageBird/internal/httperror"
+	mw "github.com/jiin-yang/messageBird/internal/middleware"
+	"github.com/jiin-yang/messageBird/internal/requestid"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorResponseCarriesTheRequestID(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = customErrorHandler
+	e.Use(mw.RequestIDMiddleware)
+	e.GET("/messages/:id", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusNotFound, "message not found")
+	})
+
+	tests := []struct {
+		name      string
+		target    string
+		requestID string
+		wantCode  int
+	}{
+		{name: "handler error", target: "/messages/1", requestID: "req-42", wantCode: http.StatusNotFound},
+		{name: "unknown route", target: "/unknown", requestID: "req-43", wantCode: http.StatusNotFound},
+		{name: "generated id", target: "/messages/1", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.requestID != "" {
+			req.Header.Set(requestid.Header, tt.requestID)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantCode)
+		}
+		var resp httperror.ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to decode %s: %v", tt.name, rec.Body.String(), err)
+		}
+		header := rec.Header().Get(requestid.Header)
+		if resp.RequestID == "" || resp.RequestID != header {
+			t.Errorf("%s: requestId = %q, want the X-Request-ID header %q", tt.name, resp.RequestID, header)
+		}
+		if tt.requestID != "" && resp.RequestID != tt.requestID {
+			t.Errorf("%s: requestId = %q, want %q", tt.name, resp.RequestID, tt.requestID)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"github.com/jiin-yang/messageBird/internal/infra/rabbitmq"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"time"
+)
+
+const (
+	healthStatusUp   = "up"
+	healthStatusDown = "down"
+
+	readinessCheckTimeout = 2 * time.Second
+)
+
+type DependencyHealth struct {
+	Status    string `json:"status"`
+	Required  bool   `json:"required"`
+	LatencyMs int64  `json:"latencyMs"`
+	State     string `json:"state,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type WorkerHealth struct {
+	Running bool `json:"running"`
+}
+
+type ReadinessResponse struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyHealth `json:"dependencies"`
+	Workers      map[string]WorkerHealth     `json:"workers"`
+}
+
+func (server *Server) healthCheck(ctx echo.Context) error {
+	log.Info().Msg("Success health check!")
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// livenessCheck only tells the process is able to serve requests, dependencies are not checked so a broker
+// outage does not get the instance restarted.
+func (server *Server) livenessCheck(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"status": healthStatusUp,
+	})
+}
+
+// readinessCheck reports every dependency and returns 503 when a required one is down, so the instance is
+// taken out of the load balancer until it recovers.
+func (server *Server) readinessCheck(ctx echo.Context) error {
+	checkCtx, cancel := context.WithTimeout(ctx.Request().Context(), readinessCheckTimeout)
+	defer cancel()
+
+	resp := ReadinessResponse{
+		Status: healthStatusUp,
+		Dependencies: map[string]DependencyHealth{
+			"mongodb":  server.checkMongo(checkCtx),
+			"rabbitmq": server.checkRabbitMQ(),
+		},
+		Workers: map[string]WorkerHealth{
+			"cron":     {Running: server.cron.IsRunning()},
+			"consumer": {Running: server.useCase.IsConsumerRunning()},
+		},
+	}
+
+	for name, dependency := range resp.Dependencies {
+		if dependency.Required && dependency.Status != healthStatusUp {
+			resp.Status = healthStatusDown
+			log.Warn().Str("dependency", name).Str("error", dependency.Error).Msg("Readiness check failed")
+		}
+	}
+
+	if resp.Status != healthStatusUp {
+		return ctx.JSON(http.StatusServiceUnavailable, resp)
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+func (server *Server) checkMongo(ctx context.Context) DependencyHealth {
+	start := time.Now()
+	err := server.mongo.Ping(ctx)
+
+	health := DependencyHealth{
+		Status:    healthStatusUp,
+		Required:  true,
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		health.Status = healthStatusDown
+		health.Error = err.Error()
+	}
+	return health
+}
+
+func (server *Server) checkRabbitMQ() DependencyHealth {
+	start := time.Now()
+	err := server.rabbitMQ.Ping()
+
+	health := DependencyHealth{
+		Status:    healthStatusUp,
+		Required:  true,
+		LatencyMs: time.Since(start).Milliseconds(),
+		State:     string(server.rabbitMQ.State()),
+	}
+	if err != nil {
+		health.Status = healthStatusDown
+		health.Error = err.Error()
+	} else if health.State != string(rabbitmq.StateConnected) {
+		health.Status = healthStatusDown
+		health.Error = "connection is " + health.State
+	}
+	return health
+}
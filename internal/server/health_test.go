@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jiin-yang/messageBird/config"
+	"github.com/jiin-yang/messageBird/internal/infra/rabbitmq"
+	"github.com/jiin-yang/messageBird/internal/infra/repository/mongoDB"
+	"github.com/jiin-yang/messageBird/internal/message"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type healthRabbitMQ struct {
+	rabbitmq.Client
+
+	state   rabbitmq.ConnectionState
+	pingErr error
+}
+
+func (c *healthRabbitMQ) State() rabbitmq.ConnectionState { return c.state }
+
+func (c *healthRabbitMQ) Ping() error { return c.pingErr }
+
+type consumerUseCase struct {
+	message.UseCase
+}
+
+func (consumerUseCase) IsConsumerRunning() bool { return true }
+
+// newMongo returns a client to the MongoDB under MONGODB_TEST_URI when up, otherwise one to an address
+// nothing listens on.
+func newMongo(t *testing.T, up bool) *mongoDB.Client {
+	t.Helper()
+
+	uri := "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=200&connectTimeoutMS=200"
+	if up {
+		uri = os.Getenv("MONGODB_TEST_URI")
+		if uri == "" {
+			t.Skip("MONGODB_TEST_URI is not set")
+		}
+	}
+
+	client, err := mongoDB.NewClient(&config.MongoDBConfig{Host: uri, Name: "messagebird_health_test"})
+	if err != nil {
+		t.Fatalf("failed to create the MongoDB client: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = client.Close(ctx)
+	})
+	return client
+}
+
+func TestReadinessCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		mongoUp    bool
+		rabbitMQ   *healthRabbitMQ
+		wantStatus int
+		wantDown   []string
+	}{
+		{
+			name:       "everything up",
+			mongoUp:    true,
+			rabbitMQ:   &healthRabbitMQ{state: rabbitmq.StateConnected},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "mongodb unreachable",
+			rabbitMQ:   &healthRabbitMQ{state: rabbitmq.StateConnected},
+			wantStatus: http.StatusServiceUnavailable,
+			wantDown:   []string{"mongodb"},
+		},
+		{
+			name:       "rabbitmq reconnecting",
+			mongoUp:    true,
+			rabbitMQ:   &healthRabbitMQ{state: rabbitmq.StateReconnecting},
+			wantStatus: http.StatusServiceUnavailable,
+			wantDown:   []string{"rabbitmq"},
+		},
+		{
+			name:       "rabbitmq ping fails",
+			mongoUp:    true,
+			rabbitMQ:   &healthRabbitMQ{state: rabbitmq.StateConnected, pingErr: errors.New("channel closed")},
+			wantStatus: http.StatusServiceUnavailable,
+			wantDown:   []string{"rabbitmq"},
+		},
+		{
+			name:       "both down",
+			rabbitMQ:   &healthRabbitMQ{state: rabbitmq.StateConnecting},
+			wantStatus: http.StatusServiceUnavailable,
+			wantDown:   []string{"mongodb", "rabbitmq"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &Server{
+				mongo:    newMongo(t, tt.mongoUp),
+				rabbitMQ: tt.rabbitMQ,
+				cron:     message.NewCron(nil, 0),
+				useCase:  consumerUseCase{},
+			}
+
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/health/ready", nil), rec)
+			if err := server.readinessCheck(ctx); err != nil {
+				t.Fatalf("readinessCheck() = %v", err)
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var resp ReadinessResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode %s: %v", rec.Body.String(), err)
+			}
+			wantStatus := healthStatusUp
+			if len(tt.wantDown) > 0 {
+				wantStatus = healthStatusDown
+			}
+			if resp.Status != wantStatus {
+				t.Errorf("status = %s, want %s", resp.Status, wantStatus)
+			}
+
+			for name, dependency := range resp.Dependencies {
+				want := healthStatusUp
+				for _, down := range tt.wantDown {
+					if name == down {
+						want = healthStatusDown
+					}
+				}
+				if dependency.Status != want {
+					t.Errorf("%s = %s (%s), want %s", name, dependency.Status, dependency.Error, want)
+				}
+				if want == healthStatusDown && dependency.Error == "" {
+					t.Errorf("%s is down without an error", name)
+				}
+			}
+			if got := resp.Dependencies["rabbitmq"].State; got != string(tt.rabbitMQ.state) {
+				t.Errorf("rabbitmq state = %s, want %s", got, tt.rabbitMQ.state)
+			}
+			if !resp.Workers["consumer"].Running || resp.Workers["cron"].Running {
+				t.Errorf("workers = %+v, want the consumer running and the cron stopped", resp.Workers)
+			}
+		})
+	}
+}
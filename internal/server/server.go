@@ -1,24 +1,37 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"github.com/jiin-yang/messageBird/config"
-	"github.com/jiin-yang/messageBird/internal/client/webhook"
+	"github.com/jiin-yang/messageBird/internal/apikey"
+	"github.com/jiin-yang/messageBird/internal/client/provider"
 	"github.com/jiin-yang/messageBird/internal/infra/rabbitmq"
 	"github.com/jiin-yang/messageBird/internal/infra/repository/mongoDB"
 	"github.com/jiin-yang/messageBird/internal/message"
+	"github.com/jiin-yang/messageBird/internal/metrics"
 	mw "github.com/jiin-yang/messageBird/internal/middleware"
+	"github.com/jiin-yang/messageBird/internal/ratelimit"
+	"github.com/jiin-yang/messageBird/internal/tenant"
+	"github.com/jiin-yang/messageBird/internal/tracing"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/ory/graceful"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"net/http"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"strings"
+	"time"
 )
 
 type Server struct {
-	echo   *echo.Echo
-	config *config.Config
+	echo     *echo.Echo
+	config   *config.Config
+	mongo    *mongoDB.Client
+	rabbitMQ rabbitmq.Client
+	cron     *message.Cron
+	useCase  message.UseCase
 }
 
 func New(c *config.Config) *Server {
@@ -29,7 +42,15 @@ func New(c *config.Config) *Server {
 
 	e.Validator = NewValidator()
 
+	// Loggers taken from a context without a request logger fall back to the global logger
+	zerolog.DefaultContextLogger = &log.Logger
+
+	e.Use(mw.RequestIDMiddleware)
 	e.Use(middleware.Recover())
+	e.Use(otelecho.Middleware(c.AppConfig.AppName, otelecho.WithSkipper(func(c echo.Context) bool {
+		return strings.HasPrefix(c.Path(), "/health") || c.Path() == "/metrics"
+	})))
+	e.Use(mw.MetricsMiddleware)
 	e.Use(mw.CommonHeaderSetterMiddleware)
 	e.Use(middleware.CORS())
 	e.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(20)))
@@ -54,44 +75,216 @@ func New(c *config.Config) *Server {
 func (server *Server) Start() error {
 	server.echo.Server.Addr = fmt.Sprintf(":%d", server.config.ServerConfig.Port)
 
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Options{
+		ServiceName:  server.config.AppConfig.AppName,
+		InstanceID:   server.config.AppConfig.InstanceID,
+		Exporter:     server.config.TracingConfig.Exporter,
+		OTLPEndpoint: server.config.TracingConfig.OTLPEndpoint,
+		OTLPInsecure: server.config.TracingConfig.OTLPInsecure,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+
 	mongoClient, err := mongoDB.NewClient(&server.config.MongoDBConfig)
 	if err != nil {
 		log.Fatal().Err(err)
 	}
 
-	webhookClient := webhook.NewWebhookClient(&webhook.NewClientOptions{
-		URL: server.config.WebhookConfig.URL,
+	indexCtx, cancelIndexCtx := context.WithTimeout(context.Background(), 30*time.Second)
+	err = mongoDB.BackfillDefaultTenant(indexCtx, mongoClient)
+	if err == nil {
+		err = mongoDB.EnsureMessageIndexes(indexCtx, mongoClient)
+	}
+	if err == nil {
+		err = mongoDB.EnsureOutboxIndexes(indexCtx, mongoClient)
+	}
+	if err == nil {
+		err = mongoDB.EnsureAPIKeyIndexes(indexCtx, mongoClient)
+	}
+	if err == nil {
+		err = mongoDB.EnsureTenantIndexes(indexCtx, mongoClient)
+	}
+	if err == nil {
+		err = mongoDB.EnsureRateLimitIndexes(indexCtx, mongoClient)
+	}
+	cancelIndexCtx()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create MongoDB indexes")
+	}
+
+	providerConf := server.config.ProviderConfig
+	smsProvider, err := provider.New(provider.Config{
+		Name:          providerConf.Name,
+		URL:           providerConf.URL,
+		Timeout:       time.Duration(providerConf.TimeoutSeconds) * time.Second,
+		Headers:       providerConf.Headers,
+		ToField:       providerConf.ToField,
+		ContentField:  providerConf.ContentField,
+		MessageIDPath: providerConf.MessageIDPath,
+		StatePath:     providerConf.StatePath,
 	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize SMS provider")
+	}
+	if server.config.DispatcherConfig.LeaseSeconds <= providerConf.TimeoutSeconds {
+		log.Warn().Int("leaseSeconds", server.config.DispatcherConfig.LeaseSeconds).
+			Int("providerTimeoutSeconds", providerConf.TimeoutSeconds).
+			Msg("DISPATCHER_LEASE_SECONDS is not longer than the provider timeout, a slow send may be taken over by another instance")
+	}
+
+	tenantUseCase := tenant.NewUseCase(&tenant.NewUseCaseOptions{
+		Repo: mongoDB.NewTenantRepository(&mongoDB.NewTenantRepositoryOpts{
+			Client: mongoClient,
+		}),
+		DefaultProvider: smsProvider,
+	})
+
+	tenantCtx, cancelTenantCtx := context.WithTimeout(context.Background(), 10*time.Second)
+	err = tenantUseCase.EnsureDefaultTenant(tenantCtx)
+	cancelTenantCtx()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create the default tenant")
+	}
+
+	rabbitMQClient, err := rabbitmq.NewRabbitMQClient(&rabbitmq.NewClientOptions{
+		URL:           server.config.RabbitMQConfig.URL,
+		FailQueueName: "fail_messages",
+		MaxRetries:    message.RetryFailMessageSendFibonacciLimit,
 
-	rabbitMQClient, err := rabbitmq.NewRabbitMQClient(server.config.RabbitMQConfig.URL, "fail_messages")
+		PrefetchCount:   server.config.RabbitMQConfig.PrefetchCount,
+		ConsumerWorkers: server.config.RabbitMQConfig.ConsumerWorkers,
+	})
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize RabbitMQ client")
 	}
-	defer rabbitMQClient.Close()
+	server.rabbitMQ = rabbitMQClient
 
 	messageRepository := mongoDB.NewMessageRepository(&mongoDB.NewMessageRepositoryOpts{
 		Client: mongoClient,
 	})
 
+	rateLimitConf := server.config.RateLimitConfig
+	limiter := ratelimit.NewLimiter(&ratelimit.NewLimiterOptions{
+		Repo: mongoDB.NewRateLimitRepository(&mongoDB.NewRateLimitRepositoryOpts{
+			Client: mongoClient,
+		}),
+		RecipientPerHour: rateLimitConf.RecipientPerHour,
+		TenantPerSecond:  rateLimitConf.TenantPerSecond,
+		TenantBurst:      rateLimitConf.TenantBurst,
+		APIKeyPerSecond:  rateLimitConf.APIKeyPerSecond,
+		APIKeyBurst:      rateLimitConf.APIKeyBurst,
+		ProviderTPS:      rateLimitConf.ProviderTPS,
+	})
+
 	messageUseCase := message.NewUseCase(&message.NewUseCaseOptions{
-		Repo:     messageRepository,
-		Webhook:  webhookClient,
-		RabbitMQ: rabbitMQClient,
+		Repo:       messageRepository,
+		Tenants:    tenantUseCase,
+		Limits:     limiter,
+		RabbitMQ:   rabbitMQClient,
+		InstanceID: server.config.AppConfig.InstanceID,
+
+		BatchSize:     server.config.DispatcherConfig.BatchSize,
+		Workers:       server.config.DispatcherConfig.Workers,
+		MaxInFlight:   server.config.DispatcherConfig.MaxInFlight,
+		LeaseDuration: time.Duration(server.config.DispatcherConfig.LeaseSeconds) * time.Second,
 	})
 
-	cronJob := message.NewCron(messageUseCase)
-	defer cronJob.StopCron()
+	cronJob := message.NewCron(messageUseCase, time.Duration(server.config.DispatcherConfig.IntervalSeconds)*time.Second)
+
+	reaper := message.NewReaper(messageUseCase, message.ReaperOptions{
+		Interval:       time.Duration(server.config.ReaperConfig.IntervalSeconds) * time.Second,
+		StuckThreshold: time.Duration(server.config.ReaperConfig.StuckThresholdSeconds) * time.Second,
+		Mode:           message.RecoveryMode(server.config.ReaperConfig.Mode),
+	})
+	reaper.Start()
 
-	message.NewHandler(server.echo, messageUseCase, cronJob)
+	outboxRelay := message.NewOutboxRelay(messageUseCase, message.OutboxRelayOptions{
+		Interval:  time.Duration(server.config.OutboxConfig.RelayIntervalSeconds) * time.Second,
+		BatchSize: server.config.OutboxConfig.RelayBatchSize,
+	})
+	outboxRelay.Start()
+
+	apiKeyUseCase := apikey.NewUseCase(&apikey.NewUseCaseOptions{
+		Repo: mongoDB.NewAPIKeyRepository(&mongoDB.NewAPIKeyRepositoryOpts{
+			Client: mongoClient,
+		}),
+		Tenants:           tenantUseCase,
+		BootstrapAdminKey: server.config.AuthConfig.BootstrapAdminKey,
+	})
+	if server.config.AuthConfig.BootstrapAdminKey == "" {
+		log.Warn().Msg("API_BOOTSTRAP_ADMIN_KEY is not set, only API keys stored in MongoDB are accepted")
+	}
+	// Health checks and metrics stay open for the orchestrator and the scraper
+	server.echo.Use(mw.APIKeyAuthMiddleware(apiKeyUseCase, func(c echo.Context) bool {
+		path := c.Request().URL.Path
+		return strings.HasPrefix(path, "/health") || path == "/metrics"
+	}))
+
+	message.NewHandler(server.echo, messageUseCase, cronJob, reaper)
+	apikey.NewHandler(server.echo, apiKeyUseCase)
+	tenant.NewHandler(server.echo, tenantUseCase)
 
 	log.Info().Msg("Server Start Successfully!")
 
+	server.mongo = mongoClient
+	server.cron = cronJob
+	server.useCase = messageUseCase
+	// /health keeps answering 204 for the existing probes, the dependencies are under /health/ready
 	server.echo.GET("/health", server.healthCheck)
+	server.echo.GET("/health/live", server.livenessCheck)
+	server.echo.GET("/health/ready", server.readinessCheck)
 
-	return graceful.Graceful(server.echo.Server.ListenAndServe, server.echo.Server.Shutdown)
-}
+	metrics.Registry.MustRegister(metrics.NewStatusCollector(func(ctx context.Context) (map[string]int64, error) {
+		counts, err := messageRepository.CountMessagesByStatus(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		byName := make(map[string]int64, len(counts))
+		for status, count := range counts {
+			byName[status.String()] = count
+		}
+		return byName, nil
+	}))
+	server.echo.GET("/metrics", echo.WrapHandler(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})))
+
+	// Graceful returns once the HTTP server stopped accepting requests, the background work is drained after it
+	err = graceful.Graceful(server.echo.Server.ListenAndServe, server.echo.Server.Shutdown)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(),
+		time.Duration(server.config.ServerConfig.ShutdownTimeoutSeconds)*time.Second)
+	defer cancelShutdown()
+
+	// The reaper and the relay write to MongoDB and RabbitMQ, their last pass finishes before both are closed
+	if drainErr := reaper.Shutdown(shutdownCtx); drainErr != nil {
+		log.Warn().Err(drainErr).Msg("Reaper pass did not finish before the shutdown deadline")
+	}
+	if drainErr := outboxRelay.Shutdown(shutdownCtx); drainErr != nil {
+		log.Warn().Err(drainErr).Msg("Outbox relay batch did not finish before the shutdown deadline")
+	}
+
+	if drainErr := cronJob.Shutdown(shutdownCtx); drainErr != nil {
+		log.Warn().Err(drainErr).Msg("Cron batch did not finish before the shutdown deadline")
+	}
+	// Deliveries still unacked when the deadline passes are requeued by the broker once the channel is closed
+	if drainErr := messageUseCase.DrainConsumeFailures(shutdownCtx); drainErr != nil {
+		log.Warn().Err(drainErr).Msg("RabbitMQ consumer did not drain before the shutdown deadline")
+	}
+
+	if closeErr := rabbitMQClient.Close(); closeErr != nil {
+		log.Warn().Err(closeErr).Msg("Failed to close RabbitMQ client")
+	}
+
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelClose()
+	if closeErr := mongoClient.Close(closeCtx); closeErr != nil {
+		log.Warn().Err(closeErr).Msg("Failed to disconnect from MongoDB")
+	}
+	if closeErr := shutdownTracing(closeCtx); closeErr != nil {
+		log.Warn().Err(closeErr).Msg("Failed to flush pending spans")
+	}
 
-func (server *Server) healthCheck(ctx echo.Context) error {
-	log.Info().Msg("Success health check!")
-	return ctx.NoContent(http.StatusNoContent)
+	log.Info().Msg("Server stopped")
+	return err
 }
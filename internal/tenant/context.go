@@ -0,0 +1,24 @@
+package tenant
+
+import "context"
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenantID)
+}
+
+// IDFromContext returns the tenant the caller is bound to. It is empty for background work and for
+// callers which may act on every tenant, repositories do not scope their queries then.
+func IDFromContext(ctx context.Context) string {
+	tenantID, _ := ctx.Value(contextKey{}).(string)
+	return tenantID
+}
+
+// IDOrDefault returns the tenant of the caller, or DefaultTenantID when the caller is not bound to one.
+func IDOrDefault(ctx context.Context) string {
+	if tenantID := IDFromContext(ctx); tenantID != "" {
+		return tenantID
+	}
+	return DefaultTenantID
+}
@@ -0,0 +1,58 @@
+package tenant
+
+import "time"
+
+type CreateTenantRequest struct {
+	Id           string               `json:"id" validate:"required,min=2,max=64,alphanum,lowercase"`
+	Name         string               `json:"name" validate:"required,max=100"`
+	Sender       *SenderConfigRequest `json:"sender,omitempty"`
+	DailyQuota   int                  `json:"dailyQuota" validate:"min=0"`
+	MonthlyQuota int                  `json:"monthlyQuota" validate:"min=0"`
+}
+
+type UpdateTenantRequest struct {
+	Name         string               `json:"name" validate:"required,max=100"`
+	Sender       *SenderConfigRequest `json:"sender,omitempty"`
+	DailyQuota   int                  `json:"dailyQuota" validate:"min=0"`
+	MonthlyQuota int                  `json:"monthlyQuota" validate:"min=0"`
+}
+
+type SenderConfigRequest struct {
+	Provider       string            `json:"provider" validate:"required"`
+	URL            string            `json:"url" validate:"required,url"`
+	TimeoutSeconds int               `json:"timeoutSeconds" validate:"min=0"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	ToField        string            `json:"toField,omitempty"`
+	ContentField   string            `json:"contentField,omitempty"`
+	MessageIDPath  string            `json:"messageIdPath,omitempty"`
+	StatePath      string            `json:"statePath,omitempty"`
+}
+
+type TenantResponse struct {
+	Id           string                `json:"id"`
+	Name         string                `json:"name"`
+	Sender       *SenderConfigResponse `json:"sender,omitempty"`
+	DailyQuota   int                   `json:"dailyQuota"`
+	MonthlyQuota int                   `json:"monthlyQuota"`
+	Usage        []UsageResponse       `json:"usage,omitempty"`
+	CreatedAt    *time.Time            `json:"createdAt"`
+	UpdatedAt    *time.Time            `json:"updatedAt,omitempty"`
+}
+
+// SenderConfigResponse leaves the header values out, they usually carry the provider credentials.
+type SenderConfigResponse struct {
+	Provider       string   `json:"provider"`
+	URL            string   `json:"url"`
+	TimeoutSeconds int      `json:"timeoutSeconds,omitempty"`
+	Headers        []string `json:"headers,omitempty"`
+	ToField        string   `json:"toField,omitempty"`
+	ContentField   string   `json:"contentField,omitempty"`
+	MessageIDPath  string   `json:"messageIdPath,omitempty"`
+	StatePath      string   `json:"statePath,omitempty"`
+}
+
+type UsageResponse struct {
+	Period string `json:"period"`
+	Count  int    `json:"count"`
+	Limit  int    `json:"limit"`
+}
@@ -0,0 +1,11 @@
+package tenant
+
+import "errors"
+
+var (
+	ErrTenantNotFound      = errors.New("tenant not found")
+	ErrTenantExists        = errors.New("tenant already exists")
+	ErrTenantScope         = errors.New("only platform api keys can create or change tenants")
+	ErrInvalidSenderConfig = errors.New("invalid sender configuration")
+	ErrQuotaExceeded       = errors.New("tenant send quota exceeded")
+)
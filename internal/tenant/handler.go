@@ -0,0 +1,124 @@
+package tenant
+
+import (
+	"errors"
+	"github.com/jiin-yang/messageBird/internal/apikey"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+type Handler interface {
+	createTenant(ctx echo.Context) error
+}
+
+type handler struct {
+	echo    *echo.Echo
+	useCase UseCase
+}
+
+func NewHandler(e *echo.Echo, u UseCase) Handler {
+	h := &handler{
+		echo:    e,
+		useCase: u,
+	}
+	h.registerRoutes()
+	return h
+}
+
+func (h *handler) registerRoutes() {
+	tenants := h.echo.Group("/admin/tenants", apikey.RequireScope(apikey.ScopeAdminTenants))
+	tenants.POST("", h.createTenant)
+	tenants.GET("", h.listTenants)
+	tenants.GET("/:id", h.getTenant)
+	tenants.PUT("/:id", h.updateTenant)
+}
+
+func (h *handler) createTenant(ctx echo.Context) error {
+	var requestDto CreateTenantRequest
+	err := ctx.Bind(&requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body").
+			SetInternal(err)
+	}
+
+	err = ctx.Validate(requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "validation error").
+			SetInternal(err)
+	}
+
+	resp, err := h.useCase.CreateTenant(ctx.Request().Context(), requestDto)
+	if err != nil {
+		return tenantHTTPError(ctx, err, requestDto.Id, "failed to create tenant - handler")
+	}
+
+	return ctx.JSON(http.StatusCreated, resp)
+}
+
+func (h *handler) listTenants(ctx echo.Context) error {
+	tenants, err := h.useCase.ListTenants(ctx.Request().Context())
+	if err != nil {
+		return tenantHTTPError(ctx, err, "", "failed to list tenants - handler")
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"data": tenants,
+	})
+}
+
+func (h *handler) getTenant(ctx echo.Context) error {
+	tenantID := ctx.Param("id")
+
+	resp, err := h.useCase.GetTenant(ctx.Request().Context(), tenantID)
+	if err != nil {
+		return tenantHTTPError(ctx, err, tenantID, "failed to get tenant - handler")
+	}
+
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+func (h *handler) updateTenant(ctx echo.Context) error {
+	tenantID := ctx.Param("id")
+
+	var requestDto UpdateTenantRequest
+	err := ctx.Bind(&requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body").
+			SetInternal(err)
+	}
+
+	err = ctx.Validate(requestDto)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "validation error").
+			SetInternal(err)
+	}
+
+	resp, err := h.useCase.UpdateTenant(ctx.Request().Context(), tenantID, requestDto)
+	if err != nil {
+		return tenantHTTPError(ctx, err, tenantID, "failed to update tenant - handler")
+	}
+
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+func tenantHTTPError(ctx echo.Context, err error, tenantID string, logMsg string) error {
+	switch {
+	case errors.Is(err, ErrInvalidSenderConfig):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+	case errors.Is(err, ErrTenantNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, ErrTenantNotFound.Error()).SetInternal(err)
+	case errors.Is(err, ErrTenantExists):
+		return echo.NewHTTPError(http.StatusConflict, ErrTenantExists.Error()).SetInternal(err)
+	case errors.Is(err, ErrTenantScope):
+		return echo.NewHTTPError(http.StatusForbidden, ErrTenantScope.Error()).SetInternal(err)
+	}
+
+	log.Ctx(ctx.Request().Context()).Error().
+		Err(err).
+		Str("tenantId", tenantID).
+		Msg(logMsg)
+
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error()).
+		SetInternal(err)
+}
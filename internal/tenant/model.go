@@ -0,0 +1,38 @@
+package tenant
+
+import "time"
+
+// DefaultTenantID owns the messages created before tenants existed and the ones created by callers
+// which are not bound to a tenant.
+const DefaultTenantID = "default"
+
+type Tenant struct {
+	Id   string
+	Name string
+	// Sender overrides the SMS provider of the deployment for the messages of this tenant.
+	Sender *SenderConfig
+	// DailyQuota and MonthlyQuota limit how many messages the tenant may create per UTC day and month.
+	// Zero means unlimited.
+	DailyQuota   int
+	MonthlyQuota int
+	CreatedAt    *time.Time
+	UpdatedAt    *time.Time
+}
+
+type SenderConfig struct {
+	Provider       string
+	URL            string
+	TimeoutSeconds int
+	Headers        map[string]string
+	ToField        string
+	ContentField   string
+	MessageIDPath  string
+	StatePath      string
+}
+
+// Usage is the number of messages a tenant created in a quota period.
+type Usage struct {
+	Period string
+	Count  int
+	Limit  int
+}
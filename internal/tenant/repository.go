@@ -0,0 +1,20 @@
+package tenant
+
+import (
+	"context"
+	"time"
+)
+
+type Repository interface {
+	CreateTenant(ctx context.Context, tenant Tenant) (*Tenant, error)
+	// EnsureTenant creates the tenant unless it already exists, an existing tenant is left untouched.
+	EnsureTenant(ctx context.Context, tenant Tenant) error
+	GetTenant(ctx context.Context, tenantID string) (*Tenant, error)
+	ListTenants(ctx context.Context) ([]Tenant, error)
+	UpdateTenant(ctx context.Context, tenant Tenant) (*Tenant, error)
+	// ReserveUsage adds count to the usage of the period unless it would go over limit, in which case it
+	// returns ErrQuotaExceeded and changes nothing.
+	ReserveUsage(ctx context.Context, tenantID, period string, count, limit int, expireAt time.Time) error
+	ReleaseUsage(ctx context.Context, tenantID, period string, count int) error
+	GetUsage(ctx context.Context, tenantID string, periods []string) (map[string]int, error)
+}
@@ -0,0 +1,383 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jiin-yang/messageBird/internal/client/provider"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL = 30 * time.Second
+
+	// usage counters are kept a while after their period ended, so the last periods can still be looked up
+	usageRetention = 7 * 24 * time.Hour
+)
+
+type UseCase interface {
+	CreateTenant(ctx context.Context, request CreateTenantRequest) (*TenantResponse, error)
+	ListTenants(ctx context.Context) ([]TenantResponse, error)
+	GetTenant(ctx context.Context, tenantID string) (*TenantResponse, error)
+	UpdateTenant(ctx context.Context, tenantID string, request UpdateTenantRequest) (*TenantResponse, error)
+	EnsureDefaultTenant(ctx context.Context) error
+	TenantExists(ctx context.Context, tenantID string) (bool, error)
+	// Provider returns the provider the messages of the tenant are sent with.
+	Provider(ctx context.Context, tenantID string) (provider.Provider, error)
+	// ReserveQuota counts count messages against the daily and monthly quota of the tenant. The returned
+	// function gives back part of the reservation, e.g. for messages which could not be stored after all.
+	ReserveQuota(ctx context.Context, tenantID string, count int) (func(ctx context.Context, count int), error)
+}
+
+type cachedTenant struct {
+	tenant   *Tenant
+	provider provider.Provider
+	loadedAt time.Time
+}
+
+type useCase struct {
+	repo            Repository
+	defaultProvider provider.Provider
+	cacheTTL        time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedTenant
+}
+
+type NewUseCaseOptions struct {
+	Repo Repository
+	// DefaultProvider sends the messages of tenants without their own sender configuration.
+	DefaultProvider provider.Provider
+	// CacheTTL is how long a tenant is used without reloading it, so changes made on another instance
+	// are picked up. Defaults to 30 seconds.
+	CacheTTL time.Duration
+}
+
+func NewUseCase(opts *NewUseCaseOptions) UseCase {
+	cacheTTL := opts.CacheTTL
+	if cacheTTL <= 0 {
+		cacheTTL = defaultCacheTTL
+	}
+
+	return &useCase{
+		repo:            opts.Repo,
+		defaultProvider: opts.DefaultProvider,
+		cacheTTL:        cacheTTL,
+		cache:           map[string]cachedTenant{},
+	}
+}
+
+// CreateTenant is only allowed to callers which are not bound to a tenant.
+func (u *useCase) CreateTenant(ctx context.Context, request CreateTenantRequest) (*TenantResponse, error) {
+	if IDFromContext(ctx) != "" {
+		return nil, ErrTenantScope
+	}
+
+	sender, err := toSenderConfig(request.Sender)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := u.repo.CreateTenant(ctx, Tenant{
+		Id:           request.Id,
+		Name:         request.Name,
+		Sender:       sender,
+		DailyQuota:   request.DailyQuota,
+		MonthlyQuota: request.MonthlyQuota,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Ctx(ctx).Info().Str("tenantId", created.Id).Msg("Tenant created")
+
+	resp := toTenantResponse(*created, nil)
+	return &resp, nil
+}
+
+// ListTenants returns every tenant, or only its own one to a caller bound to a tenant.
+func (u *useCase) ListTenants(ctx context.Context) ([]TenantResponse, error) {
+	if callerTenantID := IDFromContext(ctx); callerTenantID != "" {
+		t, err := u.repo.GetTenant(ctx, callerTenantID)
+		if err != nil {
+			return nil, err
+		}
+		return []TenantResponse{toTenantResponse(*t, nil)}, nil
+	}
+
+	tenants, err := u.repo.ListTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]TenantResponse, 0, len(tenants))
+	for _, t := range tenants {
+		resp = append(resp, toTenantResponse(t, nil))
+	}
+	return resp, nil
+}
+
+// GetTenant also reports the usage of the current quota periods.
+func (u *useCase) GetTenant(ctx context.Context, tenantID string) (*TenantResponse, error) {
+	if err := checkCallerTenant(ctx, tenantID); err != nil {
+		return nil, err
+	}
+
+	t, err := u.repo.GetTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	periods := quotaPeriods(*t, time.Now())
+	names := make([]string, 0, len(periods))
+	for _, p := range periods {
+		names = append(names, p.name)
+	}
+	counts, err := u.repo.GetUsage(ctx, tenantID, names)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]Usage, 0, len(periods))
+	for _, p := range periods {
+		usage = append(usage, Usage{Period: p.name, Count: counts[p.name], Limit: p.limit})
+	}
+
+	resp := toTenantResponse(*t, usage)
+	return &resp, nil
+}
+
+// UpdateTenant is only allowed to callers which are not bound to a tenant, a tenant must not be able to
+// lift its own quotas or point the sender at an address of its choosing.
+func (u *useCase) UpdateTenant(ctx context.Context, tenantID string, request UpdateTenantRequest) (*TenantResponse, error) {
+	if IDFromContext(ctx) != "" {
+		return nil, ErrTenantScope
+	}
+
+	sender, err := toSenderConfig(request.Sender)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := u.repo.UpdateTenant(ctx, Tenant{
+		Id:           tenantID,
+		Name:         request.Name,
+		Sender:       sender,
+		DailyQuota:   request.DailyQuota,
+		MonthlyQuota: request.MonthlyQuota,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	delete(u.cache, tenantID)
+	u.mu.Unlock()
+
+	log.Ctx(ctx).Info().Str("tenantId", tenantID).Msg("Tenant updated")
+
+	resp := toTenantResponse(*updated, nil)
+	return &resp, nil
+}
+
+func (u *useCase) EnsureDefaultTenant(ctx context.Context) error {
+	return u.repo.EnsureTenant(ctx, Tenant{Id: DefaultTenantID, Name: "Default"})
+}
+
+func (u *useCase) TenantExists(ctx context.Context, tenantID string) (bool, error) {
+	_, err := u.load(ctx, tenantID)
+	if errors.Is(err, ErrTenantNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (u *useCase) Provider(ctx context.Context, tenantID string) (provider.Provider, error) {
+	cached, err := u.load(ctx, tenantID)
+	if errors.Is(err, ErrTenantNotFound) {
+		log.Ctx(ctx).Warn().Str("tenantId", tenantID).Msg("Tenant not found, sending with the default provider")
+		return u.defaultProvider, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cached.provider, nil
+}
+
+func (u *useCase) ReserveQuota(ctx context.Context, tenantID string, count int) (func(ctx context.Context, count int), error) {
+	cached, err := u.load(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var reserved []quotaPeriod
+	release := func(ctx context.Context, count int) {
+		if count <= 0 {
+			return
+		}
+		for _, p := range reserved {
+			if err := u.repo.ReleaseUsage(ctx, tenantID, p.name, count); err != nil {
+				log.Ctx(ctx).Error().Err(err).Str("tenantId", tenantID).Str("period", p.name).Msg("Failed to release quota")
+			}
+		}
+	}
+
+	for _, p := range quotaPeriods(*cached.tenant, time.Now()) {
+		if p.limit <= 0 {
+			continue
+		}
+		if count > p.limit {
+			release(ctx, count)
+			return nil, fmt.Errorf("%w: %s limit is %d", ErrQuotaExceeded, p.name, p.limit)
+		}
+
+		err := u.repo.ReserveUsage(ctx, tenantID, p.name, count, p.limit, p.end.Add(usageRetention))
+		if errors.Is(err, ErrQuotaExceeded) {
+			release(ctx, count)
+			return nil, fmt.Errorf("%w: %s limit is %d", ErrQuotaExceeded, p.name, p.limit)
+		}
+		if err != nil {
+			release(ctx, count)
+			return nil, err
+		}
+		reserved = append(reserved, p)
+	}
+
+	return release, nil
+}
+
+// load returns the tenant and its provider from the cache, reloading them once the cache entry is older
+// than the TTL.
+func (u *useCase) load(ctx context.Context, tenantID string) (cachedTenant, error) {
+	u.mu.Lock()
+	cached, ok := u.cache[tenantID]
+	u.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < u.cacheTTL {
+		return cached, nil
+	}
+
+	t, err := u.repo.GetTenant(ctx, tenantID)
+	if err != nil {
+		return cachedTenant{}, err
+	}
+
+	p := u.defaultProvider
+	if t.Sender != nil {
+		p, err = newProvider(*t.Sender)
+		if err != nil {
+			return cachedTenant{}, err
+		}
+	}
+
+	cached = cachedTenant{tenant: t, provider: p, loadedAt: time.Now()}
+	u.mu.Lock()
+	u.cache[tenantID] = cached
+	u.mu.Unlock()
+
+	return cached, nil
+}
+
+// checkCallerTenant hides other tenants from a caller bound to a tenant.
+func checkCallerTenant(ctx context.Context, tenantID string) error {
+	if callerTenantID := IDFromContext(ctx); callerTenantID != "" && callerTenantID != tenantID {
+		return ErrTenantNotFound
+	}
+	return nil
+}
+
+type quotaPeriod struct {
+	name  string
+	limit int
+	end   time.Time
+}
+
+// quotaPeriods returns the UTC day and month of now, named e.g. day:2025-01-31 and month:2025-01.
+func quotaPeriods(t Tenant, now time.Time) []quotaPeriod {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	return []quotaPeriod{
+		{name: "day:" + day.Format(time.DateOnly), limit: t.DailyQuota, end: day.AddDate(0, 0, 1)},
+		{name: "month:" + month.Format("2006-01"), limit: t.MonthlyQuota, end: month.AddDate(0, 1, 0)},
+	}
+}
+
+func newProvider(sender SenderConfig) (provider.Provider, error) {
+	p, err := provider.New(provider.Config{
+		Name:          sender.Provider,
+		URL:           sender.URL,
+		Timeout:       time.Duration(sender.TimeoutSeconds) * time.Second,
+		Headers:       sender.Headers,
+		ToField:       sender.ToField,
+		ContentField:  sender.ContentField,
+		MessageIDPath: sender.MessageIDPath,
+		StatePath:     sender.StatePath,
+		PublicOnly:    true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSenderConfig, err)
+	}
+	return p, nil
+}
+
+// toSenderConfig validates the sender configuration by building its provider once.
+func toSenderConfig(request *SenderConfigRequest) (*SenderConfig, error) {
+	if request == nil {
+		return nil, nil
+	}
+
+	sender := SenderConfig{
+		Provider:       request.Provider,
+		URL:            request.URL,
+		TimeoutSeconds: request.TimeoutSeconds,
+		Headers:        request.Headers,
+		ToField:        request.ToField,
+		ContentField:   request.ContentField,
+		MessageIDPath:  request.MessageIDPath,
+		StatePath:      request.StatePath,
+	}
+	if _, err := newProvider(sender); err != nil {
+		return nil, err
+	}
+	return &sender, nil
+}
+
+func toTenantResponse(t Tenant, usage []Usage) TenantResponse {
+	resp := TenantResponse{
+		Id:           t.Id,
+		Name:         t.Name,
+		DailyQuota:   t.DailyQuota,
+		MonthlyQuota: t.MonthlyQuota,
+		CreatedAt:    t.CreatedAt,
+		UpdatedAt:    t.UpdatedAt,
+	}
+
+	if t.Sender != nil {
+		headers := make([]string, 0, len(t.Sender.Headers))
+		for name := range t.Sender.Headers {
+			headers = append(headers, name)
+		}
+		sort.Strings(headers)
+
+		resp.Sender = &SenderConfigResponse{
+			Provider:       t.Sender.Provider,
+			URL:            t.Sender.URL,
+			TimeoutSeconds: t.Sender.TimeoutSeconds,
+			Headers:        headers,
+			ToField:        t.Sender.ToField,
+			ContentField:   t.Sender.ContentField,
+			MessageIDPath:  t.Sender.MessageIDPath,
+			StatePath:      t.Sender.StatePath,
+		}
+	}
+
+	for _, usg := range usage {
+		resp.Usage = append(resp.Usage, UsageResponse{Period: usg.Period, Count: usg.Count, Limit: usg.Limit})
+	}
+
+	return resp
+}
@@ -0,0 +1,161 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memoryRepo struct {
+	Repository
+
+	tenants map[string]Tenant
+	usage   map[string]int
+	updated []Tenant
+}
+
+func newMemoryRepo(tenants ...Tenant) *memoryRepo {
+	r := &memoryRepo{tenants: map[string]Tenant{}, usage: map[string]int{}}
+	for _, t := range tenants {
+		r.tenants[t.Id] = t
+	}
+	return r
+}
+
+func (r *memoryRepo) GetTenant(_ context.Context, tenantID string) (*Tenant, error) {
+	t, ok := r.tenants[tenantID]
+	if !ok {
+		return nil, ErrTenantNotFound
+	}
+	return &t, nil
+}
+
+func (r *memoryRepo) UpdateTenant(_ context.Context, t Tenant) (*Tenant, error) {
+	r.updated = append(r.updated, t)
+	r.tenants[t.Id] = t
+	return &t, nil
+}
+
+func (r *memoryRepo) ReserveUsage(_ context.Context, tenantID, period string, count, limit int, _ time.Time) error {
+	key := tenantID + "|" + period
+	if r.usage[key]+count > limit {
+		return ErrQuotaExceeded
+	}
+	r.usage[key] += count
+	return nil
+}
+
+func (r *memoryRepo) ReleaseUsage(_ context.Context, tenantID, period string, count int) error {
+	r.usage[tenantID+"|"+period] -= count
+	return nil
+}
+
+// used returns the usage of the current day and month of the tenant.
+func (r *memoryRepo) used(tenantID string) (day, month int) {
+	for key, count := range r.usage {
+		switch {
+		case strings.HasPrefix(key, tenantID+"|day:"):
+			day += count
+		case strings.HasPrefix(key, tenantID+"|month:"):
+			month += count
+		}
+	}
+	return day, month
+}
+
+func TestReserveQuota(t *testing.T) {
+	repo := newMemoryRepo(Tenant{Id: "acme", DailyQuota: 10, MonthlyQuota: 6})
+	u := NewUseCase(&NewUseCaseOptions{Repo: repo})
+	ctx := context.Background()
+
+	release, err := u.ReserveQuota(ctx, "acme", 4)
+	if err != nil {
+		t.Fatalf("ReserveQuota() = %v", err)
+	}
+	if day, month := repo.used("acme"); day != 4 || month != 4 {
+		t.Fatalf("usage = %d/%d, want 4/4", day, month)
+	}
+
+	// The day has room but the month does not, the day reservation is given back
+	if _, err := u.ReserveQuota(ctx, "acme", 3); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("ReserveQuota() over the monthly quota = %v, want ErrQuotaExceeded", err)
+	}
+	if day, month := repo.used("acme"); day != 4 || month != 4 {
+		t.Errorf("usage after a refused reservation = %d/%d, want 4/4", day, month)
+	}
+
+	release(ctx, 1)
+	if day, month := repo.used("acme"); day != 3 || month != 3 {
+		t.Errorf("usage after releasing one message = %d/%d, want 3/3", day, month)
+	}
+	release(ctx, 0)
+	if day, month := repo.used("acme"); day != 3 || month != 3 {
+		t.Errorf("usage after releasing nothing = %d/%d, want 3/3", day, month)
+	}
+
+	if _, err := u.ReserveQuota(ctx, "acme", 3); err != nil {
+		t.Errorf("ReserveQuota() after a release = %v", err)
+	}
+}
+
+func TestReserveQuotaLargerThanTheQuota(t *testing.T) {
+	repo := newMemoryRepo(Tenant{Id: "acme", DailyQuota: 5, MonthlyQuota: 100})
+	u := NewUseCase(&NewUseCaseOptions{Repo: repo})
+
+	_, err := u.ReserveQuota(context.Background(), "acme", 6)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("ReserveQuota() = %v, want ErrQuotaExceeded", err)
+	}
+	if day, month := repo.used("acme"); day != 0 || month != 0 {
+		t.Errorf("usage = %d/%d, want nothing reserved", day, month)
+	}
+}
+
+func TestReserveQuotaUnlimited(t *testing.T) {
+	repo := newMemoryRepo(Tenant{Id: "acme"})
+	u := NewUseCase(&NewUseCaseOptions{Repo: repo})
+
+	release, err := u.ReserveQuota(context.Background(), "acme", 1_000_000)
+	if err != nil {
+		t.Fatalf("ReserveQuota() = %v", err)
+	}
+	release(context.Background(), 1_000_000)
+	if len(repo.usage) != 0 {
+		t.Errorf("usage = %v, want no counters for an unlimited tenant", repo.usage)
+	}
+
+	if _, err := u.ReserveQuota(context.Background(), "unknown", 1); !errors.Is(err, ErrTenantNotFound) {
+		t.Errorf("ReserveQuota() of an unknown tenant = %v, want ErrTenantNotFound", err)
+	}
+}
+
+func TestUpdateTenant(t *testing.T) {
+	repo := newMemoryRepo(Tenant{Id: "acme", DailyQuota: 10})
+	u := NewUseCase(&NewUseCaseOptions{Repo: repo})
+	request := UpdateTenantRequest{Name: "Acme", DailyQuota: 1000}
+
+	if _, err := u.UpdateTenant(NewContext(context.Background(), "acme"), "acme", request); !errors.Is(err, ErrTenantScope) {
+		t.Errorf("UpdateTenant() by a tenant key = %v, want ErrTenantScope", err)
+	}
+	if len(repo.updated) != 0 {
+		t.Fatalf("a tenant key updated its tenant: %+v", repo.updated)
+	}
+
+	for _, url := range []string{"http://127.0.0.1:8080/sms", "http://10.0.0.5/sms", "http://169.254.169.254/latest", "http://localhost/sms", "ftp://example.com/sms"} {
+		request := UpdateTenantRequest{Name: "Acme", Sender: &SenderConfigRequest{Provider: "httpjson", URL: url}}
+		if _, err := u.UpdateTenant(context.Background(), "acme", request); !errors.Is(err, ErrInvalidSenderConfig) {
+			t.Errorf("UpdateTenant() with sender %s = %v, want ErrInvalidSenderConfig", url, err)
+		}
+	}
+
+	request.Sender = &SenderConfigRequest{Provider: "httpjson", URL: "https://sms.example.com/send"}
+	resp, err := u.UpdateTenant(context.Background(), "acme", request)
+	if err != nil {
+		t.Fatalf("UpdateTenant() by a platform key = %v", err)
+	}
+	if resp.DailyQuota != 1000 || repo.tenants["acme"].Sender == nil {
+		t.Errorf("updated tenant = %+v, want the new quota and sender", repo.tenants["acme"])
+	}
+}